@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pipeline-arch/app/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+const configUsage = `usage:
+  server config print [--format=yaml|json] [-- setting flags...]
+      print the effective configuration, with the source of each value
+      and secrets redacted
+  server config diff A B
+      compare two printed configurations, "-" reading one from stdin;
+      exits 1 when they differ
+`
+
+// runConfigCommand runs `server config print` or `server config diff` and
+// returns the exit status: 0 on success, 1 on failure or when diff finds
+// differences, and 2 on usage errors
+func runConfigCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+	switch args[0] {
+	case "print":
+		return runConfigPrint(args[1:], stdout, stderr)
+	case "diff":
+		return runConfigDiff(args[1:], stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, "unknown config command %q\n%s", args[0], configUsage)
+		return 2
+	}
+}
+
+// runConfigPrint loads the configuration as the server would, with the
+// flags after "--", and prints it. Validation problems are reported on
+// stderr but do not stop the printout, which is most useful when the
+// configuration is wrong.
+func runConfigPrint(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	format := fs.String("format", "yaml", "output format: yaml or json")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "yaml" && *format != "json" {
+		fmt.Fprintf(stderr, "unknown format %q: must be yaml or json\n", *format)
+		return 2
+	}
+
+	cfg, _, err := loadConfig(fs.Args())
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load config: %v\n", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+	}
+
+	if err := writeEffective(stdout, cfg.Effective(), *format); err != nil {
+		fmt.Fprintf(stderr, "failed to print config: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeEffective(w io.Writer, effective config.Effective, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(effective)
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(effective); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// runConfigDiff prints the settings whose values differ between two
+// printed configurations, in YAML or JSON
+func runConfigDiff(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 2 {
+		fmt.Fprint(stderr, configUsage)
+		return 2
+	}
+	a, err := readEffective(args[0])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	b, err := readEffective(args[1])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	diffs := config.DiffEffective(a, b)
+	for _, diff := range diffs {
+		fmt.Fprintf(stdout, "%s\n  %s: %s\n  %s: %s\n", diff.Key, args[0], describeValue(diff.A), args[1], describeValue(diff.B))
+	}
+	if len(diffs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readEffective reads a printed configuration from path, or from stdin
+// for "-". JSON is read as YAML, so both formats decode alike.
+func readEffective(path string) (config.Effective, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var effective config.Effective
+	if err := yaml.Unmarshal(data, &effective); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return effective, nil
+}
+
+// describeValue formats one side of a difference as its value in JSON
+// and its source
+func describeValue(v *config.Value) string {
+	if v == nil {
+		return "(missing)"
+	}
+	data, err := json.Marshal(v.Value)
+	if err != nil {
+		data = []byte(fmt.Sprint(v.Value))
+	}
+	return fmt.Sprintf("%s (%s)", data, v.Source)
+}
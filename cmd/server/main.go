@@ -2,20 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
 	"github.com/pipeline-arch/app/internal/config"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/features"
+	"github.com/pipeline-arch/app/pkg/ipacl"
 	"github.com/pipeline-arch/app/pkg/logger"
 	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/pipeline-arch/app/pkg/ratelimit"
+	"github.com/pipeline-arch/app/pkg/resilience"
+	"github.com/pipeline-arch/app/pkg/safego"
+	"github.com/pipeline-arch/app/pkg/tracing"
+	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
 )
 
@@ -26,38 +42,391 @@ import (
 // @BasePath /api/v1
 
 func main() {
-	// Initialize configuration
-	cfg, err := config.Load()
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// Initialize configuration: defaults, then CONFIG_FILE, environment
+	// variables, the secret provider and command-line flags
+	cfg, cfgOpts, err := loadConfig(os.Args[1:])
 	if err != nil {
-		panic(fmt.Sprintf("failed to load config: %v", err))
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+	// Report every configuration problem at once, before anything starts
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// Initialize logger
-	log := logger.New(cfg.LogLevel)
+	log := logger.New(cfg.LogLevel).Logger
+	logger.SetDefault(log)
 	log.Info().Str("environment", cfg.Environment).Msg("starting application")
+	for key, source := range cfg.Sources() {
+		if source.Layer != config.LayerDefault {
+			log.Debug().Str("setting", key).Str("source", source.String()).Msg("configuration override")
+		}
+	}
 
 	// Initialize metrics
 	m := metrics.New("pipeline-arch", cfg.MetricsPort)
 
+	// Feature flags, adjustable at runtime through the admin API
+	flagDefs, err := features.Load(featureDefaults(cfg), cfg.FeatureFlagsFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load feature flags")
+	}
+	flags := features.NewSet(flagDefs, func(name features.Name, enabled bool) {
+		m.RecordFeatureEvaluation(string(name), enabled)
+	})
+	features.SetDefault(flags)
+
+	// Count panics recovered in background goroutines
+	safego.SetHandler(func(ctx context.Context, p *safego.Panic) {
+		m.RecordPanic(p.Origin)
+	})
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Initialize repository (PostgreSQL)
-	// repo, err := repository.New(ctx, cfg.DatabaseURL)
-	// if err != nil {
-	// 	log.Fatal().Err(err).Msg("failed to connect to database")
-	// }
-	// defer repo.Close()
+	// Export traces when a collector is configured
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Options{
+		ServiceName: cfg.TracingServiceName,
+		Endpoint:    cfg.TracingEndpoint,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid tracing configuration")
+	}
+	if cfg.TracingEndpoint != "" {
+		log.Info().Str("endpoint", cfg.TracingEndpoint).Float64("sample_ratio", cfg.TracingSampleRatio).Msg("tracing enabled")
+	}
+
+	// IP allow and deny lists of route groups; the file is watched for
+	// changes. Unlike the other groups, no one may mark requests high
+	// priority until clients are allowed to.
+	priorityRule := ipacl.Rule{Allow: config.SplitList(cfg.PriorityAllowedCIDRs)}
+	if len(priorityRule.Allow) == 0 {
+		priorityRule.Deny = []string{"0.0.0.0/0", "::/0"}
+	}
+	ipACL, err := ipacl.New(ipacl.Config{
+		TrustedProxies: config.SplitList(cfg.TrustedProxies),
+		Groups: map[string]ipacl.Rule{
+			api.IPGroupAdmin:    {Allow: config.SplitList(cfg.AdminAllowedCIDRs), Deny: config.SplitList(cfg.AdminDeniedCIDRs)},
+			api.IPGroupInternal: {Allow: config.SplitList(cfg.InternalAllowedCIDRs), Deny: config.SplitList(cfg.InternalDeniedCIDRs)},
+			api.IPGroupPriority: priorityRule,
+		},
+	}, cfg.IPACLFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid IP ACL configuration")
+	}
+	safego.Go(ctx, func(ctx context.Context) {
+		ipACL.Watch(ctx, cfg.IPACLReloadInterval, func(err error) {
+			if err != nil {
+				log.Error().Err(err).Str("file", cfg.IPACLFile).Msg("failed to reload IP ACL file; keeping previous lists")
+				return
+			}
+			log.Info().Str("file", cfg.IPACLFile).Msg("IP ACL file reloaded")
+		})
+	})
+
+	// Users, API keys, refresh tokens and signing keys are kept in
+	// PostgreSQL when it is configured, so that every replica shares them. New connections use
+	// the running DATABASE_URL, which follows rotated credentials.
+	var db *sql.DB
+	var dbConnector *repository.DSNConnector
+	if cfg.DatabaseURL != "" {
+		dbConnector, err = repository.NewDSNConnector(cfg.DatabaseURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid DATABASE_URL")
+		}
+		db = sql.OpenDB(dbConnector)
+		defer db.Close()
+		if err := repository.Migrate(ctx, db); err != nil {
+			log.Fatal().Err(err).Msg("failed to migrate the database schema")
+		}
+	} else {
+		log.Warn().Msg("DATABASE_URL is not set; users, API keys, tokens and signing keys are kept in memory and not shared between replicas")
+	}
+
+	// Protect dependencies with breakers, retries and bulkheads; nil
+	// policies pass calls straight through
+	var dbPolicy, oidcPolicy *resilience.Policy
+	if flags.Enabled(ctx, features.CircuitBreaker) {
+		dbPolicy = newPolicy("database", 50, log, m)
+		oidcPolicy = newPolicy("oidc", 10, log, m)
+	}
+
+	// Initialize auth services
+	var users repository.UserRepository = repository.NewInMemoryUserRepository()
+	var refreshTokenRepo repository.RefreshTokenRepository = repository.NewInMemoryRefreshTokenRepository()
+	var apiKeyRepo repository.APIKeyRepository = repository.NewInMemoryAPIKeyRepository()
+	if db != nil {
+		users = repository.NewPostgresUserRepository(db, "users")
+		refreshTokenRepo = repository.NewPostgresRefreshTokenRepository(db)
+		apiKeyRepo = repository.NewPostgresAPIKeyRepository(db)
+	}
+	userRepo := repository.NewTracingUserRepository(repository.NewResilientUserRepository(users, dbPolicy))
+	apiKeySvc := services.NewAPIKeyService(repository.NewResilientAPIKeyRepository(apiKeyRepo, dbPolicy), log, m)
+
+	// Access tokens are signed with rotating ES256 keys. JWT_SECRET is only
+	// used to verify HS256 tokens issued before rotation was introduced.
+	var legacySigner *auth.HS256Signer
+	jwtSecret := []byte(cfg.JWTSecret)
+	if len(jwtSecret) > 0 {
+		legacySigner = auth.NewHS256Signer(jwtSecret)
+	} else {
+		jwtSecret = make([]byte, 32)
+		if _, err := rand.Read(jwtSecret); err != nil {
+			log.Fatal().Err(err).Msg("failed to generate JWT secret")
+		}
+	}
+	keySet := auth.NewKeySet(legacySigner)
+
+	// Every replica must sign with the same keys
+	var signingKeyRepo repository.SigningKeyRepository = repository.NewInMemorySigningKeyRepository()
+	if db != nil {
+		signingKeyRepo = repository.NewPostgresSigningKeyRepository(db)
+	}
+	signingKeySvc := services.NewSigningKeyService(
+		signingKeyRepo,
+		keySet,
+		services.SigningKeyOptions{
+			RotationInterval: time.Duration(cfg.JWTKeyRotationInterval) * time.Second,
+			RetentionPeriod:  time.Duration(cfg.AccessTokenTTL)*time.Second + time.Minute,
+		},
+		log,
+		m,
+	)
+	if err := signingKeySvc.Init(ctx); err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize signing keys")
+	}
+	safego.Go(ctx, signingKeySvc.Run)
+
+	// Revocations, idempotency keys and cached responses must be shared by
+	// all replicas, so use Redis when it is configured
+	var redisClient *redis.Client
+	var redisCredentials atomic.Pointer[redis.Options]
+	var revocations repository.RevocationStore = repository.NewInMemoryRevocationStore()
+	var idempotency repository.IdempotencyStore = repository.NewInMemoryIdempotencyStore()
+	var responseCache repository.ResponseCacheStore
+	if cfg.ResponseCacheEnabled {
+		responseCache = repository.NewInMemoryResponseCacheStore()
+	}
+	if cfg.RedisURL != "" {
+		redisOpts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid REDIS_URL")
+		}
+		// New connections authenticate with the credentials of the running
+		// REDIS_URL, which follows rotated credentials
+		redisCredentials.Store(redisOpts)
+		redisOpts.CredentialsProvider = func() (string, string) {
+			current := redisCredentials.Load()
+			return current.Username, current.Password
+		}
+		redisClient = redis.NewClient(redisOpts)
+		defer redisClient.Close()
+		revocations = repository.NewRedisRevocationStore(redisClient, "pipeline-arch:")
+		idempotency = repository.NewRedisIdempotencyStore(redisClient, "pipeline-arch:")
+		if cfg.ResponseCacheEnabled {
+			responseCache = repository.NewRedisResponseCacheStore(redisClient, "pipeline-arch:")
+		}
+	}
+
+	refreshTokens := repository.NewResilientRefreshTokenRepository(refreshTokenRepo, dbPolicy)
+	sessionSvc := services.NewSessionService(revocations, refreshTokens, time.Duration(cfg.AccessTokenTTL)*time.Second, log, m)
+
+	// Deactivating, deleting or changing the role of a user ends the
+	// user's sessions, and keys of deactivated or deleted users stop
+	// working. Changes purge cached responses showing the user.
+	userSvc := services.NewUserService(userRepo, log, m)
+	userSvc.SetSessionRevoker(sessionSvc)
+	userSvc.SetCachePurger(responseCache)
+	apiKeySvc.SetOwners(userRepo)
+
+	authSvc := services.NewAuthService(
+		userRepo,
+		refreshTokens,
+		keySet,
+		services.AuthOptions{
+			Issuer:          cfg.JWTIssuer,
+			AccessTokenTTL:  time.Duration(cfg.AccessTokenTTL) * time.Second,
+			RefreshTokenTTL: time.Duration(cfg.RefreshTokenTTL) * time.Second,
+		},
+		log,
+		m,
+	)
+
+	cacheControl, err := api.ParseRouteCacheControl(cfg.CacheControlRoutes)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid CACHE_CONTROL_ROUTES")
+	}
+
+	routeTimeouts, err := api.ParseRouteTimeouts(cfg.RouteTimeouts)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid MIDDLEWARE_ROUTE_TIMEOUTS")
+	}
+
+	corsPolicies, err := newCORSPolicies(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid CORS configuration")
+	}
+	cors, err := api.NewCORSMiddleware(corsPolicies, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid CORS configuration")
+	}
 
-	// Initialize service layer
-	// svc := services.New(repo)
+	securityPolicies, err := newSecurityPolicies(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid security header configuration")
+	}
 
 	// Initialize handlers
-	handlers := api.NewHandlers(cfg, m, log)
+	deps := &routerDeps{
+		metrics:     m,
+		handlers:    api.NewHandlers(cfg, m, log),
+		users:       api.NewUserHandlers(userSvc, log),
+		apiKeys:     api.NewAPIKeyHandlers(apiKeySvc, log),
+		auth:        api.NewAuthHandlers(authSvc, sessionSvc, log),
+		signingKeys: api.NewSigningKeyHandlers(signingKeySvc, log),
+		sessions:    api.NewSessionHandlers(sessionSvc, log),
+		features:    api.NewFeatureHandlers(flags, log),
+		sessionSvc:  sessionSvc,
+		apiKeySvc:   apiKeySvc,
+		tokens:      api.TokenVerifiers{authSvc},
+
+		idempotency:    idempotency,
+		idempotencyTTL: time.Duration(cfg.IdempotencyKeyTTL) * time.Second,
+
+		maxBodySize:    int64(cfg.MaxBodySize),
+		requestTimeout: cfg.RequestTimeout,
+		routeTimeouts:  routeTimeouts,
+
+		cors:             cors.Handler,
+		securityPolicies: securityPolicies,
+		ipACL:            ipACL,
+
+		cacheControl:     cacheControl,
+		responseCache:    responseCache,
+		responseCacheTTL: cfg.ResponseCacheTTL,
+
+		concurrencyLimit: resilience.NewAdaptiveLimit(resilience.AdaptiveLimitOptions{
+			InitialLimit: cfg.ConcurrencyLimitInitial,
+			MinLimit:     cfg.ConcurrencyLimitMin,
+			MaxLimit:     cfg.ConcurrencyLimitMax,
+			OnChange:     m.SetConcurrencyLimit,
+		}),
+	}
+	m.SetConcurrencyLimit(deps.concurrencyLimit.Limit())
+
+	// Rate limits hold across replicas only when backed by Redis. The
+	// limiters always exist so that the rate_limiting flag can be turned
+	// on at runtime.
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if redisClient != nil {
+		store = ratelimit.NewRedisStore(redisClient, "pipeline-arch:ratelimit:")
+	}
+	rateLimitRoutes, err := api.ParseRouteRateLimits(cfg.RateLimitRoutes)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid RATE_LIMIT_ROUTES")
+	}
+	deps.rateLimits = api.NewRateLimits(store, defaultRateLimit(cfg), rateLimitRoutes)
+	log.Info().Int("requests_per_minute", cfg.RateLimitRequestsPerMinute).Bool("redis", redisClient != nil).Msg("rate limiters ready")
+
+	// Live settings, including the database, Redis and JWT secrets, are
+	// applied when the configuration is reloaded on SIGHUP, a change to
+	// CONFIG_FILE or the secrets refresh; the others need a restart
+	cfgWatcher := config.NewWatcher(cfg, cfgOpts)
+	deps.config = api.NewConfigHandlers(cfgWatcher.Current)
+	cfgWatcher.Subscribe(func(old, new *config.Config) {
+		if new.LogLevel != old.LogLevel {
+			logger.SetLevel(new.LogLevel)
+		}
+	})
+	cfgWatcher.Subscribe(func(old, new *config.Config) {
+		deps.rateLimits.SetDefault(defaultRateLimit(new))
+		if new.RateLimitRoutes == old.RateLimitRoutes {
+			return
+		}
+		routes, err := api.ParseRouteRateLimits(new.RateLimitRoutes)
+		if err != nil {
+			log.Error().Err(err).Msg("invalid RATE_LIMIT_ROUTES; keeping previous route limits")
+			return
+		}
+		deps.rateLimits.SetRoutes(routes)
+	})
+	cfgWatcher.Subscribe(func(old, new *config.Config) {
+		policies, err := newCORSPolicies(new)
+		if err == nil {
+			err = cors.Update(policies)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("invalid CORS configuration; keeping previous policies")
+		}
+	})
+	cfgWatcher.Subscribe(func(old, new *config.Config) {
+		flagDefs, err := features.Load(featureDefaults(new), new.FeatureFlagsFile)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to reload feature flags; keeping previous flags")
+			return
+		}
+		flags.Replace(flagDefs)
+	})
+	cfgWatcher.Subscribe(func(old, new *config.Config) {
+		applySecrets(old, new, keySet, dbConnector, &redisCredentials, log)
+	})
+	safego.Go(ctx, func(ctx context.Context) {
+		err := cfgWatcher.Watch(ctx, func(result config.ReloadResult, err error) {
+			if err != nil {
+				m.RecordConfigReload("failure")
+				log.Error().Err(err).Msg("failed to reload configuration; keeping the running configuration")
+				return
+			}
+			m.RecordConfigReload("success")
+			for _, key := range result.Rejected {
+				log.Warn().Str("setting", key).Msg("setting needs a restart to change; keeping the running value")
+			}
+			if len(result.Changed) > 0 {
+				log.Info().Strs("settings", result.Changed).Msg("configuration reloaded")
+			}
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("failed to watch configuration")
+		}
+	})
+
+	// Initialize single sign-on when an identity provider is configured
+	if cfg.OIDCIssuerURL != "" {
+		groups, err := auth.ParseRoleMapping(cfg.OIDCRoleMapping)
+		if err != nil {
+			log.Fatal().Err(err).Msg("invalid OIDC_ROLE_MAPPING")
+		}
+		provider := auth.NewOIDCProvider(auth.OIDCConfig{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+			Audience:     cfg.OIDCAudience,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+		}, resilience.NewClient(&http.Client{Timeout: 10 * time.Second}, oidcPolicy))
+		oidcSvc := services.NewOIDCService(provider, auth.NewRoleMapper(groups, cfg.OIDCDefaultRole), userRepo, authSvc, log, m)
+		oidcSvc.SetSessionRevoker(sessionSvc)
+
+		// Derive the login state cookie key so it differs from the token key
+		mac := hmac.New(sha256.New, jwtSecret)
+		mac.Write([]byte("oidc-state"))
+		deps.oidc = api.NewOIDCHandlers(oidcSvc, mac.Sum(nil), cfg.Environment != "development", log)
+		deps.tokens = append(deps.tokens, oidcSvc)
+
+		log.Info().Str("issuer", cfg.OIDCIssuerURL).Msg("OIDC single sign-on enabled")
+	}
 
 	// Setup router
-	router := setupRouter(handlers, log)
+	router := setupRouter(deps, log)
 
 	// Initialize metrics server
 	go func() {
@@ -65,7 +434,7 @@ func main() {
 		mux.Handle("/metrics", m.Handler())
 		srv := &http.Server{
 			Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
-			Handler: mux,
+			Handler: api.IPFilter(ipACL, api.IPGroupInternal, log, m.RecordIPDenial)(mux),
 		}
 		log.Info().Int("port", cfg.MetricsPort).Msg("metrics server starting")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -74,10 +443,7 @@ func main() {
 	}()
 
 	// Create main server
-	srv := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
-		Handler: router,
-	}
+	srv := newServer(cfg, router)
 
 	// Start server in goroutine
 	go func() {
@@ -95,59 +461,347 @@ func main() {
 	log.Info().Msg("shutting down servers...")
 
 	// Graceful shutdown with timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("HTTP server forced to shutdown")
 	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to flush traces")
+	}
 
 	log.Info().Msg("servers stopped")
 }
 
-func setupRouter(h *api.Handlers, log *zerolog.Logger) *chi.Mux {
+// newServer builds the HTTP server with the limits from cfg. Requests with
+// headers over MaxHeaderSize get 431 from net/http.
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Handler:           handler,
+		MaxHeaderBytes:    cfg.MaxHeaderSize,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.IdleTimeout) * time.Second,
+	}
+}
+
+// loadConfig loads the configuration the server runs with, taking flags
+// from args. The secret provider is itself configured by the other layers,
+// so it is set up from a first load.
+func loadConfig(args []string) (*config.Config, config.Options, error) {
+	opts := config.Options{File: os.Getenv("CONFIG_FILE"), Args: args}
+	cfg, err := config.LoadWith(opts)
+	if err != nil {
+		return nil, opts, err
+	}
+	opts.Secrets, err = config.NewSecretProvider(cfg)
+	if err != nil || opts.Secrets == nil {
+		return cfg, opts, err
+	}
+	cfg, err = config.LoadWith(opts)
+	return cfg, opts, err
+}
+
+// applySecrets puts rotated secrets of a reloaded configuration to use:
+// JWT_SECRET verifies legacy tokens at once, and new database and Redis
+// connections use the new credentials. A dependency that was not
+// configured at startup, or a Redis address change, needs a restart.
+func applySecrets(old, new *config.Config, keySet *auth.KeySet, db *repository.DSNConnector, redisCredentials *atomic.Pointer[redis.Options], log *zerolog.Logger) {
+	if new.JWTSecret != old.JWTSecret {
+		var legacySigner *auth.HS256Signer
+		if new.JWTSecret != "" {
+			legacySigner = auth.NewHS256Signer([]byte(new.JWTSecret))
+		}
+		keySet.SetFallback(legacySigner)
+		log.Info().Msg("JWT_SECRET rotated")
+	}
+
+	if new.DatabaseURL != old.DatabaseURL {
+		if db == nil || new.DatabaseURL == "" {
+			log.Warn().Msg("DATABASE_URL needs a restart to be added or removed; keeping the running value")
+		} else if err := db.SetDSN(new.DatabaseURL); err != nil {
+			log.Error().Err(err).Msg("invalid DATABASE_URL; keeping the running value")
+		} else {
+			log.Info().Msg("DATABASE_URL rotated; new connections use it")
+		}
+	}
+
+	if new.RedisURL != old.RedisURL {
+		current := redisCredentials.Load()
+		next, err := redis.ParseURL(new.RedisURL)
+		switch {
+		case current == nil || new.RedisURL == "":
+			log.Warn().Msg("REDIS_URL needs a restart to be added or removed; keeping the running value")
+		case err != nil:
+			log.Error().Err(err).Msg("invalid REDIS_URL; keeping the running value")
+		case next.Addr != current.Addr || next.DB != current.DB:
+			log.Warn().Msg("REDIS_URL address needs a restart to change; keeping the running address")
+		default:
+			redisCredentials.Store(next)
+			log.Info().Msg("REDIS_URL rotated; new connections use it")
+		}
+	}
+}
+
+// featureDefaults returns the feature flags as configured by cfg, before
+// the feature flag file and FEATURE_* variables adjust them
+func featureDefaults(cfg *config.Config) []features.Flag {
+	return []features.Flag{
+		{Name: features.RateLimiting, Description: "Per-caller request rate limits", Enabled: cfg.FeatureRateLimiting},
+		{Name: features.CircuitBreaker, Description: "Circuit breakers, retries and bulkheads for dependencies; read at startup", Enabled: cfg.FeatureCircuitBreaker},
+		{Name: features.RequestLogging, Description: "Access logs for HTTP requests", Enabled: true},
+		{Name: features.LoadShedding, Description: "Reject requests beyond the adaptive concurrency limit", Enabled: cfg.FeatureLoadShedding},
+	}
+}
+
+// defaultRateLimit returns the limit of the default rate limiter
+func defaultRateLimit(cfg *config.Config) ratelimit.Limit {
+	limit := ratelimit.PerMinute(cfg.RateLimitRequestsPerMinute)
+	if cfg.RateLimitBurst > 0 {
+		limit.Burst = cfg.RateLimitBurst
+	}
+	return limit
+}
+
+// newCORSPolicies builds the default CORS policy and the per-route
+// overrides from cfg
+func newCORSPolicies(cfg *config.Config) ([]api.CORSPolicy, error) {
+	base := api.CORSPolicy{
+		AllowedOrigins:   config.SplitList(cfg.CORSAllowedOrigins),
+		AllowedMethods:   config.SplitList(cfg.CORSAllowedMethods),
+		AllowedHeaders:   config.SplitList(cfg.CORSAllowedHeaders),
+		ExposedHeaders:   config.SplitList(cfg.CORSExposedHeaders),
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           time.Duration(cfg.CORSMaxAge) * time.Second,
+	}
+	routes, err := api.ParseCORSRouteOrigins(cfg.CORSRouteOrigins, base)
+	if err != nil {
+		return nil, err
+	}
+	return append([]api.CORSPolicy{base}, routes...), nil
+}
+
+// cspReportPath receives Content-Security-Policy violation reports
+const cspReportPath = "/csp-report"
+
+// newSecurityPolicies builds the security header policies for pages and,
+// below /api and /.well-known, for the API from cfg
+func newSecurityPolicies(cfg *config.Config) ([]api.SecurityPolicy, error) {
+	pageCSP, err := api.ParseCSP(cfg.SecurityCSP)
+	if err != nil {
+		return nil, err
+	}
+	apiCSP, err := api.ParseCSP(cfg.SecurityAPICSP)
+	if err != nil {
+		return nil, err
+	}
+
+	page := api.SecurityPolicy{
+		HSTSMaxAge:                time.Duration(cfg.SecurityHSTSMaxAge) * time.Second,
+		HSTSIncludeSubdomains:     true,
+		CSP:                       pageCSP,
+		CSPReportOnly:             cfg.SecurityCSPReportOnly,
+		CSPReportURI:              cspReportPath,
+		PermissionsPolicy:         cfg.SecurityPermissionsPolicy,
+		CrossOriginOpenerPolicy:   cfg.SecurityCOOP,
+		CrossOriginEmbedderPolicy: cfg.SecurityCOEP,
+	}
+	policies := []api.SecurityPolicy{page}
+	for _, prefix := range []string{"/api", "/.well-known"} {
+		p := page
+		p.PathPrefix = prefix
+		p.CSP = apiCSP
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// newPolicy builds the resilience policy for a dependency, exporting
+// breaker state changes as metrics and log events
+func newPolicy(name string, maxConcurrent int, log *zerolog.Logger, m *metrics.Metrics) *resilience.Policy {
+	return &resilience.Policy{
+		Bulkhead: resilience.NewBulkhead(maxConcurrent, 100*time.Millisecond),
+		Retry: &resilience.RetryPolicy{
+			Budget: resilience.NewRetryBudget(0.1, 1),
+		},
+		Breaker: resilience.NewBreaker(name, resilience.BreakerOptions{
+			SlowCallDuration: 2 * time.Second,
+			OnStateChange: func(name string, from, to resilience.State) {
+				m.RecordBreakerTransition(name, from.String(), to.String(), int(to))
+				log.Warn().
+					Str("breaker", name).
+					Str("from", from.String()).
+					Str("to", to.String()).
+					Msg("circuit breaker state changed")
+			},
+		}),
+	}
+}
+
+// routerDeps groups the handlers and authenticators mounted by setupRouter
+type routerDeps struct {
+	metrics     *metrics.Metrics
+	handlers    *api.Handlers
+	users       *api.UserHandlers
+	apiKeys     *api.APIKeyHandlers
+	auth        *api.AuthHandlers
+	oidc        *api.OIDCHandlers
+	signingKeys *api.SigningKeyHandlers
+	sessions    *api.SessionHandlers
+	features    *api.FeatureHandlers
+	config      *api.ConfigHandlers
+	apiKeySvc   *services.APIKeyService
+	sessionSvc  *services.SessionService
+	tokens      api.TokenVerifiers
+
+	// Applied while the rate_limiting flag is on; nil limits disable rate
+	// limiting
+	rateLimits *api.RateLimits
+
+	idempotency    repository.IdempotencyStore
+	idempotencyTTL time.Duration
+
+	maxBodySize    int64
+	requestTimeout time.Duration
+	routeTimeouts  []api.RouteTimeout
+
+	cors func(next http.Handler) http.Handler
+
+	// Security headers by path prefix
+	securityPolicies []api.SecurityPolicy
+
+	// Trusted proxies and the allow and deny lists of route groups
+	ipACL *ipacl.ACL
+
+	// Cache-Control by path prefix, and the cache of user reads; a nil
+	// store disables caching
+	cacheControl     []api.RouteCacheControl
+	responseCache    repository.ResponseCacheStore
+	responseCacheTTL time.Duration
+
+	// Applied while the load_shedding flag is on
+	concurrencyLimit *resilience.AdaptiveLimit
+}
+
+func setupRouter(deps *routerDeps, log *zerolog.Logger) *chi.Mux {
+	h := deps.handlers
 	r := chi.NewRouter()
 
 	// Middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(30 * time.Second))
+	r.Use(api.RequestContext(log))
+	r.Use(api.Tracing)
+	r.Use(api.RealIP(deps.ipACL))
+	r.Use(api.Recovery(log, deps.metrics.RecordPanic))
 	r.Use(middleware.Heartbeat("/healthz"))
 	r.Use(middleware.Heartbeat("/readyz"))
 
+	// Request metrics; probes answered above are not counted
+	r.Use(deps.metrics.Middleware)
+
+	// Shed load beyond the adaptive concurrency limit before it queues up
+	r.Use(api.WhenEnabled(features.LoadShedding, api.LoadShedder(deps.concurrencyLimit, deps.metrics.InFlight, []string{"/healthz", "/readyz"}, deps.ipACL, log)))
+
+	r.Use(api.Timeout(deps.requestTimeout, deps.routeTimeouts))
+	r.Use(api.MaxBodySize(deps.maxBodySize))
+
 	// Logging middleware
-	r.Use(httplog.RequestLogger(
-		httplog.NewLogger("pipeline-arch", httplog.Options{
-			JSON: true,
-		}),
-	))
-
-	// CORS
-	cors := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID"},
-		ExposedHeaders:   []string{"X-Request-ID"},
-		AllowCredentials: true,
-		MaxAge:           300,
-	})
-	r.Use(cors.Handler)
+	r.Use(api.WhenEnabled(features.RequestLogging, api.RequestLogger(log)))
+
+	// CORS, with per-route policies
+	r.Use(deps.cors)
+
+	// Security headers, with stricter policies for the API
+	r.Use(api.SecurityHeaders(deps.securityPolicies))
+	r.Use(api.CacheControl(deps.cacheControl))
+
+	// Response compression
+	r.Use(api.Compression(api.CompressionOptions{}))
 
 	// Routes
 	r.Get("/", h.Index)
 	r.Get("/healthz", h.Healthz)
 	r.Get("/readyz", h.Readyz)
+	r.Get("/.well-known/jwks.json", deps.signingKeys.JWKS)
+	r.Post(cspReportPath, api.CSPReportHandler(log, deps.metrics.RecordCSPViolation))
 
 	// API v1 routes
 	r.Route("/api/v1", func(r chi.Router) {
-		// User routes
-		r.Route("/users", func(r chi.Router) {
-			r.Get("/", h.ListUsers)
-			r.Post("/", h.CreateUser)
-			r.Get("/{id}", h.GetUser)
-			r.Put("/{id}", h.UpdateUser)
-			r.Delete("/{id}", h.DeleteUser)
+		// Resolve API keys and access tokens to principals; anonymous
+		// requests pass through
+		r.Use(api.Authenticate(deps.apiKeySvc, deps.tokens, log))
+		r.Use(api.CheckRevocation(deps.sessionSvc, log))
+		r.Use(api.FeatureTargeting)
+
+		// Rate limits pick the route's limiter; by default token issuance
+		// has a stricter one against credential stuffing
+		rateLimiter := api.WhenEnabled(features.RateLimiting, api.RateLimiter(deps.rateLimits, log))
+
+		// Token issuance
+		r.Route("/auth", func(r chi.Router) {
+			r.Use(rateLimiter)
+			r.Post("/token", deps.auth.Token)
+			r.Post("/refresh", deps.auth.Refresh)
+			r.Post("/logout", deps.auth.Logout)
+
+			// Single sign-on
+			if deps.oidc != nil {
+				r.Get("/oidc/login", deps.oidc.Login)
+				r.Get("/oidc/callback", deps.oidc.Callback)
+			}
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(rateLimiter)
+			r.Use(api.Idempotency(deps.idempotency, deps.idempotencyTTL, log))
+
+			// User routes. Scopes are checked before the response cache
+			// answers; deleting users is an admin operation.
+			r.Route("/users", func(r chi.Router) {
+				r.Group(func(r chi.Router) {
+					r.Use(api.RequireScope(models.ScopeUsersRead))
+					r.Use(api.ResponseCache(deps.responseCache, deps.responseCacheTTL, log, deps.metrics.RecordCacheLookup))
+					r.Get("/", deps.users.ListUsers)
+					r.Get("/{id}", deps.users.GetUser)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(api.RequireScope(models.ScopeUsersWrite))
+					r.Post("/", deps.users.CreateUser)
+					r.Put("/{id}", deps.users.UpdateUser)
+				})
+				r.Group(func(r chi.Router) {
+					r.Use(api.IPFilter(deps.ipACL, api.IPGroupAdmin, log, deps.metrics.RecordIPDenial))
+					r.Use(api.RequireScope(models.ScopeAdmin))
+					r.Delete("/{id}", deps.users.DeleteUser)
+				})
+			})
+
+			// API key routes
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Use(api.IPFilter(deps.ipACL, api.IPGroupAdmin, log, deps.metrics.RecordIPDenial))
+				r.Use(api.RequireScope(models.ScopeAPIKeysManage))
+				r.Get("/", deps.apiKeys.ListAPIKeys)
+				r.Post("/", deps.apiKeys.CreateAPIKey)
+				r.Get("/{id}", deps.apiKeys.GetAPIKey)
+				r.Patch("/{id}", deps.apiKeys.UpdateAPIKey)
+				r.Delete("/{id}", deps.apiKeys.DeleteAPIKey)
+				r.Post("/{id}/rotate", deps.apiKeys.RotateAPIKey)
+			})
+
+			// Admin routes
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(api.IPFilter(deps.ipACL, api.IPGroupAdmin, log, deps.metrics.RecordIPDenial))
+				r.Use(api.RequireScope(models.ScopeAdmin))
+				r.Get("/signing-keys", deps.signingKeys.ListSigningKeys)
+				r.Post("/signing-keys/rotate", deps.signingKeys.RotateSigningKeys)
+				r.Delete("/users/{id}/sessions", deps.sessions.RevokeUserSessions)
+				r.Get("/features", deps.features.ListFeatures)
+				r.Put("/features/{name}", deps.features.OverrideFeature)
+				r.Delete("/features/{name}/override", deps.features.ClearFeatureOverride)
+				r.Get("/config", deps.config.GetConfig)
+			})
 		})
 
 		// Health check with detailed status
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/rs/zerolog"
+)
+
+// APIKeyHandlers contains the API key management handlers
+type APIKeyHandlers struct {
+	svc *services.APIKeyService
+	log *zerolog.Logger
+}
+
+// NewAPIKeyHandlers creates a new APIKeyHandlers instance
+func NewAPIKeyHandlers(svc *services.APIKeyService, log *zerolog.Logger) *APIKeyHandlers {
+	return &APIKeyHandlers{
+		svc: svc,
+		log: log,
+	}
+}
+
+// ListAPIKeys returns the keys visible to the caller
+func (h *APIKeyHandlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.PrincipalFromContext(r.Context())
+
+	response, err := h.svc.ListKeys(r.Context(), caller)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// CreateAPIKey creates a new key and returns its plaintext value once
+func (h *APIKeyHandlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.PrincipalFromContext(r.Context())
+
+	var req models.APIKeyCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Name == "" || len(req.Scopes) == 0 {
+		writeError(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	response, err := h.svc.CreateKey(r.Context(), caller, &req)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	// The response holds the plaintext key
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// GetAPIKey returns a single key
+func (h *APIKeyHandlers) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.PrincipalFromContext(r.Context())
+
+	response, err := h.svc.GetKey(r.Context(), caller, chi.URLParam(r, "id"))
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateAPIKey updates the name, scopes or expiry of a key
+func (h *APIKeyHandlers) UpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.PrincipalFromContext(r.Context())
+
+	var req models.APIKeyUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	response, err := h.svc.UpdateKey(r.Context(), caller, chi.URLParam(r, "id"), &req)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// DeleteAPIKey revokes a key
+func (h *APIKeyHandlers) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.PrincipalFromContext(r.Context())
+	id := chi.URLParam(r, "id")
+
+	if err := h.svc.RevokeKey(r.Context(), caller, id); err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &models.SuccessResponse{
+		Message: "API key revoked successfully",
+		Data: map[string]string{
+			"id": id,
+		},
+	})
+}
+
+// RotateAPIKey issues a replacement key. The old key stays valid for the
+// requested overlap window.
+func (h *APIKeyHandlers) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	caller, _ := auth.PrincipalFromContext(r.Context())
+
+	var req models.APIKeyRotateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+	}
+
+	overlap := services.DefaultRotationOverlap
+	if req.OverlapSeconds != nil {
+		overlap = time.Duration(*req.OverlapSeconds) * time.Second
+	}
+
+	response, err := h.svc.RotateKey(r.Context(), caller, chi.URLParam(r, "id"), overlap)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	// The response holds the plaintext key
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusCreated, response)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// APIKeyHeader is the header carrying an API key as an alternative to
+// the Authorization header
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyAuthenticator resolves a plaintext API key to a principal
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (*auth.Principal, error)
+}
+
+// TokenVerifier resolves a signed access token to a principal
+type TokenVerifier interface {
+	VerifyAccessToken(ctx context.Context, token string) (*auth.Principal, error)
+}
+
+// TokenVerifiers tries each verifier in order and accepts the token as soon
+// as one of them does. This lets locally issued tokens and tokens from an
+// external identity provider share the Authorization header.
+type TokenVerifiers []TokenVerifier
+
+// VerifyAccessToken implements TokenVerifier
+func (v TokenVerifiers) VerifyAccessToken(ctx context.Context, token string) (*auth.Principal, error) {
+	var lastErr error = errors.ErrUnauthorized
+	for _, verifier := range v {
+		principal, err := verifier.VerifyAccessToken(ctx, token)
+		if err == nil {
+			return principal, nil
+		}
+		// Only fall through on rejections, not on lookup failures
+		if errors.HTTPStatus(err) != http.StatusUnauthorized {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Authenticate creates middleware that resolves request credentials to a
+// principal. Requests without credentials pass through anonymously; use
+// RequireAuth or RequireScope to reject them. Accepted credentials are
+// `Authorization: Bearer pk_...` and `X-API-Key: pk_...` for API keys and
+// `Authorization: Bearer <jwt>` for access tokens.
+func Authenticate(keys APIKeyAuthenticator, tokens TokenVerifier, log *zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credential, ok := extractCredential(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var (
+				principal *auth.Principal
+				err       error
+			)
+			switch {
+			case auth.IsAPIKey(credential):
+				principal, err = keys.Authenticate(r.Context(), credential)
+			case tokens != nil:
+				principal, err = tokens.VerifyAccessToken(r.Context(), credential)
+			default:
+				writeUnauthorized(w, "Unsupported credential")
+				return
+			}
+			if err != nil {
+				requestLog(r, log).Debug().
+					Err(err).
+					Str("path", r.URL.Path).
+					Str("method", r.Method).
+					Msg("authentication failed")
+				if errors.HTTPStatus(err) == http.StatusUnauthorized {
+					writeUnauthorized(w, "Invalid credentials")
+					return
+				}
+				writeAppError(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// RevocationChecker reports whether a principal's token has been revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, principal *auth.Principal) (bool, error)
+}
+
+// CheckRevocation creates middleware that rejects requests whose access
+// token has been revoked. It must run after Authenticate.
+func CheckRevocation(checker RevocationChecker, log *zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			revoked, err := checker.IsRevoked(r.Context(), principal)
+			if err != nil {
+				// Fail closed: a token we cannot check may have been revoked
+				writeAppError(w, err)
+				return
+			}
+			if revoked {
+				requestLog(r, log).Info().
+					Str("user_id", principal.Subject).
+					Str("token_id", principal.TokenID).
+					Msg("rejected revoked token")
+				writeUnauthorized(w, "Token has been revoked")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAuth rejects requests that were not authenticated
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.PrincipalFromContext(r.Context()); !ok {
+			writeUnauthorized(w, "Authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects requests whose principal lacks the given scope
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				writeUnauthorized(w, "Authentication required")
+				return
+			}
+			if !principal.HasScope(scope) {
+				writeError(w, http.StatusForbidden, "Missing required scope: "+scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// extractCredential returns the bearer token or API key sent with r
+func extractCredential(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			token = strings.TrimSpace(token)
+			return token, token != ""
+		}
+	}
+	if key := strings.TrimSpace(r.Header.Get(APIKeyHeader)); key != "" {
+		return key, true
+	}
+	return "", false
+}
+
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="pipeline-arch"`)
+	writeError(w, http.StatusUnauthorized, message)
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/rs/zerolog"
+)
+
+// AuthHandlers contains the token issuance handlers
+type AuthHandlers struct {
+	svc      *services.AuthService
+	sessions *services.SessionService
+	log      *zerolog.Logger
+}
+
+// NewAuthHandlers creates a new AuthHandlers instance
+func NewAuthHandlers(svc *services.AuthService, sessions *services.SessionService, log *zerolog.Logger) *AuthHandlers {
+	return &AuthHandlers{
+		svc:      svc,
+		sessions: sessions,
+		log:      log,
+	}
+}
+
+// Token issues tokens for the password and refresh_token grants. Both JSON
+// and OAuth2 form-encoded bodies are accepted.
+func (h *AuthHandlers) Token(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeTokenRequest(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	var response *models.TokenResponse
+	switch req.GrantType {
+	case models.GrantTypePassword:
+		if req.Email == "" || req.Password == "" {
+			writeError(w, http.StatusBadRequest, "Missing required fields")
+			return
+		}
+		response, err = h.svc.Login(r.Context(), req.Email, req.Password)
+	case models.GrantTypeRefreshToken:
+		if req.RefreshToken == "" {
+			writeError(w, http.StatusBadRequest, "Missing required fields")
+			return
+		}
+		response, err = h.svc.Refresh(r.Context(), req.RefreshToken)
+	default:
+		writeError(w, http.StatusBadRequest, "Unsupported grant type")
+		return
+	}
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Refresh rotates a refresh token
+func (h *AuthHandlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeTokenRequest(r)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "Missing refresh token")
+		return
+	}
+
+	response, err := h.svc.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Logout revokes the refresh token family of the presented token
+func (h *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	var req models.LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "Missing refresh token")
+		return
+	}
+
+	if err := h.svc.Logout(r.Context(), req.RefreshToken); err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	// Also end the access token the request was made with, if any
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		if err := h.sessions.RevokeToken(r.Context(), principal); err != nil {
+			writeAppError(w, err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeTokenRequest(r *http.Request) (*models.TokenRequest, error) {
+	req := &models.TokenRequest{}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		req.GrantType = r.PostForm.Get("grant_type")
+		req.Email = r.PostForm.Get("username")
+		if req.Email == "" {
+			req.Email = r.PostForm.Get("email")
+		}
+		req.Password = r.PostForm.Get("password")
+		req.RefreshToken = r.PostForm.Get("refresh_token")
+		return req, nil
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
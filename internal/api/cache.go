@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/rs/zerolog"
+)
+
+// SurrogateKeyHeader lists the surrogate keys of a response, separated by
+// spaces. ResponseCache stores only responses that have them.
+const SurrogateKeyHeader = "Surrogate-Key"
+
+// maxCachedResponseSize is the largest response body the response cache
+// stores
+const maxCachedResponseSize = 1 << 20
+
+// cachedHeaders are the response headers stored with a cached response.
+// The rest are set again by middleware when the response is served.
+var cachedHeaders = []string{"Content-Type", "ETag", "Last-Modified", SurrogateKeyHeader}
+
+// RouteCacheControl sets the Cache-Control of GET responses below a path
+// prefix
+type RouteCacheControl struct {
+	Prefix       string
+	CacheControl string
+}
+
+// ParseRouteCacheControl parses a semicolon-separated list of
+// prefix=directives pairs such as
+// "/api/v1/users=private, no-cache;/api/v1/status=no-store"
+func ParseRouteCacheControl(s string) ([]RouteCacheControl, error) {
+	var routes []RouteCacheControl
+	for _, pair := range strings.Split(s, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(pair, "=")
+		prefix = strings.TrimSpace(prefix)
+		value = strings.TrimSpace(value)
+		if !ok || !strings.HasPrefix(prefix, "/") || value == "" {
+			return nil, fmt.Errorf("invalid route cache control %q: expected /prefix=directives", pair)
+		}
+		routes = append(routes, RouteCacheControl{Prefix: prefix, CacheControl: value})
+	}
+	return routes, nil
+}
+
+// CacheControl creates middleware that sets the Cache-Control of the
+// longest matching route prefix on GET and HEAD requests. Handlers may
+// replace it.
+func CacheControl(routes []RouteCacheControl) func(next http.Handler) http.Handler {
+	routes = append([]RouteCacheControl(nil), routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				for _, route := range routes {
+					if matchesPrefix(r.URL.Path, route.Prefix) {
+						w.Header().Set("Cache-Control", route.CacheControl)
+						break
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeCacheable writes data like writeJSON with its validators and
+// surrogate keys, or 304 when the request's If-None-Match or
+// If-Modified-Since shows that the client's copy is current. A zero
+// lastModified leaves Last-Modified out.
+func writeCacheable(w http.ResponseWriter, r *http.Request, data interface{}, etag string, lastModified time.Time, surrogateKeys ...string) {
+	header := w.Header()
+	header.Set("ETag", etag)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if len(surrogateKeys) > 0 {
+		header.Set(SurrogateKeyHeader, strings.Join(surrogateKeys, " "))
+	}
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// notModified evaluates the preconditions of a GET or HEAD request as RFC
+// 9110 section 13.2.2 orders them: If-Modified-Since is only considered
+// without If-None-Match
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagListMatches(inm, etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+// etagListMatches reports whether an If-None-Match list matches etag by
+// weak comparison
+func etagListMatches(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseCache creates middleware that serves GET requests from store.
+// Responses are stored for ttl when they are 200 OK and carry surrogate
+// keys, under their path and query; they must not depend on the caller.
+// Cached responses honour conditional requests. Each lookup is passed to
+// onLookup, when not nil, as "hit" or "miss". Store errors are logged and
+// the request is served normally. A nil store disables the middleware.
+func ResponseCache(store repository.ResponseCacheStore, ttl time.Duration, log *zerolog.Logger, onLookup func(result string)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if store == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.URL.Path
+			if query := r.URL.Query(); len(query) > 0 {
+				key += "?" + query.Encode()
+			}
+
+			cached, err := store.Get(r.Context(), key)
+			if err != nil {
+				requestLog(r, log).Error().Err(err).Str("path", r.URL.Path).Msg("Error reading response cache")
+			}
+			if cached != nil {
+				if onLookup != nil {
+					onLookup("hit")
+				}
+				serveCached(w, r, cached)
+				return
+			}
+			if onLookup != nil {
+				onLookup("miss")
+			}
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+
+			next.ServeHTTP(ww, r)
+
+			surrogateKeys := strings.Fields(w.Header().Get(SurrogateKeyHeader))
+			if ww.Status() != http.StatusOK || len(surrogateKeys) == 0 || buf.Len() > maxCachedResponseSize {
+				return
+			}
+			resp := &models.CachedResponse{
+				StatusCode: http.StatusOK,
+				Header:     make(map[string][]string, len(cachedHeaders)),
+				Body:       buf.Bytes(),
+				Keys:       surrogateKeys,
+			}
+			for _, name := range cachedHeaders {
+				if values := w.Header().Values(name); len(values) > 0 {
+					resp.Header[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+			// Store the response even if the client has gone away
+			if err := store.Set(context.WithoutCancel(r.Context()), key, resp, ttl); err != nil {
+				requestLog(r, log).Error().Err(err).Str("path", r.URL.Path).Msg("Error storing cached response")
+			}
+		})
+	}
+}
+
+func serveCached(w http.ResponseWriter, r *http.Request, cached *models.CachedResponse) {
+	header := w.Header()
+	for name, values := range cached.Header {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+	var lastModified time.Time
+	if value := header.Get("Last-Modified"); value != "" {
+		lastModified, _ = http.ParseTime(value)
+	}
+	if notModified(r, header.Get("ETag"), lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(cached.Body)))
+	w.WriteHeader(cached.StatusCode)
+	w.Write(cached.Body)
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionMinSize is the smallest response body worth compressing
+const DefaultCompressionMinSize = 1024
+
+// DefaultCompressibleTypes are the media types compressed by default.
+// Entries ending in /* match every subtype.
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Supported content codings in order of preference
+var encodings = []string{"zstd", "br", "gzip"}
+
+// CompressionOptions configures the Compression middleware. Zero values
+// select the defaults.
+type CompressionOptions struct {
+	// MinSize is the smallest body in bytes that is compressed
+	MinSize int
+
+	// ContentTypes lists the media types that are compressed
+	ContentTypes []string
+}
+
+// encoder is implemented by the gzip, brotli and zstd writers
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+type compressor struct {
+	minSize int
+	types   map[string]bool
+	pools   map[string]*sync.Pool
+}
+
+// Compression creates middleware that compresses responses with zstd,
+// brotli or gzip as negotiated by Accept-Encoding. Bodies are buffered
+// until MinSize bytes are written so small responses are sent as is;
+// flushing starts compression immediately so streaming responses keep
+// working. Responses that already carry a Content-Encoding, partial
+// content and Range requests are never compressed.
+func Compression(opts CompressionOptions) func(next http.Handler) http.Handler {
+	c := &compressor{
+		minSize: opts.MinSize,
+		types:   make(map[string]bool),
+		pools: map[string]*sync.Pool{
+			"gzip": {New: func() interface{} {
+				w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+				return w
+			}},
+			"br": {New: func() interface{} {
+				return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+			}},
+			"zstd": {New: func() interface{} {
+				w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+				return w
+			}},
+		},
+	}
+	if c.minSize <= 0 {
+		c.minSize = DefaultCompressionMinSize
+	}
+	types := opts.ContentTypes
+	if len(types) == 0 {
+		types = DefaultCompressibleTypes
+	}
+	for _, t := range types {
+		c.types[strings.ToLower(t)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the supported coding with the highest q-value,
+// preferring zstd, then brotli, then gzip on ties. It returns "" when the
+// client accepts none of them.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = q
+		} else {
+			accepted[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range encodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response with these headers may be
+// compressed. An unknown content type is not compressible.
+func (c *compressor) compressible(header http.Header, status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	return c.allowedType(header.Get("Content-Type"))
+}
+
+func (c *compressor) allowedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if c.types[mediaType] {
+		return true
+	}
+	if i := strings.IndexByte(mediaType, '/'); i > 0 {
+		return c.types[mediaType[:i]+"/*"]
+	}
+	return false
+}
+
+func (c *compressor) get(encoding string, w io.Writer) encoder {
+	enc := c.pools[encoding].Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func (c *compressor) put(encoding string, enc encoder) {
+	c.pools[encoding].Put(enc)
+}
+
+// compressWriter buffers the start of a response until it knows whether
+// compressing it is worthwhile
+type compressWriter struct {
+	http.ResponseWriter
+	c        *compressor
+	encoding string
+	status   int
+	started  bool
+	buf      []byte
+
+	// enc is set once the response is being compressed
+	enc encoder
+}
+
+// WriteHeader records the status; headers are sent once the body is
+// known to be compressible or not
+func (w *compressWriter) WriteHeader(status int) {
+	if w.started || w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+
+	// Skip buffering when the headers already rule out compression
+	header := w.Header()
+	if header.Get("Content-Type") != "" && !w.c.compressible(header, status) {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.started {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.c.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush starts compression regardless of size so streamed chunks reach the
+// client as soon as the handler flushes them
+func (w *compressWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.started {
+		if err := w.start(true); err != nil {
+			return
+		}
+	}
+	if w.enc != nil {
+		if err := w.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start sends the headers and any buffered body, compressing when compress
+// is set and the response qualifies
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if compress && w.c.compressible(header, w.status) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		// The encoded body is no longer byte-for-byte the tagged one
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.c.get(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close sends responses that stayed below the size threshold and finishes
+// the compressed stream
+func (w *compressWriter) close() error {
+	if !w.started {
+		if w.status == 0 {
+			return nil
+		}
+		return w.start(false)
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.c.put(w.encoding, w.enc)
+	w.enc = nil
+	return err
+}
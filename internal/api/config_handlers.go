@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pipeline-arch/app/internal/config"
+)
+
+// ConfigHandlers contains the configuration admin handlers
+type ConfigHandlers struct {
+	current func() *config.Config
+}
+
+// NewConfigHandlers creates a new ConfigHandlers instance. current returns
+// the running configuration, which changes when it is reloaded.
+func NewConfigHandlers(current func() *config.Config) *ConfigHandlers {
+	return &ConfigHandlers{current: current}
+}
+
+// GetConfig returns the effective configuration with the source of each
+// value; secrets are redacted
+func (h *ConfigHandlers) GetConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, h.current().Effective())
+}
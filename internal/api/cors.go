@@ -0,0 +1,320 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/config"
+	"github.com/rs/zerolog"
+)
+
+// CORSPolicy is the cross-origin policy for requests below PathPrefix.
+// AllowedOrigins holds exact origins such as "https://example.com",
+// wildcard-subdomain patterns such as "https://*.example.com", or "*" for
+// any origin. An empty list allows no cross-origin requests.
+type CORSPolicy struct {
+	PathPrefix       string
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Validate checks the origin patterns and refuses a wildcard origin
+// together with credentials, which browsers reject
+func (p CORSPolicy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("CORS policy for %q: wildcard origin \"*\" cannot be combined with credentials", p.prefix())
+			}
+			continue
+		}
+		if err := validateOriginPattern(origin); err != nil {
+			return fmt.Errorf("CORS policy for %q: %w", p.prefix(), err)
+		}
+	}
+	return nil
+}
+
+func (p CORSPolicy) prefix() string {
+	if p.PathPrefix == "" {
+		return "/"
+	}
+	return p.PathPrefix
+}
+
+// ParseCORSRouteOrigins parses per-route origin overrides such as
+// "/api/v1/admin=https://admin.example.com,https://*.ops.example.com;/.well-known=*"
+// into copies of base. Routes are separated by semicolons and origins by
+// commas; an empty origin list shuts the route to cross-origin requests.
+func ParseCORSRouteOrigins(s string, base CORSPolicy) ([]CORSPolicy, error) {
+	var policies []CORSPolicy
+	for _, route := range strings.Split(s, ";") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		prefix, origins, ok := strings.Cut(route, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid CORS route %q: expected /prefix=origin,...", route)
+		}
+		policy := base
+		policy.PathPrefix = prefix
+		policy.AllowedOrigins = config.SplitList(origins)
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// CORS creates middleware that applies the policy with the longest
+// matching PathPrefix to each request. Preflight requests are answered
+// here with 204; rejected preflights get no CORS headers, so the browser
+// blocks the actual request, and the reason is logged at debug level.
+// Policies are validated up front.
+func CORS(policies []CORSPolicy, log *zerolog.Logger) (func(next http.Handler) http.Handler, error) {
+	c, err := NewCORSMiddleware(policies, log)
+	if err != nil {
+		return nil, err
+	}
+	return c.Handler, nil
+}
+
+// CORSMiddleware is CORS with policies that can be replaced while the
+// server runs
+type CORSMiddleware struct {
+	policies atomic.Pointer[[]*corsPolicy]
+	log      *zerolog.Logger
+}
+
+// NewCORSMiddleware creates CORS middleware applying policies
+func NewCORSMiddleware(policies []CORSPolicy, log *zerolog.Logger) (*CORSMiddleware, error) {
+	c := &CORSMiddleware{log: log}
+	if err := c.Update(policies); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Update validates policies and applies them to the following requests.
+// Invalid policies are refused and the current ones stay in place.
+func (c *CORSMiddleware) Update(policies []CORSPolicy) error {
+	compiled := make([]*corsPolicy, 0, len(policies))
+	for _, p := range policies {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		compiled = append(compiled, compileCORSPolicy(p))
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return len(compiled[i].prefix) > len(compiled[j].prefix)
+	})
+	c.policies.Store(&compiled)
+	return nil
+}
+
+// Handler is the middleware function
+func (c *CORSMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var policy *corsPolicy
+		for _, p := range *c.policies.Load() {
+			if matchesPrefix(r.URL.Path, p.prefix) {
+				policy = p
+				break
+			}
+		}
+
+		origin := r.Header.Get("Origin")
+		if policy == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if reason := policy.preflight(w, r, origin); reason != "" {
+				requestLog(r, c.log).Debug().
+					Str("origin", origin).
+					Str("path", r.URL.Path).
+					Str("policy", policy.prefix).
+					Str("reason", reason).
+					Msg("CORS preflight rejected")
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		policy.actual(w, origin)
+		next.ServeHTTP(w, r)
+	})
+}
+
+type corsPolicy struct {
+	prefix           string
+	anyOrigin        bool
+	origins          map[string]bool
+	patterns         []originPattern
+	methods          map[string]bool
+	anyHeader        bool
+	headers          map[string]bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// originPattern matches origins of the form prefix + subdomains + suffix,
+// e.g. "https://" + "api.eu" + ".example.com"
+type originPattern struct {
+	prefix string
+	suffix string
+}
+
+func compileCORSPolicy(p CORSPolicy) *corsPolicy {
+	c := &corsPolicy{
+		prefix:           p.prefix(),
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		exposedHeaders:   strings.Join(p.ExposedHeaders, ", "),
+		allowCredentials: p.AllowCredentials,
+	}
+	for _, origin := range p.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "*"):
+			prefix, suffix, _ := strings.Cut(origin, "*")
+			c.patterns = append(c.patterns, originPattern{prefix: prefix, suffix: suffix})
+		default:
+			c.origins[origin] = true
+		}
+	}
+	for _, method := range p.AllowedMethods {
+		c.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range p.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+			continue
+		}
+		c.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	if p.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(p.MaxAge.Seconds()))
+	}
+	return c
+}
+
+func (c *corsPolicy) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+	for _, p := range c.patterns {
+		if len(origin) <= len(p.prefix)+len(p.suffix) ||
+			!strings.HasPrefix(origin, p.prefix) || !strings.HasSuffix(origin, p.suffix) {
+			continue
+		}
+		// The wildcard stands for subdomain labels only, not a port,
+		// path or userinfo
+		if !strings.ContainsAny(origin[len(p.prefix):len(origin)-len(p.suffix)], ":/@") {
+			return true
+		}
+	}
+	return false
+}
+
+// setOrigin writes the headers shared by preflight and actual responses
+func (c *corsPolicy) setOrigin(header http.Header, origin string) {
+	if c.anyOrigin && !c.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflight answers a preflight request and returns why it was rejected,
+// or "" when it was allowed
+func (c *corsPolicy) preflight(w http.ResponseWriter, r *http.Request, origin string) string {
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	if !c.allowOrigin(origin) {
+		return "origin not allowed"
+	}
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !c.methods[method] {
+		return fmt.Sprintf("method %s not allowed", method)
+	}
+	requested := config.SplitList(r.Header.Get("Access-Control-Request-Headers"))
+	if !c.anyHeader {
+		for _, h := range requested {
+			if !c.headers[http.CanonicalHeaderKey(h)] {
+				return fmt.Sprintf("header %s not allowed", h)
+			}
+		}
+	}
+
+	c.setOrigin(header, origin)
+	header.Set("Access-Control-Allow-Methods", method)
+	if len(requested) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if c.maxAge != "" {
+		header.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	return ""
+}
+
+func (c *corsPolicy) actual(w http.ResponseWriter, origin string) {
+	header := w.Header()
+	if !c.anyOrigin || c.allowCredentials {
+		header.Add("Vary", "Origin")
+	}
+	if !c.allowOrigin(origin) {
+		return
+	}
+	c.setOrigin(header, origin)
+	if c.exposedHeaders != "" {
+		header.Set("Access-Control-Expose-Headers", c.exposedHeaders)
+	}
+}
+
+// validateOriginPattern accepts scheme://host[:port], where the host may
+// start with a "*." wildcard label
+func validateOriginPattern(origin string) error {
+	host := origin
+	wildcard := strings.Contains(origin, "*")
+	if wildcard {
+		if strings.Count(origin, "*") > 1 || !strings.Contains(origin, "://*.") {
+			return fmt.Errorf("invalid origin pattern %q: the wildcard must be the leading host label", origin)
+		}
+		host = strings.Replace(origin, "*", "wildcard", 1)
+	}
+	u, err := url.Parse(host)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid origin %q: expected scheme://host[:port]", origin)
+	}
+	if u.Path == "/" {
+		return fmt.Errorf("invalid origin %q: origins have no trailing slash", origin)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/features"
+	"github.com/rs/zerolog"
+)
+
+// TenantHeader names the tenant that feature flags are evaluated for
+const TenantHeader = "X-Tenant-ID"
+
+// FeatureTargeting creates middleware that records who feature flags are
+// evaluated for: the authenticated principal and the tenant from
+// TenantHeader. It must run after Authenticate.
+func FeatureTargeting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := features.Target{Tenant: r.Header.Get(TenantHeader)}
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			target.Subject = principal.Subject
+			target.Role = principal.Role
+		}
+		next.ServeHTTP(w, r.WithContext(features.WithTarget(r.Context(), target)))
+	})
+}
+
+// WhenEnabled applies middleware only to requests for which the flag is on
+func WhenEnabled(name features.Name, middleware func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if features.Enabled(r.Context(), name) {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FeatureHandlers contains the feature flag admin handlers
+type FeatureHandlers struct {
+	flags *features.Set
+	log   *zerolog.Logger
+}
+
+// NewFeatureHandlers creates a new FeatureHandlers instance
+func NewFeatureHandlers(flags *features.Set, log *zerolog.Logger) *FeatureHandlers {
+	return &FeatureHandlers{
+		flags: flags,
+		log:   log,
+	}
+}
+
+// ListFeatures returns every flag with its targeting rules and override
+func (h *FeatureHandlers) ListFeatures(w http.ResponseWriter, r *http.Request) {
+	states := h.flags.List()
+	response := &models.FeatureFlagListResponse{
+		Flags: make([]*models.FeatureFlagResponse, 0, len(states)),
+	}
+	for _, state := range states {
+		response.Flags = append(response.Flags, featureResponse(state))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// OverrideFeature turns the flag in the URL on or off for everyone
+func (h *FeatureHandlers) OverrideFeature(w http.ResponseWriter, r *http.Request) {
+	var req models.FeatureFlagOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+
+	name := features.Name(chi.URLParam(r, "name"))
+	if err := h.flags.Override(name, *req.Enabled); err != nil {
+		writeError(w, http.StatusNotFound, "Feature flag not found")
+		return
+	}
+	h.logChange(r, name, req.Enabled)
+	h.writeFeature(w, name)
+}
+
+// ClearFeatureOverride returns the flag in the URL to its targeting rules
+func (h *FeatureHandlers) ClearFeatureOverride(w http.ResponseWriter, r *http.Request) {
+	name := features.Name(chi.URLParam(r, "name"))
+	if err := h.flags.ClearOverride(name); err != nil {
+		writeError(w, http.StatusNotFound, "Feature flag not found")
+		return
+	}
+	h.logChange(r, name, nil)
+	h.writeFeature(w, name)
+}
+
+func (h *FeatureHandlers) writeFeature(w http.ResponseWriter, name features.Name) {
+	state, ok := h.flags.Get(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Feature flag not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, featureResponse(state))
+}
+
+func (h *FeatureHandlers) logChange(r *http.Request, name features.Name, override *bool) {
+	event := requestLog(r, h.log).Info().Str("flag", string(name))
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		event = event.Str("by", principal.Subject)
+	}
+	if override != nil {
+		event.Bool("override", *override).Msg("feature flag overridden")
+		return
+	}
+	event.Msg("feature flag override cleared")
+}
+
+func featureResponse(state features.State) *models.FeatureFlagResponse {
+	return &models.FeatureFlagResponse{
+		Name:        string(state.Name),
+		Description: state.Description,
+		Enabled:     state.Enabled,
+		Roles:       state.Roles,
+		Tenants:     state.Tenants,
+		Percentage:  state.Percentage,
+		Override:    state.Override,
+	}
+}
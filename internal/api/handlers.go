@@ -4,9 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
 	"github.com/pipeline-arch/app/internal/config"
 	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/errors"
 	"github.com/pipeline-arch/app/pkg/metrics"
 	"github.com/rs/zerolog"
 )
@@ -69,117 +69,6 @@ func (h *Handlers) Status(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, response)
 }
 
-// ListUsers returns a list of users
-func (h *Handlers) ListUsers(w http.ResponseWriter, r *http.Request) {
-	// In a real application, this would query the database
-	// For demo purposes, return mock data
-	page := getIntParam(r, "page", 1)
-	pageSize := getIntParam(r, "page_size", 10)
-
-	users := []*models.UserResponse{
-		{
-			ID:        "1",
-			Email:     "user1@example.com",
-			Name:      "User One",
-			Role:      "admin",
-			Active:    true,
-		},
-		{
-			ID:        "2",
-			Email:     "user2@example.com",
-			Name:      "User Two",
-			Role:      "user",
-			Active:    true,
-		},
-	}
-
-	response := &models.UserListResponse{
-		Users:      users,
-		Total:      2,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: 1,
-	}
-
-	h.metrics.IncRequest("list_users")
-	writeJSON(w, http.StatusOK, response)
-}
-
-// GetUser returns a single user by ID
-func (h *Handlers) GetUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	// In a real application, this would query the database
-	user := &models.UserResponse{
-		ID:        id,
-		Email:     "user@example.com",
-		Name:      "Test User",
-		Role:      "user",
-		Active:    true,
-	}
-
-	h.metrics.IncRequest("get_user")
-	writeJSON(w, http.StatusOK, user)
-}
-
-// CreateUser creates a new user
-func (h *Handlers) CreateUser(w http.ResponseWriter, r *http.Request) {
-	var req models.UserCreateRequest
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// Validate request
-	if req.Email == "" || req.Name == "" || req.Role == "" {
-		writeError(w, http.StatusBadRequest, "Missing required fields")
-		return
-	}
-
-	// In a real application, this would save to the database
-	user := models.NewUser(req.Email, req.Name, req.Role)
-
-	h.metrics.IncRequest("create_user")
-	writeJSON(w, http.StatusCreated, user.ToResponse())
-}
-
-// UpdateUser updates an existing user
-func (h *Handlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	var req models.UserUpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// In a real application, this would update the database
-	user := &models.UserResponse{
-		ID:        id,
-		Email:     "updated@example.com",
-		Name:      "Updated User",
-		Role:      "user",
-		Active:    true,
-	}
-
-	h.metrics.IncRequest("update_user")
-	writeJSON(w, http.StatusOK, user)
-}
-
-// DeleteUser deletes a user
-func (h *Handlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-
-	h.metrics.IncRequest("delete_user")
-	writeJSON(w, http.StatusOK, &models.SuccessResponse{
-		Message: "User deleted successfully",
-		Data: map[string]string{
-			"id": id,
-		},
-	})
-}
-
 // Helper functions
 
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
@@ -196,6 +85,11 @@ func writeError(w http.ResponseWriter, status int, message string) {
 	})
 }
 
+// writeAppError writes err using the status and message of its AppError
+func writeAppError(w http.ResponseWriter, err error) {
+	writeJSON(w, errors.HTTPStatus(err), errors.Response(err))
+}
+
 func getIntParam(r *http.Request, key string, defaultValue int) int {
 	value := r.URL.Query().Get(key)
 	if value == "" {
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// IdempotencyKeyHeader carries the client-chosen key of a retryable request
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+const (
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+
+	// maxIdempotentResponseSize is the largest response body stored for
+	// replay; larger responses are not recorded
+	maxIdempotentResponseSize = 1 << 20
+
+	// idempotencyLockTTL bounds how long a key stays reserved by a request
+	// that never completes, e.g. because the replica died
+	idempotencyLockTTL = time.Minute
+)
+
+// unreplayedHeaders are response headers that describe the original
+// exchange rather than the resource, or that outer middleware such as
+// Compression sets on the shared header map, and are not replayed
+var unreplayedHeaders = map[string]bool{
+	"Content-Encoding":    true,
+	"Content-Length":      true,
+	"Date":                true,
+	"Set-Cookie":          true,
+	"Retry-After":         true,
+	"Ratelimit-Limit":     true,
+	"Ratelimit-Remaining": true,
+	"Ratelimit-Reset":     true,
+	"Ratelimit-Policy":    true,
+	"X-Request-Id":        true,
+}
+
+// unreplayableResponse answers retries of requests whose response was
+// marked no-store
+var unreplayableResponse, _ = json.Marshal(&models.ErrorResponse{
+	Error:   "Request already processed",
+	Code:    http.StatusConflict,
+	Message: "A request with this Idempotency-Key was already processed and its response cannot be replayed",
+})
+
+// Idempotency creates middleware that makes POST and PATCH requests with
+// an Idempotency-Key header safe to retry. The first response is stored
+// with a fingerprint of the request and replayed for retries with the
+// Idempotent-Replayed header set. Reusing a key for a different request
+// gets 422, and a retry arriving while the first request is in flight gets
+// 409. Server errors are not stored so the request can be retried.
+// Responses with Cache-Control: no-store carry secrets and are never
+// stored; retries of those requests get 409 instead of a replay. Keys
+// are scoped to the authenticated principal; anonymous requests are
+// processed normally. A nil store disables the middleware.
+func Idempotency(store repository.IdempotencyStore, ttl time.Duration, log *zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if store == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			principal, ok := auth.PrincipalFromContext(r.Context())
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				writeError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeBodyError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			scopedKey := principal.Type + ":" + principal.Subject + ":" + key
+			fingerprint := requestFingerprint(r, body)
+
+			record, err := store.Begin(r.Context(), scopedKey, fingerprint, idempotencyLockTTL)
+			if err != nil {
+				requestLog(r, log).Error().Err(err).Str("path", r.URL.Path).Msg("Error reserving idempotency key")
+				writeAppError(w, errors.ErrServiceUnavailable)
+				return
+			}
+			if record != nil {
+				switch {
+				case record.Fingerprint != fingerprint:
+					writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				case !record.Completed:
+					w.Header().Set("Retry-After", "1")
+					writeError(w, http.StatusConflict, "A request with this Idempotency-Key is in progress")
+				default:
+					replay(w, record)
+				}
+				return
+			}
+
+			// Release the reservation if the handler panics
+			completed := false
+			defer func() {
+				if !completed {
+					releaseIdempotencyKey(store, scopedKey, requestLog(r, log))
+				}
+			}()
+
+			var buf bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&buf)
+
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status >= http.StatusInternalServerError {
+				return
+			}
+
+			record = &models.IdempotencyRecord{
+				Key:         scopedKey,
+				Fingerprint: fingerprint,
+				StatusCode:  status,
+				Header:      replayedHeaders(w.Header()),
+				Body:        buf.Bytes(),
+			}
+			if noStore(w.Header()) {
+				// The response carries a secret, such as a new API key
+				record.StatusCode = http.StatusConflict
+				record.Header = map[string][]string{"Content-Type": {"application/json"}}
+				record.Body = unreplayableResponse
+			} else if buf.Len() > maxIdempotentResponseSize {
+				return
+			}
+			// Store the response even if the client has gone away
+			if err := store.Complete(context.WithoutCancel(r.Context()), record, ttl); err != nil {
+				requestLog(r, log).Error().Err(err).Str("path", r.URL.Path).Msg("Error storing idempotent response")
+				return
+			}
+			completed = true
+		})
+	}
+}
+
+// requestFingerprint identifies the request a key was first used for
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+"\n"+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// noStore reports whether a response is marked Cache-Control: no-store
+func noStore(header http.Header) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func replayedHeaders(header http.Header) map[string][]string {
+	replayed := make(map[string][]string, len(header))
+	for name, values := range header {
+		if !unreplayedHeaders[http.CanonicalHeaderKey(name)] {
+			replayed[name] = values
+		}
+	}
+	return replayed
+}
+
+func replay(w http.ResponseWriter, record *models.IdempotencyRecord) {
+	header := w.Header()
+	for name, values := range record.Header {
+		header[name] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+	header.Set("Content-Length", strconv.Itoa(len(record.Body)))
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+func releaseIdempotencyKey(store repository.IdempotencyStore, key string, log *zerolog.Logger) {
+	if err := store.Release(context.Background(), key); err != nil {
+		log.Error().Err(err).Msg("Error releasing idempotency key")
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pipeline-arch/app/pkg/ipacl"
+	"github.com/rs/zerolog"
+)
+
+// Route groups guarded by IPFilter
+const (
+	// IPGroupAdmin holds the admin API
+	IPGroupAdmin = "admin"
+	// IPGroupInternal holds endpoints for operators, such as metrics
+	IPGroupInternal = "internal"
+	// IPGroupPriority holds the clients whose PriorityHeader is honored
+	IPGroupPriority = "priority"
+)
+
+// RealIP creates middleware that replaces RemoteAddr with the client
+// address resolved by acl, so that forwarding headers are only believed
+// from trusted proxies. Requests whose peer address cannot be parsed are
+// left alone.
+func RealIP(acl *ipacl.ACL) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := acl.ClientIP(r); ip.IsValid() {
+				r.RemoteAddr = ip.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// IPFilter creates middleware that answers 403 to clients whose address is
+// not allowed into group by acl. Denials are logged at warn level and
+// passed to onDeny, when not nil, with the group. It resolves the client
+// itself, so it also works on servers without RealIP.
+func IPFilter(acl *ipacl.ACL, group string, log *zerolog.Logger, onDeny func(group string)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := acl.ClientIP(r)
+			if !acl.Allowed(group, ip) {
+				requestLog(r, log).Warn().
+					Str("group", group).
+					Str("client_ip", ip.String()).
+					Str("method", r.Method).
+					Str("path", r.URL.Path).
+					Msg("request denied by IP filter")
+				if onDeny != nil {
+					onDeny(group)
+				}
+				writeError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
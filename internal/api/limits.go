@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// MaxBodySize creates middleware that limits request bodies to limit
+// bytes. Requests declaring a larger Content-Length get 413 right away;
+// reads past the limit of other bodies fail with *http.MaxBytesError,
+// which handlers report with writeBodyError. A limit of 0 disables it.
+func MaxBodySize(limit int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				writeError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeBodyError reports a failure to read or decode the request body
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	writeError(w, http.StatusBadRequest, "Invalid request body")
+}
+
+// RouteTimeout overrides the request timeout below a path prefix
+type RouteTimeout struct {
+	Prefix  string
+	Timeout time.Duration
+}
+
+// ParseRouteTimeouts parses a comma-separated list of prefix=duration
+// pairs such as "/api/v1/admin=60s,/api/v1/auth=10s"
+func ParseRouteTimeouts(s string) ([]RouteTimeout, error) {
+	var timeouts []RouteTimeout
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid route timeout %q: expected /prefix=duration", pair)
+		}
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid route timeout %q: bad duration", pair)
+		}
+		timeouts = append(timeouts, RouteTimeout{Prefix: strings.TrimSpace(prefix), Timeout: timeout})
+	}
+	return timeouts, nil
+}
+
+// Timeout creates middleware that cancels the request context after the
+// timeout of the longest matching route prefix, or defaultTimeout. Handlers
+// that give up because of the deadline and have not written a response
+// yet get 504. Unlike nesting chi's Timeout, overrides may be longer than
+// the default.
+func Timeout(defaultTimeout time.Duration, routes []RouteTimeout) func(next http.Handler) http.Handler {
+	routes = append([]RouteTimeout(nil), routes...)
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := defaultTimeout
+			for _, route := range routes {
+				if matchesPrefix(r.URL.Path, route.Prefix) {
+					timeout = route.Timeout
+					break
+				}
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			if ctx.Err() == context.DeadlineExceeded && ww.Status() == 0 {
+				writeError(w, http.StatusGatewayTimeout, "Request timed out")
+			}
+		})
+	}
+}
+
+// matchesPrefix reports whether path is prefix or lies below it
+func matchesPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -2,24 +2,29 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pipeline-arch/app/pkg/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 )
 
-// MetricsMiddleware creates middleware for request metrics
+// MetricsMiddleware creates middleware that counts requests by method,
+// route pattern and status
 func MetricsMiddleware(m *prometheus.CounterVec) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			start := time.Now()
-			m.WithLabelValues(r.Method, r.URL.Path, "200").Inc()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(ww, r)
 
-			duration := time.Since(start).Seconds()
-			_ = duration // Use for histogram metrics
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			m.WithLabelValues(r.Method, metrics.RoutePattern(r), strconv.Itoa(status)).Inc()
 		})
 	}
 }
@@ -34,7 +39,7 @@ func RequestLogger(log *zerolog.Logger) func(next http.Handler) http.Handler {
 
 			next.ServeHTTP(ww, r)
 
-			log.Info().
+			requestLog(r, log).Info().
 				Str("method", r.Method).
 				Str("path", r.URL.Path).
 				Int("status", ww.Status()).
@@ -43,57 +48,4 @@ func RequestLogger(log *zerolog.Logger) func(next http.Handler) http.Handler {
 				Msg("request completed")
 		})
 	}
-}
-
-// SecurityHeaders adds security headers to responses
-func SecurityHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "DENY")
-		w.Header().Set("X-XSS-Protection", "1; mode=block")
-		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
-		w.Header().Set("Content-Security-Policy", "default-src 'self'")
-		w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// Compression adds gzip compression to responses
-func Compression(next http.Handler) http.Handler {
-	// In a real application, use negroni/compressible or similar
-	return next
-}
-
-// RateLimiter creates rate limiting middleware
-func RateLimiter(requestsPerMinute int) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// In production, implement proper rate limiting
-			// using Redis or in-memory counter
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-// Recovery creates panic recovery middleware with logging
-func Recovery(log *zerolog.Logger) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Error().
-						Interface("error", err).
-						Str("path", r.URL.Path).
-						Str("method", r.Method).
-						Msg("panic recovered")
-
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte("Internal Server Error"))
-				}
-			}()
-
-			next.ServeHTTP(w, r)
-		})
-	}
 }
\ No newline at end of file
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// oidcStateCookie carries the login state between login and callback
+	oidcStateCookie = "oidc_state"
+
+	// oidcStateTTL bounds how long a user may take to log in at the IdP
+	oidcStateTTL = 10 * time.Minute
+)
+
+// oidcState is the per-login state bound to the browser by a signed cookie
+type oidcState struct {
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// OIDCHandlers contains the single sign-on handlers
+type OIDCHandlers struct {
+	svc           *services.OIDCService
+	cookieKey     []byte
+	secureCookies bool
+	log           *zerolog.Logger
+}
+
+// NewOIDCHandlers creates a new OIDCHandlers instance. cookieKey signs the
+// login state cookie; secureCookies should be false only for local
+// development over plain HTTP.
+func NewOIDCHandlers(svc *services.OIDCService, cookieKey []byte, secureCookies bool, log *zerolog.Logger) *OIDCHandlers {
+	return &OIDCHandlers{
+		svc:           svc,
+		cookieKey:     cookieKey,
+		secureCookies: secureCookies,
+		log:           log,
+	}
+}
+
+// Login starts an authorization code flow with PKCE and redirects the
+// browser to the identity provider
+func (h *OIDCHandlers) Login(w http.ResponseWriter, r *http.Request) {
+	state, err := auth.RandomString(24)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	nonce, err := auth.RandomString(24)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	verifier, challenge, err := auth.GeneratePKCE()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	redirectURL, err := h.svc.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	value, err := h.encodeState(&oidcState{
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	// Login and callback are mounted side by side, so scope the cookie to
+	// their shared parent path
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     path.Dir(r.URL.Path),
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// Callback completes the login when the identity provider redirects back
+func (h *OIDCHandlers) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// Always clear the state cookie; it is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     path.Dir(r.URL.Path),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if idpErr := query.Get("error"); idpErr != "" {
+		requestLog(r, h.log).Warn().
+			Str("error", idpErr).
+			Str("description", query.Get("error_description")).
+			Msg("Identity provider returned an error")
+		writeError(w, http.StatusUnauthorized, "Single sign-on failed")
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Missing login state")
+		return
+	}
+	state, ok := h.decodeState(cookie.Value)
+	if !ok || time.Now().Unix() > state.ExpiresAt {
+		writeError(w, http.StatusBadRequest, "Invalid or expired login state")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(query.Get("state"))) != 1 {
+		writeError(w, http.StatusBadRequest, "Invalid or expired login state")
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		writeError(w, http.StatusBadRequest, "Missing authorization code")
+		return
+	}
+
+	response, err := h.svc.Callback(r.Context(), code, state.Verifier, state.Nonce)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *OIDCHandlers) encodeState(state *oidcState) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + h.sign(encoded), nil
+}
+
+func (h *OIDCHandlers) decodeState(value string) (*oidcState, bool) {
+	encoded, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(h.sign(encoded))) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, false
+	}
+	state := &oidcState{}
+	if err := json.Unmarshal(payload, state); err != nil {
+		return nil, false
+	}
+	return state, true
+}
+
+func (h *OIDCHandlers) sign(encoded string) string {
+	mac := hmac.New(sha256.New, h.cookieKey)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/pkg/ratelimit"
+	"github.com/rs/zerolog"
+)
+
+// defaultLimiterName names the limiter of routes without a limit of their
+// own
+const defaultLimiterName = "default"
+
+// RouteRateLimit overrides the default rate limit below a path prefix
+type RouteRateLimit struct {
+	Prefix string
+	Limit  ratelimit.Limit
+}
+
+// ParseRouteRateLimits parses a comma-separated list of
+// prefix=requests-per-minute pairs such as "/api/v1/auth=20,/api/v1/admin=30"
+func ParseRouteRateLimits(s string) ([]RouteRateLimit, error) {
+	var routes []RouteRateLimit
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("invalid route rate limit %q: expected /prefix=requests-per-minute", pair)
+		}
+		perMinute, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || perMinute <= 0 {
+			return nil, fmt.Errorf("invalid route rate limit %q: bad request count", pair)
+		}
+		routes = append(routes, RouteRateLimit{Prefix: strings.TrimSpace(prefix), Limit: ratelimit.PerMinute(perMinute)})
+	}
+	return routes, nil
+}
+
+// RateLimits holds the limiters RateLimiter enforces: one for each route
+// prefix with a limit of its own, keeping a separate quota, and a default
+// for the other routes. Limits can be replaced while serving.
+type RateLimits struct {
+	store    ratelimit.Store
+	fallback *ratelimit.Limiter
+	routes   atomic.Pointer[[]routeLimiter]
+}
+
+type routeLimiter struct {
+	prefix  string
+	limiter *ratelimit.Limiter
+}
+
+// NewRateLimits creates rate limits on store enforcing defaultLimit, or
+// the limit of the longest matching route prefix
+func NewRateLimits(store ratelimit.Store, defaultLimit ratelimit.Limit, routes []RouteRateLimit) *RateLimits {
+	l := &RateLimits{
+		store:    store,
+		fallback: ratelimit.NewLimiter(store, defaultLimiterName, defaultLimit),
+	}
+	l.SetRoutes(routes)
+	return l
+}
+
+// SetDefault changes the limit of routes without a limit of their own
+func (l *RateLimits) SetDefault(limit ratelimit.Limit) {
+	l.fallback.SetLimit(limit)
+}
+
+// SetRoutes replaces the route limits. Limiters are named after their
+// prefix, so callers keep their state on routes that stay limited.
+func (l *RateLimits) SetRoutes(routes []RouteRateLimit) {
+	limiters := make([]routeLimiter, 0, len(routes))
+	for _, route := range routes {
+		prefix := strings.TrimSuffix(route.Prefix, "/")
+		limiters = append(limiters, routeLimiter{
+			prefix:  prefix,
+			limiter: ratelimit.NewLimiter(l.store, "route:"+prefix, route.Limit),
+		})
+	}
+	sort.SliceStable(limiters, func(i, j int) bool {
+		return len(limiters[i].prefix) > len(limiters[j].prefix)
+	})
+	l.routes.Store(&limiters)
+}
+
+// Limiter returns the limiter enforced on path
+func (l *RateLimits) Limiter(path string) *ratelimit.Limiter {
+	for _, route := range *l.routes.Load() {
+		if matchesPrefix(path, route.prefix) {
+			return route.limiter
+		}
+	}
+	return l.fallback
+}
+
+// RateLimiter creates middleware that enforces limits per caller. Callers
+// are identified by API key, then user, then client IP, so it must run
+// after Authenticate and RealIP. API keys with a quota of their own get it
+// instead of the default limit; route limits apply to every caller.
+// Responses carry the RateLimit headers from the IETF draft, and rejected
+// requests get 429 with Retry-After. Nil limits disable rate limiting.
+// Store errors fail open so that an unavailable Redis does not take the
+// API down.
+func RateLimiter(limits *RateLimits, log *zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limits == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(r)
+			limiter := limits.Limiter(r.URL.Path)
+			limit := limiter.Limit()
+			if principal, ok := auth.PrincipalFromContext(r.Context()); ok && principal.RateLimit > 0 && limiter == limits.fallback {
+				limit = ratelimit.PerMinute(principal.RateLimit)
+			}
+			result, err := limiter.AllowLimit(r.Context(), key, limit)
+			if err != nil {
+				requestLog(r, log).Warn().
+					Err(err).
+					Str("limiter", limiter.Name()).
+					Str("path", r.URL.Path).
+					Msg("Rate limit check failed, allowing request")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set("RateLimit-Policy", result.Limit.String())
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit.Capacity()))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", ceilSeconds(result.ResetAfter))
+
+			if !result.Allowed {
+				requestLog(r, log).Debug().
+					Str("limiter", limiter.Name()).
+					Str("key", key).
+					Str("path", r.URL.Path).
+					Msg("Rate limit exceeded")
+				header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+				writeError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the caller a request is counted against
+func rateLimitKey(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		if principal.KeyID != "" {
+			return "key:" + principal.KeyID
+		}
+		return "user:" + principal.Subject
+	}
+
+	// RealIP leaves a bare IP in RemoteAddr
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/pipeline-arch/app/pkg/safego"
+	"github.com/pipeline-arch/app/pkg/tracecontext"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recovery creates middleware that recovers panics in handlers. The panic
+// is logged at error level with its stack and the request and trace IDs,
+// recorded on the request span, and passed to onPanic, when not nil, with
+// the route pattern. The caller gets a 500 problem+json response unless
+// the handler had already started its response. Goroutines started with
+// safego.Go while serving a request report its route as their origin.
+// http.ErrAbortHandler is passed on so that net/http aborts the response.
+func Recovery(log *zerolog.Logger, onPanic func(route string)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := r
+			r = r.WithContext(safego.WithOrigin(r.Context(), func() string {
+				return metrics.RoutePattern(req)
+			}))
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				route := metrics.RoutePattern(r)
+				requestLog(r, log).Error().
+					Interface("panic", v).
+					Str("method", r.Method).
+					Str("path", r.URL.Path).
+					Str("route", route).
+					Str("stack", string(debug.Stack())).
+					Msg("panic recovered")
+
+				span := trace.SpanFromContext(r.Context())
+				span.RecordError(fmt.Errorf("panic: %v", v))
+				span.SetStatus(codes.Error, "panic")
+
+				if onPanic != nil {
+					onPanic(route)
+				}
+
+				if ww.Status() == 0 {
+					writeProblem(ww, r, http.StatusInternalServerError, "The server encountered an unexpected error")
+				}
+			}()
+
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// writeProblem writes an RFC 9457 problem details response carrying the
+// request and trace IDs
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	problem := &models.ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: tracecontext.RequestID(r.Context()),
+	}
+	if tc, ok := tracecontext.FromContext(r.Context()); ok {
+		problem.TraceID = tc.TraceID.String()
+	}
+
+	// Headers a middleware set for the abandoned body no longer apply
+	header := w.Header()
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	header.Set("Content-Type", models.ProblemContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
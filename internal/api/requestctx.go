@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/pipeline-arch/app/pkg/tracecontext"
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from callers
+const maxRequestIDLength = 128
+
+// RequestContext creates middleware that identifies each request. It keeps
+// a well-formed X-Request-ID from the caller or generates one, continues
+// the caller's W3C trace from traceparent and tracestate or starts a new
+// trace, and stores both in the request context together with a logger
+// derived from log that carries request_id, trace_id and span_id, for
+// logger.FromContext. The IDs are echoed in the X-Request-ID and
+// traceparent response headers. It replaces middleware.RequestID and sets
+// its context key too, so middleware.GetReqID keeps working; it must run
+// first.
+func RequestContext(log *zerolog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID(requestID) {
+				requestID = uuid.New().String()
+			}
+
+			tc, err := tracecontext.Parse(r.Header.Get(tracecontext.TraceParentHeader), r.Header.Get(tracecontext.TraceStateHeader))
+			if err != nil {
+				tc = tracecontext.New()
+			}
+
+			l := log.With().
+				Str("request_id", requestID).
+				Str("trace_id", tc.TraceID.String()).
+				Str("span_id", tc.SpanID.String()).
+				Logger()
+
+			ctx := tracecontext.WithRequestID(r.Context(), requestID)
+			ctx = tracecontext.WithTraceContext(ctx, tc)
+			ctx = context.WithValue(ctx, middleware.RequestIDKey, requestID)
+			ctx = logger.WithContext(ctx, &l)
+
+			header := w.Header()
+			header.Set(RequestIDHeader, requestID)
+			header.Set(tracecontext.TraceParentHeader, tc.TraceParent())
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// validRequestID accepts short IDs of letters, digits and -_.:/+= so that
+// caller-supplied values cannot inject anything into logs or headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestLog returns the logger RequestContext stored for r, which carries
+// the request and trace IDs, or log outside of RequestContext
+func requestLog(r *http.Request, log *zerolog.Logger) *zerolog.Logger {
+	return logger.FromContextOr(r.Context(), log)
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// CSPDirective is a Content-Security-Policy directive such as
+// script-src 'self'
+type CSPDirective struct {
+	Name   string
+	Values []string
+}
+
+// ParseCSP parses a policy such as "default-src 'self'; img-src 'self' data:"
+// into directives
+func ParseCSP(s string) ([]CSPDirective, error) {
+	var directives []CSPDirective
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		for _, c := range name {
+			if (c < 'a' || c > 'z') && c != '-' {
+				return nil, fmt.Errorf("invalid CSP directive %q", fields[0])
+			}
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate CSP directive %q", name)
+		}
+		seen[name] = true
+		directives = append(directives, CSPDirective{Name: name, Values: fields[1:]})
+	}
+	return directives, nil
+}
+
+// nonceDirectives get the per-request nonce when the policy declares them
+var nonceDirectives = map[string]bool{"script-src": true, "style-src": true}
+
+// SecurityPolicy is the set of security headers for responses below
+// PathPrefix. Empty fields leave their header out.
+type SecurityPolicy struct {
+	PathPrefix string
+
+	// HSTSMaxAge is sent in Strict-Transport-Security on requests that
+	// arrived over TLS, directly or at a proxy that set X-Forwarded-Proto
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// CSP is the Content-Security-Policy. A fresh nonce is added to its
+	// script-src and style-src directives on every request and is
+	// available to handlers through CSPNonce. In report-only mode the
+	// policy is sent as Content-Security-Policy-Report-Only. Violations
+	// are reported to CSPReportURI.
+	CSP           []CSPDirective
+	CSPReportOnly bool
+	CSPReportURI  string
+
+	PermissionsPolicy         string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+}
+
+type cspNonceKey struct{}
+
+// CSPNonce returns the nonce that SecurityHeaders added to the
+// Content-Security-Policy of the request, for use in nonce attributes of
+// inline scripts and styles, or ""
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// SecurityHeaders creates middleware that applies the policy with the
+// longest matching PathPrefix to each response. X-Content-Type-Options,
+// X-Frame-Options and Referrer-Policy are always set.
+func SecurityHeaders(policies []SecurityPolicy) func(next http.Handler) http.Handler {
+	compiled := make([]*securityPolicy, 0, len(policies))
+	for _, p := range policies {
+		compiled = append(compiled, compileSecurityPolicy(p))
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return len(compiled[i].prefix) > len(compiled[j].prefix)
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+			for _, p := range compiled {
+				if matchesPrefix(r.URL.Path, p.prefix) {
+					r = p.apply(w, r)
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type securityPolicy struct {
+	prefix      string
+	hsts        string
+	cspHeader   string
+	csp         []CSPDirective
+	nonce       bool
+	permissions string
+	coop        string
+	coep        string
+}
+
+func compileSecurityPolicy(p SecurityPolicy) *securityPolicy {
+	c := &securityPolicy{
+		prefix:      p.PathPrefix,
+		csp:         p.CSP,
+		permissions: p.PermissionsPolicy,
+		coop:        p.CrossOriginOpenerPolicy,
+		coep:        p.CrossOriginEmbedderPolicy,
+	}
+
+	if p.HSTSMaxAge > 0 {
+		c.hsts = "max-age=" + strconv.Itoa(int(p.HSTSMaxAge.Seconds()))
+		if p.HSTSIncludeSubdomains {
+			c.hsts += "; includeSubDomains"
+		}
+		if p.HSTSPreload {
+			c.hsts += "; preload"
+		}
+	}
+
+	c.cspHeader = "Content-Security-Policy"
+	if p.CSPReportOnly {
+		c.cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	if p.CSPReportURI != "" {
+		c.csp = append(append([]CSPDirective(nil), c.csp...), CSPDirective{Name: "report-uri", Values: []string{p.CSPReportURI}})
+	}
+	for _, d := range c.csp {
+		if nonceDirectives[d.Name] {
+			c.nonce = true
+		}
+	}
+	return c
+}
+
+func (c *securityPolicy) apply(w http.ResponseWriter, r *http.Request) *http.Request {
+	header := w.Header()
+	if c.hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+		header.Set("Strict-Transport-Security", c.hsts)
+	}
+	if c.permissions != "" {
+		header.Set("Permissions-Policy", c.permissions)
+	}
+	if c.coop != "" {
+		header.Set("Cross-Origin-Opener-Policy", c.coop)
+	}
+	if c.coep != "" {
+		header.Set("Cross-Origin-Embedder-Policy", c.coep)
+	}
+	if len(c.csp) == 0 {
+		return r
+	}
+
+	var nonce string
+	if c.nonce {
+		b := make([]byte, 16)
+		rand.Read(b)
+		nonce = base64.StdEncoding.EncodeToString(b)
+		r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+	}
+
+	var b strings.Builder
+	for i, d := range c.csp {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(d.Name)
+		for _, v := range d.Values {
+			b.WriteByte(' ')
+			b.WriteString(v)
+		}
+		if nonce != "" && nonceDirectives[d.Name] {
+			b.WriteString(" 'nonce-" + nonce + "'")
+		}
+	}
+	header.Set(c.cspHeader, b.String())
+	return r
+}
+
+// maxCSPReportSize bounds the body of a violation report
+const maxCSPReportSize = 64 << 10
+
+// cspViolation is the part of a violation report that is recorded. Reports
+// come as application/csp-report from report-uri, with a "csp-report"
+// object using these dashed names, or as application/reports+json from
+// the Reporting API, with camel-case names in "body".
+type cspViolation struct {
+	DocumentURI        string `json:"document-uri"`
+	BlockedURI         string `json:"blocked-uri"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	Disposition        string `json:"disposition"`
+}
+
+type cspReportBody struct {
+	DocumentURL        string `json:"documentURL"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	Disposition        string `json:"disposition"`
+}
+
+// cspDirectiveNames are the fetch and document directives of CSP level 3
+var cspDirectiveNames = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "img-src": true,
+	"font-src": true, "connect-src": true, "media-src": true, "object-src": true,
+	"frame-src": true, "child-src": true, "worker-src": true, "manifest-src": true,
+	"base-uri": true, "form-action": true, "frame-ancestors": true, "sandbox": true,
+}
+
+// CSPReportHandler returns the handler of the CSP report endpoint. Each
+// violation is logged at warn level and passed to onViolation, when not
+// nil, with the directive that was violated. Malformed reports get 400.
+func CSPReportHandler(log *zerolog.Logger, onViolation func(directive string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportSize))
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+
+		var violations []cspViolation
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/reports+json") {
+			var reports []struct {
+				Type string        `json:"type"`
+				Body cspReportBody `json:"body"`
+			}
+			if err := json.Unmarshal(body, &reports); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid CSP report")
+				return
+			}
+			for _, report := range reports {
+				if report.Type != "csp-violation" {
+					continue
+				}
+				violations = append(violations, cspViolation{
+					DocumentURI:        report.Body.DocumentURL,
+					BlockedURI:         report.Body.BlockedURL,
+					EffectiveDirective: report.Body.EffectiveDirective,
+					Disposition:        report.Body.Disposition,
+				})
+			}
+		} else {
+			var report struct {
+				Report *cspViolation `json:"csp-report"`
+			}
+			if err := json.Unmarshal(body, &report); err != nil || report.Report == nil {
+				writeError(w, http.StatusBadRequest, "Invalid CSP report")
+				return
+			}
+			violations = append(violations, *report.Report)
+		}
+
+		for _, v := range violations {
+			directive := v.EffectiveDirective
+			if directive == "" {
+				directive, _, _ = strings.Cut(v.ViolatedDirective, " ")
+			}
+			// Reports are unauthenticated, so keep made-up directives
+			// from creating metric series
+			if !cspDirectiveNames[directive] {
+				directive = "other"
+			}
+			requestLog(r, log).Warn().
+				Str("document_uri", v.DocumentURI).
+				Str("blocked_uri", v.BlockedURI).
+				Str("directive", directive).
+				Str("disposition", v.Disposition).
+				Msg("CSP violation reported")
+			if onViolation != nil {
+				onViolation(directive)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
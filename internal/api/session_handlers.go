@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/rs/zerolog"
+)
+
+// SessionHandlers contains the session administration handlers
+type SessionHandlers struct {
+	svc *services.SessionService
+	log *zerolog.Logger
+}
+
+// NewSessionHandlers creates a new SessionHandlers instance
+func NewSessionHandlers(svc *services.SessionService, log *zerolog.Logger) *SessionHandlers {
+	return &SessionHandlers{
+		svc: svc,
+		log: log,
+	}
+}
+
+// RevokeUserSessions ends every session of the user in the URL
+func (h *SessionHandlers) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if err := h.svc.RevokeUserSessions(r.Context(), chi.URLParam(r, "id"), "admin"); err != nil {
+		writeAppError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pipeline-arch/app/pkg/ipacl"
+	"github.com/pipeline-arch/app/pkg/resilience"
+	"github.com/rs/zerolog"
+)
+
+// PriorityHeader marks requests that are never shed when set to "high" by
+// a client in IPGroupPriority
+const PriorityHeader = "X-Request-Priority"
+
+// LoadShedder creates middleware that rejects requests with 503 and
+// Retry-After while more than limit.Limit() requests are in flight, rather
+// than queueing them until they time out. inFlight reports the requests
+// currently being served, including this one. Served requests feed their
+// latency back into limit; 503 and 504 responses count as drops. Requests
+// to exemptPaths, such as health probes, and requests marked high priority
+// with PriorityHeader by a client acl admits into IPGroupPriority are
+// always served. The header is ignored from other clients, and from all
+// clients when acl is nil.
+func LoadShedder(limit *resilience.AdaptiveLimit, inFlight func() int, exemptPaths []string, acl *ipacl.ACL, log *zerolog.Logger) func(next http.Handler) http.Handler {
+	exempt := make(map[string]bool, len(exemptPaths))
+	for _, path := range exemptPaths {
+		exempt[path] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		if limit == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exempt[r.URL.Path] || highPriority(r, acl) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			current := inFlight()
+			if max := limit.Limit(); current > max {
+				requestLog(r, log).Debug().
+					Int("in_flight", current).
+					Int("limit", max).
+					Str("path", r.URL.Path).
+					Msg("Request shed")
+				w.Header().Set("Retry-After", "1")
+				writeError(w, http.StatusServiceUnavailable, "Server is overloaded")
+				return
+			}
+
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			status := ww.Status()
+			dropped := status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+			limit.Observe(time.Since(start), inFlight(), dropped)
+		})
+	}
+}
+
+// highPriority reports whether r is marked high priority by a client
+// trusted to do so
+func highPriority(r *http.Request, acl *ipacl.ACL) bool {
+	if acl == nil || !strings.EqualFold(r.Header.Get(PriorityHeader), "high") {
+		return false
+	}
+	return acl.Allowed(IPGroupPriority, acl.ClientIP(r))
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/rs/zerolog"
+)
+
+// jwksMaxAge is how long verifiers may cache the JWKS. Keys are published
+// as next for a full rotation interval, which must comfortably exceed it.
+const jwksMaxAge = "max-age=300"
+
+// SigningKeyHandlers contains the JWKS and signing key admin handlers
+type SigningKeyHandlers struct {
+	svc *services.SigningKeyService
+	log *zerolog.Logger
+}
+
+// NewSigningKeyHandlers creates a new SigningKeyHandlers instance
+func NewSigningKeyHandlers(svc *services.SigningKeyService, log *zerolog.Logger) *SigningKeyHandlers {
+	return &SigningKeyHandlers{
+		svc: svc,
+		log: log,
+	}
+}
+
+// JWKS publishes the public keys used to verify access tokens
+func (h *SigningKeyHandlers) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, "+jwksMaxAge)
+	writeJSON(w, http.StatusOK, h.svc.JWKS())
+}
+
+// ListSigningKeys returns the signing keys and their lifecycle state
+func (h *SigningKeyHandlers) ListSigningKeys(w http.ResponseWriter, r *http.Request) {
+	response, err := h.svc.ListKeys(r.Context())
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RotateSigningKeys rotates the signing keys immediately
+func (h *SigningKeyHandlers) RotateSigningKeys(w http.ResponseWriter, r *http.Request) {
+	response, err := h.svc.Rotate(r.Context())
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
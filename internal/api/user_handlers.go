@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/rs/zerolog"
+)
+
+// UserHandlers contains the user management handlers
+type UserHandlers struct {
+	svc *services.UserService
+	log *zerolog.Logger
+}
+
+// NewUserHandlers creates a new UserHandlers instance
+func NewUserHandlers(svc *services.UserService, log *zerolog.Logger) *UserHandlers {
+	return &UserHandlers{
+		svc: svc,
+		log: log,
+	}
+}
+
+// ListUsers returns a page of users
+func (h *UserHandlers) ListUsers(w http.ResponseWriter, r *http.Request) {
+	page := getIntParam(r, "page", 1)
+	pageSize := getIntParam(r, "page_size", 10)
+
+	response, err := h.svc.ListUsers(r.Context(), page, pageSize)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeCacheable(w, r, response, response.ETag(), response.LastModified(), listSurrogateKeys(response)...)
+}
+
+// GetUser returns a single user by ID
+func (h *UserHandlers) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.svc.GetUser(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeCacheable(w, r, user, user.ETag(), user.UpdatedAt, models.UserSurrogateKey(user.ID))
+}
+
+// CreateUser creates a new user. Only admins may create users, since
+// every new user is given a role.
+func (h *UserHandlers) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.UserCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Email == "" || req.Name == "" || req.Role == "" {
+		writeError(w, http.StatusBadRequest, "Missing required fields")
+		return
+	}
+	caller, _ := auth.PrincipalFromContext(r.Context())
+	if !caller.HasScope(models.ScopeAdmin) {
+		writeError(w, http.StatusForbidden, "Only admins can create users")
+		return
+	}
+
+	user, err := h.svc.CreateUser(r.Context(), &req)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// UpdateUser updates an existing user. Changing the role or deactivating
+// the user ends the user's sessions. Callers without the admin scope may
+// only update their own name, email and password.
+func (h *UserHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.UserUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	caller, ok := auth.PrincipalFromContext(r.Context())
+	if !caller.HasScope(models.ScopeAdmin) {
+		if req.Role != nil || req.Active != nil {
+			writeError(w, http.StatusForbidden, "Only admins can change a user's role or active state")
+			return
+		}
+		if !ok || caller.Subject != id {
+			writeError(w, http.StatusForbidden, "Only admins can update other users")
+			return
+		}
+	}
+
+	user, err := h.svc.UpdateUser(r.Context(), id, &req)
+	if err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+// DeleteUser deletes a user and ends the user's sessions
+func (h *UserHandlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if err := h.svc.DeleteUser(r.Context(), id); err != nil {
+		writeAppError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &models.SuccessResponse{
+		Message: "User deleted successfully",
+		Data: map[string]string{
+			"id": id,
+		},
+	})
+}
+
+// listSurrogateKeys tags a user list with the list key and each user on
+// the page
+func listSurrogateKeys(list *models.UserListResponse) []string {
+	keys := []string{models.UsersSurrogateKey}
+	for _, u := range list.Users {
+		keys = append(keys, models.UserSurrogateKey(u.ID))
+	}
+	return keys
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// APIKeyPrefix marks a credential as an API key
+const APIKeyPrefix = "pk_"
+
+const (
+	apiKeyIDLength     = 8
+	apiKeySecretBytes  = 32
+	apiKeyIDCharacters = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// GenerateAPIKey creates a new random API key. It returns the plaintext key,
+// which is shown to the caller once, the visible prefix used for lookups and
+// the hash that is persisted.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, apiKeyIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key id: %w", err)
+	}
+	for i := range id {
+		id[i] = apiKeyIDCharacters[int(id[i])%len(apiKeyIDCharacters)]
+	}
+
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key secret: %w", err)
+	}
+
+	prefix = APIKeyPrefix + string(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex-encoded SHA-256 hash of a plaintext key.
+// Keys carry 256 bits of entropy, so a fast hash is sufficient.
+func HashAPIKey(key string) string {
+	return hashToken(key)
+}
+
+// ParseAPIKeyPrefix extracts the visible prefix from a plaintext key
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	if !IsAPIKey(key) {
+		return "", false
+	}
+	return key[:len(APIKeyPrefix)+apiKeyIDLength], true
+}
+
+// IsAPIKey reports whether a credential looks like an API key
+func IsAPIKey(key string) bool {
+	return strings.HasPrefix(key, APIKeyPrefix) &&
+		len(key) > len(APIKeyPrefix)+apiKeyIDLength+1 &&
+		key[len(APIKeyPrefix)+apiKeyIDLength] == '_'
+}
+
+// CompareAPIKeyHash reports whether key hashes to the stored hash in constant time
+func CompareAPIKeyHash(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) == 1
+}
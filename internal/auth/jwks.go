@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJWKSCacheTTL applies when the JWKS response has no max-age
+	defaultJWKSCacheTTL = time.Hour
+
+	// defaultJWKSRefreshInterval bounds how often an unknown kid can
+	// trigger a refetch, so forged tokens cannot be used to hammer the IdP
+	defaultJWKSRefreshInterval = 30 * time.Second
+)
+
+// RemoteKeySet fetches and caches the signing keys published at a jwks_uri.
+// Keys are refreshed when the cache expires or when a token references a
+// kid that is not cached yet, which picks up IdP key rotations.
+type RemoteKeySet struct {
+	url        string
+	client     *http.Client
+	now        func() time.Time
+	minRefresh time.Duration
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	expiresAt time.Time
+	lastFetch time.Time
+
+	// fetchMu serializes refreshes so concurrent misses share one request
+	fetchMu sync.Mutex
+}
+
+// NewRemoteKeySet creates a key set backed by the given jwks_uri
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteKeySet{
+		url:        url,
+		client:     client,
+		now:        time.Now,
+		minRefresh: defaultJWKSRefreshInterval,
+		keys:       make(map[string]crypto.PublicKey),
+	}
+}
+
+// Verify checks the token signature against the key named by its kid and
+// decodes the payload into claims. Time-based claims are not checked.
+func (s *RemoteKeySet) Verify(ctx context.Context, token string, claims interface{}) error {
+	jws, err := parseJWS(token)
+	if err != nil {
+		return err
+	}
+	// Symmetric and unsigned tokens can never come from a JWKS
+	if jws.header.Algorithm == "none" || strings.HasPrefix(jws.header.Algorithm, "HS") {
+		return fmt.Errorf("%w: algorithm %q not allowed", ErrInvalidToken, jws.header.Algorithm)
+	}
+
+	key, err := s.key(ctx, jws.header.KeyID)
+	if err != nil {
+		return err
+	}
+	if err := verifyJWSSignature(jws.header.Algorithm, key, jws.signingInput, jws.signature); err != nil {
+		return err
+	}
+	return jws.decodeClaims(claims)
+}
+
+func (s *RemoteKeySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, found, fresh := s.lookup(kid)
+	if found && fresh {
+		return key, nil
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		// Keep serving a stale key while the IdP is unreachable
+		if found {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	if key, found, _ = s.lookup(kid); !found {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+func (s *RemoteKeySet) lookup(kid string) (crypto.PublicKey, bool, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fresh := s.now().Before(s.expiresAt)
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true, fresh
+		}
+	}
+	key, found := s.keys[kid]
+	return key, found, fresh
+}
+
+func (s *RemoteKeySet) refresh(ctx context.Context) error {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	s.mu.RLock()
+	lastFetch := s.lastFetch
+	s.mu.RUnlock()
+	if s.now().Sub(lastFetch) < s.minRefresh {
+		return nil
+	}
+
+	keys, ttl, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFetch = s.now()
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	s.expiresAt = s.lastFetch.Add(ttl)
+	return nil
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i := range set.Keys {
+		jwk := &set.Keys[i]
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Skip keys we cannot use rather than failing the whole set
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+
+	return keys, cacheTTL(resp.Header.Get("Cache-Control")), nil
+}
+
+// cacheTTL extracts max-age from a Cache-Control header
+func cacheTTL(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(directive), "=")
+		if !found || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultJWKSCacheTTL
+}
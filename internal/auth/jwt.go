@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Token errors
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// clockSkew is the leeway allowed when checking time-based claims
+const clockSkew = 30 * time.Second
+
+// Claims are the JWT claims carried by access tokens
+type Claims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	// IssuedAt has microsecond precision so that a token issued right
+	// after its user's sessions were revoked is told apart from one
+	// issued before
+	IssuedAt float64 `json:"iat"`
+	ID       string  `json:"jti"`
+	Type     string  `json:"typ,omitempty"`
+	Role     string  `json:"role,omitempty"`
+	Scope    string  `json:"scope,omitempty"`
+}
+
+// NumericDate returns t as a JWT NumericDate with microsecond precision
+func NumericDate(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// IssuedTime returns the iat claim as a time
+func (c *Claims) IssuedTime() time.Time {
+	return time.UnixMicro(int64(math.Round(c.IssuedAt * 1e6)))
+}
+
+// Scopes returns the space-delimited scope claim as a slice
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Valid checks the time-based claims against now
+func (c *Claims) Valid(now time.Time) error {
+	if c.Subject == "" || c.ID == "" {
+		return ErrInvalidToken
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return ErrTokenExpired
+	}
+	if c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// TokenSigner signs and verifies access tokens
+type TokenSigner interface {
+	Sign(claims *Claims) (string, error)
+	Verify(token string) (*Claims, error)
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// HS256Signer signs and verifies HMAC-SHA256 JWTs with a shared secret
+type HS256Signer struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewHS256Signer creates a signer for the given secret
+func NewHS256Signer(secret []byte) *HS256Signer {
+	return &HS256Signer{
+		secret: secret,
+		now:    time.Now,
+	}
+}
+
+// Sign serializes and signs the claims
+func (s *HS256Signer) Sign(claims *Claims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Algorithm: "HS256", Type: "JWT"})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token claims: %w", err)
+	}
+
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	return signingInput + "." + encodeSegment(s.sign(signingInput)), nil
+}
+
+// Verify checks the signature and time-based claims of a token
+func (s *HS256Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, s.sign(parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := claims.Valid(s.now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (s *HS256Signer) sign(signingInput string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+)
+
+// SigningAlgorithm is the JWS algorithm used for keys generated here
+const SigningAlgorithm = "ES256"
+
+// ErrNoActiveKey is returned when the key set has no key to sign with
+var ErrNoActiveKey = errors.New("no active signing key")
+
+// KeySet signs tokens with the active key and verifies them against every
+// key that has not been retired. It is safe for concurrent use and can be
+// reloaded at any time, which is how rotations are picked up.
+type KeySet struct {
+	mu     sync.RWMutex
+	active *ecdsa.PrivateKey
+	kid    string
+	keys   map[string]*ecdsa.PublicKey
+	jwks   JSONWebKeySet
+
+	// fallback verifies HS256 tokens issued before key rotation was
+	// introduced, so upgrading does not log everyone out
+	fallback *HS256Signer
+	now      func() time.Time
+}
+
+// NewKeySet creates an empty key set. fallback may be nil.
+func NewKeySet(fallback *HS256Signer) *KeySet {
+	return &KeySet{
+		keys:     make(map[string]*ecdsa.PublicKey),
+		fallback: fallback,
+		now:      time.Now,
+	}
+}
+
+// SetFallback replaces the signer that verifies HS256 tokens, e.g. when
+// the legacy secret is rotated. fallback may be nil.
+func (k *KeySet) SetFallback(fallback *HS256Signer) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.fallback = fallback
+}
+
+// Load replaces the keys in the set. Exactly one key must be active;
+// retired keys are ignored.
+func (k *KeySet) Load(signingKeys []*models.SigningKey) error {
+	var (
+		active *ecdsa.PrivateKey
+		kid    string
+		keys   = make(map[string]*ecdsa.PublicKey)
+		jwks   = JSONWebKeySet{Keys: []JSONWebKey{}}
+	)
+	for _, sk := range signingKeys {
+		if !sk.CanVerify() {
+			continue
+		}
+		priv, err := ParseSigningKey(sk.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", sk.ID, err)
+		}
+		if sk.Status == models.SigningKeyActive {
+			if active != nil {
+				return fmt.Errorf("more than one active signing key")
+			}
+			active, kid = priv, sk.ID
+		}
+		keys[sk.ID] = &priv.PublicKey
+		jwks.Keys = append(jwks.Keys, PublicJWK(sk.ID, &priv.PublicKey))
+	}
+	if active == nil {
+		return ErrNoActiveKey
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.active, k.kid, k.keys, k.jwks = active, kid, keys, jwks
+	return nil
+}
+
+// ActiveKeyID returns the kid of the key currently used for signing
+func (k *KeySet) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.kid
+}
+
+// JWKS returns the public keys of every non-retired key
+func (k *KeySet) JWKS() JSONWebKeySet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.jwks
+}
+
+// Sign serializes the claims and signs them with the active key
+func (k *KeySet) Sign(claims *Claims) (string, error) {
+	k.mu.RLock()
+	active, kid := k.active, k.kid
+	k.mu.RUnlock()
+	if active == nil {
+		return "", ErrNoActiveKey
+	}
+
+	header, err := json.Marshal(jwsHeader{Algorithm: SigningAlgorithm, Type: "JWT", KeyID: kid})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token header: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token claims: %w", err)
+	}
+
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	r, s, err := ecdsa.Sign(rand.Reader, active, hashInput(hashForAlg(SigningAlgorithm), signingInput))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	// JWS encodes ECDSA signatures as fixed-width r || s
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+// Verify checks the signature and time-based claims of a token
+func (k *KeySet) Verify(token string) (*Claims, error) {
+	jws, err := parseJWS(token)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	fallback := k.fallback
+	k.mu.RUnlock()
+	if jws.header.Algorithm == "HS256" && fallback != nil {
+		return fallback.Verify(token)
+	}
+	if jws.header.Algorithm != SigningAlgorithm {
+		return nil, fmt.Errorf("%w: algorithm %q not allowed", ErrInvalidToken, jws.header.Algorithm)
+	}
+
+	k.mu.RLock()
+	key, found := k.keys[jws.header.KeyID]
+	k.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, jws.header.KeyID)
+	}
+	if err := verifyJWSSignature(jws.header.Algorithm, key, jws.signingInput, jws.signature); err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := jws.decodeClaims(claims); err != nil {
+		return nil, err
+	}
+	if err := claims.Valid(k.now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// GenerateSigningKey creates a new P-256 key in the next state. Its ID is
+// the RFC 7638 thumbprint of the public key.
+func GenerateSigningKey() (*models.SigningKey, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signing key: %w", err)
+	}
+
+	return &models.SigningKey{
+		ID:         Thumbprint(&priv.PublicKey),
+		Algorithm:  SigningAlgorithm,
+		Status:     models.SigningKeyNext,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})),
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// ParseSigningKey decodes a PEM encoded EC private key
+func ParseSigningKey(data string) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM data")
+	}
+	priv, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EC private key: %w", err)
+	}
+	if priv.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("unsupported curve %s", priv.Curve.Params().Name)
+	}
+	return priv, nil
+}
+
+// PublicJWK returns the JWK for a P-256 public key
+func PublicJWK(kid string, pub *ecdsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		KeyType:   "EC",
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: SigningAlgorithm,
+		Curve:     "P-256",
+		X:         encodeCoordinate(pub.X),
+		Y:         encodeCoordinate(pub.Y),
+	}
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of a P-256 public key
+func Thumbprint(pub *ecdsa.PublicKey) string {
+	// Members in lexicographic order, no whitespace, as the RFC requires
+	canonical := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+		encodeCoordinate(pub.X), encodeCoordinate(pub.Y))
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func encodeCoordinate(v *big.Int) string {
+	b := make([]byte, 32)
+	v.FillBytes(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
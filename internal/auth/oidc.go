@@ -0,0 +1,446 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOIDCUnavailable is returned when the identity provider cannot be reached
+var ErrOIDCUnavailable = errors.New("identity provider unavailable")
+
+// OIDCConfig configures the OpenID Connect relying party
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Audience is the expected aud of IdP-issued access tokens. It
+	// defaults to ClientID.
+	Audience string
+
+	// GroupsClaim names the claim listing the user's groups
+	GroupsClaim string
+
+	// JWKSRefreshInterval is the minimum time between JWKS refetches
+	// triggered by unknown key IDs. It defaults to 30 seconds.
+	JWKSRefreshInterval time.Duration
+}
+
+// OIDCProviderMetadata is the subset of the discovery document we use
+type OIDCProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint,omitempty"`
+}
+
+// OIDCTokenResponse is the token endpoint response for the code grant
+type OIDCTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	IDToken      string `json:"id_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Identity is a verified end-user identity asserted by the IdP
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+
+	// EmailVerified is set only when the token says email_verified: true
+	EmailVerified bool
+
+	// TokenID, IssuedAt and ExpiresAt describe the verified token
+	TokenID   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// oidcClaims are the standard claims checked on ID and access tokens
+type oidcClaims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	NotBefore       int64    `json:"nbf,omitempty"`
+	ID              string   `json:"jti,omitempty"`
+	Nonce           string   `json:"nonce,omitempty"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	EmailVerified   *bool    `json:"email_verified,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Username        string   `json:"preferred_username,omitempty"`
+}
+
+// audience accepts the aud claim as either a string or an array
+type audience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDCProvider talks to an OpenID Connect identity provider. Discovery is
+// performed lazily on first use and cached.
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	metadata *OIDCProviderMetadata
+	keys     *RemoteKeySet
+}
+
+// NewOIDCProvider creates a provider for the given configuration
+func NewOIDCProvider(cfg OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = cfg.ClientID
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCProvider{
+		cfg:    cfg,
+		client: client,
+		now:    time.Now,
+	}
+}
+
+// AuthCodeURL returns the authorization endpoint URL for a code flow login
+// using PKCE with the S256 challenge method
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	md, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code at the token endpoint
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (*OIDCTokenResponse, error) {
+	md, _, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&oauthErr)
+		return nil, fmt.Errorf("token exchange failed with status %d: %s %s", resp.StatusCode, oauthErr.Error, oauthErr.Description)
+	}
+
+	var tokens OIDCTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response did not contain an id_token")
+	}
+	return &tokens, nil
+}
+
+// VerifyIDToken verifies an ID token's signature, issuer, audience, expiry
+// and nonce and returns the asserted identity
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*Identity, error) {
+	claims, raw, err := p.verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Audience.contains(p.cfg.ClientID) {
+		return nil, fmt.Errorf("%w: audience mismatch", ErrInvalidToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: authorized party mismatch", ErrInvalidToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return p.identity(claims, raw), nil
+}
+
+// VerifyAccessToken verifies a JWT access token issued by the IdP for this
+// API and returns the asserted identity
+func (p *OIDCProvider) VerifyAccessToken(ctx context.Context, token string) (*Identity, error) {
+	claims, raw, err := p.verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Audience.contains(p.cfg.Audience) {
+		return nil, fmt.Errorf("%w: audience mismatch", ErrInvalidToken)
+	}
+	return p.identity(claims, raw), nil
+}
+
+func (p *OIDCProvider) verify(ctx context.Context, token string) (*oidcClaims, map[string]json.RawMessage, error) {
+	md, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var payload json.RawMessage
+	if err := keys.Verify(ctx, token, &payload); err != nil {
+		return nil, nil, err
+	}
+
+	claims := &oidcClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != md.Issuer {
+		return nil, nil, fmt.Errorf("%w: issuer mismatch", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return nil, nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+	now := p.now()
+	if claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(clockSkew)) {
+		return nil, nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, nil, fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+	return claims, raw, nil
+}
+
+func (p *OIDCProvider) identity(claims *oidcClaims, raw map[string]json.RawMessage) *Identity {
+	identity := &Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified != nil && *claims.EmailVerified,
+		Name:          claims.Name,
+		TokenID:       claims.ID,
+		IssuedAt:      time.Unix(claims.IssuedAt, 0),
+		ExpiresAt:     time.Unix(claims.ExpiresAt, 0),
+	}
+	if identity.Name == "" {
+		identity.Name = claims.Username
+	}
+
+	// Groups may be a list or, for single-group users, a plain string
+	if value, ok := raw[p.cfg.GroupsClaim]; ok {
+		var groups []string
+		if err := json.Unmarshal(value, &groups); err != nil {
+			var group string
+			if err := json.Unmarshal(value, &group); err == nil {
+				groups = []string{group}
+			}
+		}
+		identity.Groups = groups
+	}
+	return identity
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*OIDCProviderMetadata, *RemoteKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, p.keys, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrOIDCUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: discovery returned status %d", ErrOIDCUnavailable, resp.StatusCode)
+	}
+
+	md := &OIDCProviderMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(md); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	if strings.TrimSuffix(md.Issuer, "/") != strings.TrimSuffix(p.cfg.IssuerURL, "/") {
+		return nil, nil, fmt.Errorf("discovery issuer %q does not match configured issuer %q", md.Issuer, p.cfg.IssuerURL)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("discovery document is missing required endpoints")
+	}
+
+	p.metadata = md
+	p.keys = NewRemoteKeySet(md.JWKSURI, p.client)
+	if p.cfg.JWKSRefreshInterval > 0 {
+		p.keys.minRefresh = p.cfg.JWKSRefreshInterval
+	}
+	return p.metadata, p.keys, nil
+}
+
+// GeneratePKCE returns a PKCE code verifier and its S256 challenge
+func GeneratePKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString returns n random bytes encoded as unpadded base64url
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// rolePrecedence orders roles from least to most privileged
+var rolePrecedence = map[string]int{
+	"viewer": 1,
+	"user":   2,
+	"admin":  3,
+}
+
+// RoleMapper maps IdP groups to local user roles
+type RoleMapper struct {
+	groups      map[string]string
+	defaultRole string
+}
+
+// NewRoleMapper creates a mapper from group names to roles. Users without
+// a mapped group get defaultRole.
+func NewRoleMapper(groups map[string]string, defaultRole string) *RoleMapper {
+	return &RoleMapper{
+		groups:      groups,
+		defaultRole: defaultRole,
+	}
+}
+
+// Role returns the most privileged role granted by any of the groups
+func (m *RoleMapper) Role(groups []string) string {
+	role := m.defaultRole
+	for _, group := range groups {
+		mapped, ok := m.groups[group]
+		if ok && rolePrecedence[mapped] > rolePrecedence[role] {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// ParseRoleMapping parses a "group=role,group=role" mapping
+func ParseRoleMapping(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, found := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !found || group == "" {
+			return nil, fmt.Errorf("invalid role mapping %q", pair)
+		}
+		if _, ok := rolePrecedence[role]; !ok {
+			roles := make([]string, 0, len(rolePrecedence))
+			for r := range rolePrecedence {
+				roles = append(roles, r)
+			}
+			sort.Strings(roles)
+			return nil, fmt.Errorf("invalid role %q for group %q, must be one of %v", role, group, roles)
+		}
+		mapping[group] = role
+	}
+	return mapping, nil
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+)
+
+// Authentication methods
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+	MethodOIDC   = "oidc"
+)
+
+// roleScopes maps user roles to the scopes they are granted
+var roleScopes = map[string][]string{
+	"admin":  {models.ScopeAdmin},
+	"user":   {models.ScopeUsersRead, models.ScopeUsersWrite, models.ScopeAPIKeysManage},
+	"viewer": {models.ScopeUsersRead},
+}
+
+// ScopesForRole returns the scopes granted to a user role
+func ScopesForRole(role string) []string {
+	return roleScopes[role]
+}
+
+// Principal describes the authenticated caller of a request
+type Principal struct {
+	Subject string   `json:"subject"`
+	Type    string   `json:"type"`
+	Method  string   `json:"method"`
+	Role    string   `json:"role,omitempty"`
+	KeyID   string   `json:"key_id,omitempty"`
+	TokenID string   `json:"token_id,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"`
+
+	// RateLimit is the API key's own quota in requests per minute; zero
+	// uses the default rate limit
+	RateLimit int `json:"rate_limit,omitempty"`
+
+	// IssuedAt and ExpiresAt bound the token lifetime for token-based
+	// principals; they are zero for API keys
+	IssuedAt  time.Time `json:"-"`
+	ExpiresAt time.Time `json:"-"`
+}
+
+// HasScope reports whether the principal was granted the given scope
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope || s == models.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// RefreshTokenPrefix marks a credential as a refresh token
+const RefreshTokenPrefix = "rt_"
+
+const refreshTokenBytes = 32
+
+// GenerateRefreshToken creates a new opaque refresh token and its hash
+func GenerateRefreshToken() (token, hash string, err error) {
+	secret := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token = RefreshTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash under which a refresh token is stored
+func HashRefreshToken(token string) string {
+	return hashToken(token)
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a high-entropy token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
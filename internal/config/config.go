@@ -1,84 +1,309 @@
 package config
 
 import (
-	"fmt"
 	"os"
-	"strconv"
-
-	"gopkg.in/yaml.v3"
+	"strings"
+	"time"
 )
 
-// Config holds all application configuration
+// Config holds all application configuration. Settings tagged
+// reload:"live" are applied while the server runs when the configuration
+// is reloaded; changing the others needs a restart. Settings tagged
+// secret:"true" are redacted whenever the configuration is printed or
+// logged, see Redacted.
 type Config struct {
 	Host          string `yaml:"host" env:"APP_HOST"`
 	Port          int    `yaml:"port" env:"APP_PORT"`
 	Environment   string `yaml:"environment" env:"ENVIRONMENT"`
-	LogLevel      string `yaml:"log_level" env:"LOG_LEVEL"`
+	LogLevel      string `yaml:"log_level" env:"LOG_LEVEL" reload:"live"`
 	MetricsPort   int    `yaml:"metrics_port" env:"METRICS_PORT"`
-	DatabaseURL   string `yaml:"database_url" env:"DATABASE_URL"`
-	RedisURL      string `yaml:"redis_url" env:"REDIS_URL"`
-	JWTSecret     string `yaml:"jwt_secret" env:"JWT_SECRET"`
+	DatabaseURL   string `yaml:"database_url" env:"DATABASE_URL" secret:"true" reload:"live"`
+	RedisURL      string `yaml:"redis_url" env:"REDIS_URL" secret:"true" reload:"live"`
+	JWTSecret     string `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true" reload:"live"`
 	MaxHeaderSize int    `yaml:"max_header_size" env:"MAX_HEADER_SIZE"`
 	ReadTimeout   int    `yaml:"read_timeout" env:"READ_TIMEOUT"`
 	WriteTimeout  int    `yaml:"write_timeout" env:"WRITE_TIMEOUT"`
+
+	// Further server limits (timeouts in seconds, sizes in bytes)
+	IdleTimeout       int `yaml:"idle_timeout" env:"IDLE_TIMEOUT"`
+	ReadHeaderTimeout int `yaml:"read_header_timeout" env:"READ_HEADER_TIMEOUT"`
+	MaxBodySize       int `yaml:"max_body_size" env:"MAX_BODY_SIZE"`
+
+	// RequestTimeout bounds request handling and ShutdownTimeout graceful
+	// shutdown. RouteTimeouts overrides RequestTimeout below path prefixes,
+	// e.g. "/api/v1/admin=60s".
+	RequestTimeout  time.Duration `yaml:"request_timeout" env:"MIDDLEWARE_REQUEST_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"MIDDLEWARE_SHUTDOWN_TIMEOUT"`
+	RouteTimeouts   string        `yaml:"route_timeouts" env:"MIDDLEWARE_ROUTE_TIMEOUTS"`
+
+	// Token issuance (TTLs in seconds)
+	JWTIssuer       string `yaml:"jwt_issuer" env:"JWT_ISSUER"`
+	AccessTokenTTL  int    `yaml:"access_token_ttl" env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL int    `yaml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL"`
+
+	// JWTKeyRotationInterval is how long a signing key stays active, in
+	// seconds; 0 disables scheduled rotation
+	JWTKeyRotationInterval int `yaml:"jwt_key_rotation_interval" env:"JWT_KEY_ROTATION_INTERVAL"`
+
+	// OpenID Connect single sign-on; disabled when OIDCIssuerURL is empty
+	OIDCIssuerURL    string `yaml:"oidc_issuer_url" env:"OIDC_ISSUER_URL"`
+	OIDCClientID     string `yaml:"oidc_client_id" env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret string `yaml:"oidc_client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	OIDCRedirectURL  string `yaml:"oidc_redirect_url" env:"OIDC_REDIRECT_URL"`
+	OIDCScopes       string `yaml:"oidc_scopes" env:"OIDC_SCOPES"`
+	OIDCAudience     string `yaml:"oidc_audience" env:"OIDC_AUDIENCE"`
+	OIDCGroupsClaim  string `yaml:"oidc_groups_claim" env:"OIDC_GROUPS_CLAIM"`
+	OIDCRoleMapping  string `yaml:"oidc_role_mapping" env:"OIDC_ROLE_MAPPING"`
+	OIDCDefaultRole  string `yaml:"oidc_default_role" env:"OIDC_DEFAULT_ROLE"`
+
+	// Rate limiting per API key, user or client IP. RateLimitRoutes gives
+	// path prefixes limits of their own, as "/prefix=requests-per-minute"
+	// pairs separated by commas, instead of the default
+	FeatureRateLimiting        bool   `yaml:"feature_rate_limiting" env:"FEATURE_RATE_LIMITING" reload:"live"`
+	RateLimitRequestsPerMinute int    `yaml:"rate_limit_requests_per_minute" env:"RATE_LIMIT_REQUESTS_PER_MINUTE" reload:"live"`
+	RateLimitBurst             int    `yaml:"rate_limit_burst" env:"RATE_LIMIT_BURST" reload:"live"`
+	RateLimitRoutes            string `yaml:"rate_limit_routes" env:"RATE_LIMIT_ROUTES" reload:"live"`
+
+	// FeatureCircuitBreaker protects repository and outbound HTTP calls
+	// with circuit breakers, retries and bulkheads
+	FeatureCircuitBreaker bool `yaml:"feature_circuit_breaker" env:"FEATURE_CIRCUIT_BREAKER"`
+
+	// FeatureLoadShedding rejects requests beyond an adaptive concurrency
+	// limit, which starts at ConcurrencyLimitInitial and stays between
+	// ConcurrencyLimitMin and ConcurrencyLimitMax
+	FeatureLoadShedding     bool `yaml:"feature_load_shedding" env:"FEATURE_LOAD_SHEDDING" reload:"live"`
+	ConcurrencyLimitInitial int  `yaml:"concurrency_limit_initial" env:"CONCURRENCY_LIMIT_INITIAL"`
+	ConcurrencyLimitMin     int  `yaml:"concurrency_limit_min" env:"CONCURRENCY_LIMIT_MIN"`
+	ConcurrencyLimitMax     int  `yaml:"concurrency_limit_max" env:"CONCURRENCY_LIMIT_MAX"`
+
+	// FeatureFlagsFile is an optional YAML file with feature flag targeting
+	// rules, see pkg/features
+	FeatureFlagsFile string `yaml:"feature_flags_file" env:"FEATURE_FLAGS_FILE" reload:"live"`
+
+	// IdempotencyKeyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for replay, in seconds
+	IdempotencyKeyTTL int `yaml:"idempotency_key_ttl" env:"IDEMPOTENCY_KEY_TTL"`
+
+	// CORS policy as comma-separated lists. Origins may be exact,
+	// wildcard-subdomain patterns like https://*.example.com, or "*"; "*"
+	// cannot be combined with credentials. CORSRouteOrigins overrides the
+	// origins below path prefixes, e.g.
+	// "/api/v1/admin=https://admin.example.com;/.well-known=*".
+	CORSAllowedOrigins   string `yaml:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS" reload:"live"`
+	CORSAllowedMethods   string `yaml:"cors_allowed_methods" env:"CORS_ALLOWED_METHODS" reload:"live"`
+	CORSAllowedHeaders   string `yaml:"cors_allowed_headers" env:"CORS_ALLOWED_HEADERS" reload:"live"`
+	CORSExposedHeaders   string `yaml:"cors_exposed_headers" env:"CORS_EXPOSED_HEADERS" reload:"live"`
+	CORSAllowCredentials bool   `yaml:"cors_allow_credentials" env:"CORS_ALLOW_CREDENTIALS" reload:"live"`
+	CORSMaxAge           int    `yaml:"cors_max_age" env:"CORS_MAX_AGE" reload:"live"`
+	CORSRouteOrigins     string `yaml:"cors_route_origins" env:"CORS_ROUTE_ORIGINS" reload:"live"`
+
+	// Tracing exports OpenTelemetry spans over OTLP/HTTP to the collector
+	// at TracingEndpoint, e.g. http://otel-collector:4318, and is off
+	// without one. TracingSampleRatio is the share of new traces that are
+	// sampled; traces continued from a caller follow its decision.
+	TracingEndpoint    string  `yaml:"tracing_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TracingServiceName string  `yaml:"tracing_service_name" env:"OTEL_SERVICE_NAME"`
+	TracingSampleRatio float64 `yaml:"tracing_sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+
+	// Security headers. HSTS is sent over TLS only, for
+	// SecurityHSTSMaxAge seconds; 0 turns it off. SecurityCSP applies to
+	// pages and SecurityAPICSP to /api and /.well-known; violations of
+	// either are reported to /csp-report, and with SecurityCSPReportOnly
+	// they are only reported, not blocked.
+	SecurityHSTSMaxAge        int    `yaml:"security_hsts_max_age" env:"SECURITY_HSTS_MAX_AGE"`
+	SecurityCSP               string `yaml:"security_csp" env:"SECURITY_CSP"`
+	SecurityAPICSP            string `yaml:"security_api_csp" env:"SECURITY_API_CSP"`
+	SecurityCSPReportOnly     bool   `yaml:"security_csp_report_only" env:"SECURITY_CSP_REPORT_ONLY"`
+	SecurityPermissionsPolicy string `yaml:"security_permissions_policy" env:"SECURITY_PERMISSIONS_POLICY"`
+	SecurityCOOP              string `yaml:"security_coop" env:"SECURITY_COOP"`
+	SecurityCOEP              string `yaml:"security_coep" env:"SECURITY_COEP"`
+
+	// Client addresses are taken from forwarding headers only when the
+	// peer is one of TrustedProxies. The admin routes and the metrics
+	// server admit the Allowed CIDRs of their group, minus the Denied
+	// ones; empty allow lists admit everyone. X-Request-Priority is only
+	// honored from PriorityAllowedCIDRs, and from no one when it is empty.
+	// IPACLFile may override any of these, and is reloaded within
+	// IPACLReloadInterval of a change.
+	TrustedProxies       string        `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	AdminAllowedCIDRs    string        `yaml:"admin_allowed_cidrs" env:"ADMIN_ALLOWED_CIDRS"`
+	AdminDeniedCIDRs     string        `yaml:"admin_denied_cidrs" env:"ADMIN_DENIED_CIDRS"`
+	InternalAllowedCIDRs string        `yaml:"internal_allowed_cidrs" env:"INTERNAL_ALLOWED_CIDRS"`
+	InternalDeniedCIDRs  string        `yaml:"internal_denied_cidrs" env:"INTERNAL_DENIED_CIDRS"`
+	PriorityAllowedCIDRs string        `yaml:"priority_allowed_cidrs" env:"PRIORITY_ALLOWED_CIDRS"`
+	IPACLFile            string        `yaml:"ip_acl_file" env:"IP_ACL_FILE"`
+	IPACLReloadInterval  time.Duration `yaml:"ip_acl_reload_interval" env:"IP_ACL_RELOAD_INTERVAL"`
+
+	// CacheControlRoutes sets Cache-Control on GET responses by path
+	// prefix, as "/prefix=directives" pairs separated by semicolons. The
+	// response cache keeps user reads for ResponseCacheTTL, in Redis when
+	// it is configured, and is purged when users change.
+	CacheControlRoutes   string        `yaml:"cache_control_routes" env:"CACHE_CONTROL_ROUTES"`
+	ResponseCacheEnabled bool          `yaml:"response_cache_enabled" env:"RESPONSE_CACHE_ENABLED"`
+	ResponseCacheTTL     time.Duration `yaml:"response_cache_ttl" env:"RESPONSE_CACHE_TTL"`
+
+	// Secret settings are read from their environment variable or from the
+	// file named by its _FILE variable, then from SecretsProvider: "env"
+	// adds nothing, "file" reads a file per secret in SecretsDir and
+	// "vault" a KV version 2 secret at VaultKVPath. The configuration is
+	// reloaded every SecretsRefreshInterval to pick up rotated secrets;
+	// 0 turns this off. Rotated DatabaseURL and RedisURL credentials are
+	// used by new connections and a rotated JWTSecret verifies legacy
+	// tokens at once; the other secrets need a restart.
+	SecretsProvider        string        `yaml:"secrets_provider" env:"SECRETS_PROVIDER"`
+	SecretsDir             string        `yaml:"secrets_dir" env:"SECRETS_DIR"`
+	SecretsRefreshInterval time.Duration `yaml:"secrets_refresh_interval" env:"SECRETS_REFRESH_INTERVAL"`
+	VaultAddr              string        `yaml:"vault_addr" env:"VAULT_ADDR"`
+	VaultToken             string        `yaml:"vault_token" env:"VAULT_TOKEN" secret:"true"`
+	VaultKVMount           string        `yaml:"vault_kv_mount" env:"VAULT_KV_MOUNT"`
+	VaultKVPath            string        `yaml:"vault_kv_path" env:"VAULT_KV_PATH"`
+
+	// loadErrors are the settings that could not be parsed, reported by
+	// Validate, and sources records where each setting came from
+	loadErrors []FieldError
+	sources    Sources
 }
 
-// Load reads configuration from environment variables and config file
-func Load() (*Config, error) {
-	config := &Config{
-		Host:          getEnv("APP_HOST", "0.0.0.0"),
-		Port:          getEnvAsInt("APP_PORT", 8080),
-		Environment:   getEnv("ENVIRONMENT", "development"),
-		LogLevel:      getEnv("LOG_LEVEL", "info"),
-		MetricsPort:   getEnvAsInt("METRICS_PORT", 9090),
-		DatabaseURL:   os.Getenv("DATABASE_URL"),
-		RedisURL:      os.Getenv("REDIS_URL"),
-		JWTSecret:     os.Getenv("JWT_SECRET"),
-		MaxHeaderSize: getEnvAsInt("MAX_HEADER_SIZE", 1048576),
-		ReadTimeout:   getEnvAsInt("READ_TIMEOUT", 30),
-		WriteTimeout:  getEnvAsInt("WRITE_TIMEOUT", 30),
+// Defaults returns the configuration used for settings that no layer sets.
+// The defaults that depend on the environment are filled in by LoadWith.
+func Defaults() *Config {
+	return &Config{
+		Host:          "0.0.0.0",
+		Port:          8080,
+		Environment:   "development",
+		LogLevel:      "info",
+		MetricsPort:   9090,
+		MaxHeaderSize: 1048576,
+		ReadTimeout:   30,
+		WriteTimeout:  30,
+
+		IdleTimeout:       120,
+		ReadHeaderTimeout: 10,
+		MaxBodySize:       1048576,
+
+		RequestTimeout:  30 * time.Second,
+		ShutdownTimeout: 30 * time.Second,
+
+		JWTIssuer:       "pipeline-arch",
+		AccessTokenTTL:  900,
+		RefreshTokenTTL: 604800,
+
+		JWTKeyRotationInterval: 2592000,
+
+		OIDCScopes:      "openid email profile",
+		OIDCGroupsClaim: "groups",
+		OIDCDefaultRole: "viewer",
+
+		RateLimitRequestsPerMinute: 100,
+		RateLimitRoutes:            "/api/v1/auth=20",
+
+		ConcurrencyLimitInitial: 100,
+		ConcurrencyLimitMin:     10,
+		ConcurrencyLimitMax:     1000,
+
+		IdempotencyKeyTTL: 86400,
+
+		CORSAllowedMethods: "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		CORSAllowedHeaders: "Accept,Authorization,Content-Type,X-API-Key,X-CSRF-Token,X-Request-ID,Idempotency-Key,traceparent,tracestate",
+		CORSExposedHeaders: "X-Request-ID,Idempotent-Replayed,traceparent",
+		CORSMaxAge:         300,
+
+		TracingServiceName: "pipeline-arch",
+		TracingSampleRatio: 1,
+
+		SecurityHSTSMaxAge:        31536000,
+		SecurityCSP:               "default-src 'self'; script-src 'self'; style-src 'self'; img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		SecurityAPICSP:            "default-src 'none'; frame-ancestors 'none'",
+		SecurityPermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=()",
+		SecurityCOOP:              "same-origin",
+		SecurityCOEP:              "require-corp",
+
+		TrustedProxies:      "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,127.0.0.0/8,::1/128,fc00::/7",
+		IPACLReloadInterval: 30 * time.Second,
+
+		CacheControlRoutes: "/api/v1/users=private, no-cache",
+		ResponseCacheTTL:   time.Minute,
+
+		SecretsProvider:        "env",
+		SecretsDir:             "/etc/pipeline-arch/secrets",
+		SecretsRefreshInterval: 5 * time.Minute,
+		VaultKVMount:           "secret",
+		VaultKVPath:            "pipeline-arch",
 	}
+}
 
-	return config, nil
+// Load reads configuration from the YAML file named by CONFIG_FILE, if
+// any, and environment variables
+func Load() (*Config, error) {
+	return LoadWith(Options{File: os.Getenv("CONFIG_FILE")})
 }
 
-// LoadFromFile loads configuration from a YAML file
+// LoadFromFile loads configuration from a YAML file; environment variables
+// override it
 func LoadFromFile(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
+	return LoadWith(Options{File: path})
+}
 
-	config := &Config{}
-	if err := yaml.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+// LoadWith loads configuration from Defaults and the layers in opts, see
+// LoadInto. Values that do not parse are reported by Validate rather than
+// returned.
+func LoadWith(opts Options) (*Config, error) {
+	config := Defaults()
+	sources, err := LoadInto(config, opts)
+	if verr, ok := err.(*ValidationError); ok {
+		config.loadErrors = verr.Errors
+	} else if err != nil {
+		return nil, err
 	}
+	config.sources = sources
+	config.applyEnvironmentDefaults()
+	return config, nil
+}
 
-	// Override with environment variables
-	config.Host = getEnv("APP_HOST", config.Host)
-	config.Port = getEnvAsInt("APP_PORT", config.Port)
-	config.Environment = getEnv("ENVIRONMENT", config.Environment)
-	config.LogLevel = getEnv("LOG_LEVEL", config.LogLevel)
-	config.MetricsPort = getEnvAsInt("METRICS_PORT", config.MetricsPort)
-	config.DatabaseURL = os.Getenv("DATABASE_URL")
-	config.RedisURL = os.Getenv("REDIS_URL")
-	config.JWTSecret = os.Getenv("JWT_SECRET")
+// applyEnvironmentDefaults fills in the defaults that depend on the
+// environment, for settings that no layer set
+func (c *Config) applyEnvironmentDefaults() {
+	development := c.Environment == "development"
+	defaults := Defaults()
 
-	return config, nil
+	// Any origin may call a development server; other environments must
+	// list their origins
+	if c.sources["cors_allowed_origins"].Layer == LayerDefault {
+		c.CORSAllowedOrigins = defaults.CORSAllowedOrigins
+		if development {
+			c.CORSAllowedOrigins = "*"
+		}
+	}
+	// Development servers run without TLS and try out policy changes in
+	// report-only mode
+	if c.sources["security_hsts_max_age"].Layer == LayerDefault {
+		c.SecurityHSTSMaxAge = defaults.SecurityHSTSMaxAge
+		if development {
+			c.SecurityHSTSMaxAge = 0
+		}
+	}
+	if c.sources["security_csp_report_only"].Layer == LayerDefault {
+		c.SecurityCSPReportOnly = development
+	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// Sources returns where the value of each setting came from, keyed by its
+// yaml name
+func (c *Config) Sources() Sources {
+	sources := make(Sources, len(c.sources))
+	for key, source := range c.sources {
+		sources[key] = source
 	}
-	return defaultValue
+	return sources
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value, exists := os.LookupEnv(key); exists {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// SplitList splits a comma-separated config value, dropping blank entries
+func SplitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
-	return defaultValue
-}
\ No newline at end of file
+	return items
+}
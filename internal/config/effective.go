@@ -0,0 +1,96 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Value is the effective value of one setting, redacted if it is a
+// secret, with the environment variable that sets it and where the value
+// came from
+type Value struct {
+	Value  interface{} `json:"value" yaml:"value"`
+	Env    string      `json:"env,omitempty" yaml:"env,omitempty"`
+	Source Source      `json:"source" yaml:"source"`
+	Secret bool        `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// Effective is a configuration as it is running, keyed by the yaml path of
+// each setting. It is safe to print: secrets are redacted.
+type Effective map[string]Value
+
+// Effective returns the settings of the configuration with their sources.
+// Durations are written like "30s", as in the config file.
+func (c *Config) Effective() Effective {
+	effective := make(Effective)
+	for _, s := range collectSettings(reflect.ValueOf(c.Redacted()).Elem(), "", "") {
+		var value interface{}
+		if s.value.Type() == durationType {
+			value = time.Duration(s.value.Int()).String()
+		} else {
+			value = s.value.Interface()
+		}
+		source, ok := c.sources[s.path]
+		if !ok {
+			source = Source{Layer: LayerDefault}
+		}
+		effective[s.path] = Value{Value: value, Env: s.env, Source: source, Secret: s.secret}
+	}
+	return effective
+}
+
+// Difference is a setting whose value differs between two effective
+// configurations. A or B is nil when the setting is missing on that side.
+type Difference struct {
+	Key string
+	A   *Value
+	B   *Value
+}
+
+// DiffEffective lists the settings whose values differ between a and b,
+// sorted by key. Sources are not compared, and secrets only as set or
+// unset since their values are redacted.
+func DiffEffective(a, b Effective) []Difference {
+	keys := make(map[string]bool, len(a))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+
+	var diffs []Difference
+	for key := range keys {
+		va, inA := a[key]
+		vb, inB := b[key]
+		if inA && inB && sameValue(va.Value, vb.Value) {
+			continue
+		}
+		diff := Difference{Key: key}
+		if inA {
+			diff.A = &va
+		}
+		if inB {
+			diff.B = &vb
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
+
+// sameValue compares values by their JSON encoding, so that a number reads
+// the same whether it was decoded as an integer or a float
+func sameValue(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return bytes.Equal(ja, jb)
+}
@@ -0,0 +1,466 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Layer is a place settings are read from. Later layers override earlier
+// ones: defaults < file < env < secret < flag.
+type Layer string
+
+const (
+	LayerDefault Layer = "default"
+	LayerFile    Layer = "file"
+	LayerEnv     Layer = "env"
+	LayerSecret  Layer = "secret"
+	LayerFlag    Layer = "flag"
+)
+
+// Source records where the value of a setting came from: its layer and
+// the file, environment variable, secret provider or flag that set it
+type Source struct {
+	Layer Layer  `json:"layer" yaml:"layer"`
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+}
+
+func (s Source) String() string {
+	if s.Name == "" {
+		return string(s.Layer)
+	}
+	return string(s.Layer) + " " + s.Name
+}
+
+// Sources maps settings, named by their yaml path such as "port" or
+// "database.pool_size", to where their values came from
+type Sources map[string]Source
+
+// Options are the layers LoadInto reads over the defaults
+type Options struct {
+	// File is a YAML file of settings; empty skips the file layer
+	File string
+	// Args are command-line flags named after the yaml path of each
+	// setting with dashes for underscores, e.g. --metrics-port=9091 or
+	// --database.pool-size 10
+	Args []string
+	// LookupEnv reads environment variables; nil means os.LookupEnv
+	LookupEnv func(key string) (string, bool)
+	// Secrets, when not nil, overrides secret settings with the values it
+	// holds
+	Secrets SecretProvider
+}
+
+// secretsTimeout bounds how long LoadInto waits for a SecretProvider
+const secretsTimeout = 10 * time.Second
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadInto fills dst, a pointer to a struct holding the defaults, from the
+// layers in opts and returns where each setting came from.
+//
+// Settings are the exported fields of dst, named by their yaml tags and
+// read from the environment variables in their env tags. Settings tagged
+// secret:"true" may instead be read from the file named by the variable
+// with a _FILE suffix, e.g. DATABASE_URL_FILE, and are then overridden by
+// opts.Secrets. Struct fields are sections: their settings nest under the
+// section in the file, and their env tag, if any, prefixes the environment
+// variables inside with an underscore. Environment variables and flags
+// hold lists as comma-separated values and maps as comma-separated
+// key=value pairs.
+//
+// A value that does not parse keeps the value of the layer below; all of
+// them are returned together as a *ValidationError after every layer is
+// read. Other errors, such as an unreadable file, an unknown file setting,
+// an unknown flag or a failing secret provider, stop loading.
+func LoadInto(dst interface{}, opts Options) (Sources, error) {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: LoadInto needs a pointer to a struct, got %T", dst)
+	}
+	l := &loader{sources: make(Sources), lookupEnv: opts.LookupEnv}
+	if l.lookupEnv == nil {
+		l.lookupEnv = os.LookupEnv
+	}
+
+	settings := collectSettings(v.Elem(), "", "")
+	for _, s := range settings {
+		l.sources[s.path] = Source{Layer: LayerDefault}
+	}
+	if opts.File != "" {
+		if err := l.loadFile(v.Elem(), opts.File); err != nil {
+			return nil, err
+		}
+	}
+	l.loadEnv(settings)
+	if opts.Secrets != nil {
+		if err := l.loadSecrets(settings, opts.Secrets); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.loadFlags(settings, opts.Args); err != nil {
+		return nil, err
+	}
+
+	if len(l.errs) > 0 {
+		return l.sources, &ValidationError{Errors: l.errs}
+	}
+	return l.sources, nil
+}
+
+// setting is a leaf field of the configuration struct. Live settings,
+// tagged reload:"live", may change while the server runs; secret ones,
+// tagged secret:"true", are never shown.
+type setting struct {
+	path   string
+	env    string
+	flag   string
+	live   bool
+	secret bool
+	value  reflect.Value
+}
+
+type loader struct {
+	sources   Sources
+	errs      []FieldError
+	lookupEnv func(key string) (string, bool)
+}
+
+// collectSettings lists the settings of the struct v, whose fields nest
+// under path and whose environment variables start with envPrefix
+func collectSettings(v reflect.Value, path, envPrefix string) []setting {
+	var settings []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := settingName(field)
+		if !ok {
+			continue
+		}
+		fieldPath := joinPath(path, name)
+		env := field.Tag.Get("env")
+		if isSection(field.Type) {
+			prefix := envPrefix
+			if env != "" {
+				prefix += env + "_"
+			}
+			settings = append(settings, collectSettings(v.Field(i), fieldPath, prefix)...)
+			continue
+		}
+		s := setting{
+			path:   fieldPath,
+			flag:   strings.ReplaceAll(fieldPath, "_", "-"),
+			live:   field.Tag.Get("reload") == "live",
+			secret: field.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		}
+		if env != "" {
+			s.env = envPrefix + env
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// settingName returns the yaml name of a struct field, or false for
+// unexported fields and fields tagged yaml:"-"
+func settingName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return "", false
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name, true
+}
+
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// loadFile applies the settings in a YAML file. Each setting is decoded on
+// its own so that settings missing from the file keep their values.
+func (l *loader) loadFile(root reflect.Value, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+		return nil
+	}
+	return l.decodeSection(doc.Content[0], root, "", path)
+}
+
+func (l *loader) decodeSection(node *yaml.Node, v reflect.Value, path, file string) error {
+	if node.Kind != yaml.MappingNode {
+		if path == "" {
+			return fmt.Errorf("failed to parse config file: %s must hold a mapping of settings", file)
+		}
+		return fmt.Errorf("failed to parse config file: %s line %d: %s must be a mapping", file, node.Line, path)
+	}
+
+	fields := make(map[string]int)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name, ok := settingName(t.Field(i)); ok {
+			fields[name] = i
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		settingPath := joinPath(path, key.Value)
+		index, ok := fields[key.Value]
+		if !ok {
+			return fmt.Errorf("failed to parse config file: %s line %d: unknown setting %q", file, key.Line, settingPath)
+		}
+		field := v.Field(index)
+		if isSection(field.Type()) {
+			if err := l.decodeSection(value, field, settingPath, file); err != nil {
+				return err
+			}
+			continue
+		}
+		decoded := reflect.New(field.Type())
+		if err := value.Decode(decoded.Interface()); err != nil {
+			message := fmt.Sprintf("invalid %s in %s line %d", describeType(field.Type()), file, value.Line)
+			if value.Kind == yaml.ScalarNode {
+				message = fmt.Sprintf("invalid %s %q in %s line %d", describeType(field.Type()), value.Value, file, value.Line)
+			}
+			l.errs = append(l.errs, FieldError{Key: settingPath, Message: message})
+			continue
+		}
+		field.Set(decoded.Elem())
+		l.sources[settingPath] = Source{Layer: LayerFile, Name: file}
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables that are set, even to empty
+// values, and the files named by the _FILE variables of secret settings
+func (l *loader) loadEnv(settings []setting) {
+	for _, s := range settings {
+		if s.env == "" {
+			continue
+		}
+		if s.secret {
+			raw, name, ok, err := lookupSecretEnv(l.lookupEnv, s.env)
+			if err != nil {
+				l.errs = append(l.errs, FieldError{Key: name, Message: err.Error()})
+			} else if ok {
+				l.set(s, raw, Source{Layer: LayerEnv, Name: name}, name)
+			}
+			continue
+		}
+		if raw, ok := l.lookupEnv(s.env); ok {
+			l.set(s, raw, Source{Layer: LayerEnv, Name: s.env}, s.env)
+		}
+	}
+}
+
+// loadSecrets applies the values provider holds for secret settings
+func (l *loader) loadSecrets(settings []setting, provider SecretProvider) error {
+	var keys []string
+	for _, s := range settings {
+		if s.secret && s.env != "" {
+			keys = append(keys, s.env)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+	values, err := provider.Secrets(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets from %s: %w", provider.Name(), err)
+	}
+	for _, s := range settings {
+		if !s.secret || s.env == "" {
+			continue
+		}
+		if raw, ok := values[s.env]; ok {
+			l.set(s, raw, Source{Layer: LayerSecret, Name: provider.Name()}, s.env)
+		}
+	}
+	return nil
+}
+
+// loadFlags applies command-line flags. Every setting has a flag, and
+// boolean flags may be given without a value.
+func (l *loader) loadFlags(settings []setting, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	values := make([]*flagValue, len(settings))
+	for i, s := range settings {
+		values[i] = &flagValue{isBool: s.value.Kind() == reflect.Bool}
+		fs.Var(values[i], s.flag, s.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("invalid command line: %w", err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("invalid command line: unexpected argument %q", fs.Arg(0))
+	}
+	for i, s := range settings {
+		if values[i].set {
+			name := "--" + s.flag
+			l.set(s, values[i].raw, Source{Layer: LayerFlag, Name: name}, name)
+		}
+	}
+	return nil
+}
+
+// set parses raw into s, recording source, or records a problem under key
+// and leaves s alone
+func (l *loader) set(s setting, raw string, source Source, key string) {
+	value := reflect.New(s.value.Type()).Elem()
+	if err := parseValue(value, raw); err != nil {
+		l.errs = append(l.errs, FieldError{Key: key, Message: err.Error()})
+		return
+	}
+	s.value.Set(value)
+	l.sources[s.path] = source
+}
+
+// flagValue holds the raw value of a flag until every layer below it has
+// been read
+type flagValue struct {
+	raw    string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string { return f.raw }
+
+func (f *flagValue) Set(s string) error {
+	f.raw, f.set = s, true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
+
+// parseValue parses the text of an environment variable or flag into v.
+// Surrounding space is ignored except in strings.
+func parseValue(v reflect.Value, raw string) error {
+	s := strings.TrimSpace(raw)
+	invalid := fmt.Errorf("invalid %s %q", describeType(v.Type()), raw)
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return invalid
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return invalid
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return invalid
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		items := SplitList(s)
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := parseValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, pair := range SplitList(s) {
+			key, value, ok := strings.Cut(pair, "=")
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				return fmt.Errorf("invalid map entry %q: expected key=value", pair)
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := parseValue(elem, strings.TrimSpace(value)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// describeType names the kind of value a setting of type t expects
+func describeType(t reflect.Type) string {
+	if t == durationType {
+		return "duration (e.g. 30s)"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "non-negative integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "list"
+	case reflect.Map:
+		return "map"
+	default:
+		return t.String()
+	}
+}
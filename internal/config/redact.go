@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// RedactedValue stands in for secrets wherever the configuration is shown
+const RedactedValue = "[REDACTED]"
+
+// Redacted returns a copy of the configuration with the values of secret
+// settings replaced by RedactedValue. Secrets that are not set stay empty
+// so that missing ones can still be told apart.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, s := range collectSettings(reflect.ValueOf(&redacted).Elem(), "", "") {
+		if !s.secret || s.value.IsZero() {
+			continue
+		}
+		if s.value.Kind() == reflect.String {
+			s.value.SetString(RedactedValue)
+		} else {
+			s.value.Set(reflect.Zero(s.value.Type()))
+		}
+	}
+	return &redacted
+}
+
+// configFile has the fields of Config without its methods, so that it
+// encodes field by field
+type configFile Config
+
+// MarshalYAML encodes the configuration, redacted, in the layout of the
+// config file
+func (c Config) MarshalYAML() (interface{}, error) {
+	return (*configFile)(c.Redacted()), nil
+}
+
+// MarshalJSON encodes the configuration, redacted, as an object keyed by
+// yaml name with durations written like "30s"
+func (c Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(settingsMap(reflect.ValueOf(c.Redacted()).Elem()))
+}
+
+// String formats the configuration, redacted, as JSON so that it is safe
+// to print and log
+func (c Config) String() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "config.Config{" + err.Error() + "}"
+	}
+	return string(data)
+}
+
+// GoString keeps %#v from printing secrets
+func (c Config) GoString() string {
+	return "config.Config" + c.String()
+}
+
+// settingsMap lists the settings of the struct v by yaml name, nesting
+// sections
+func settingsMap(v reflect.Value) map[string]interface{} {
+	settings := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := settingName(t.Field(i))
+		if !ok {
+			continue
+		}
+		field := v.Field(i)
+		switch {
+		case isSection(field.Type()):
+			settings[name] = settingsMap(field)
+		case field.Type() == durationType:
+			settings[name] = time.Duration(field.Int()).String()
+		default:
+			settings[name] = field.Interface()
+		}
+	}
+	return settings
+}
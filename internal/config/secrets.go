@@ -0,0 +1,199 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// vaultTimeout bounds each request of a VaultSecretProvider without its
+// own client
+const vaultTimeout = 10 * time.Second
+
+// SecretProvider reads the values of secret settings from a secret store.
+// Settings are named by their environment variable, e.g. DATABASE_URL.
+type SecretProvider interface {
+	// Name identifies the provider, and where it reads from, in the
+	// sources of settings
+	Name() string
+	// Secrets returns the values the provider holds for keys; keys it
+	// does not hold are left out
+	Secrets(ctx context.Context, keys []string) (map[string]string, error)
+}
+
+// NewSecretProvider returns the provider selected by SecretsProvider, or
+// nil for "env" since the environment is always read
+func NewSecretProvider(c *Config) (SecretProvider, error) {
+	switch c.SecretsProvider {
+	case "", "env":
+		return nil, nil
+	case "file":
+		return &FileSecretProvider{Dir: c.SecretsDir}, nil
+	case "vault":
+		return &VaultSecretProvider{
+			Addr:  c.VaultAddr,
+			Token: c.VaultToken,
+			Mount: c.VaultKVMount,
+			Path:  c.VaultKVPath,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", c.SecretsProvider)
+	}
+}
+
+// SecretName is the name of a secret setting in files and secret stores:
+// its environment variable in lower case with dashes, e.g. database-url,
+// like the keys of a Kubernetes Secret
+func SecretName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// EnvSecretProvider reads each secret from its environment variable or,
+// when only KEY_FILE is set, from the file it names
+type EnvSecretProvider struct {
+	// LookupEnv reads environment variables; nil means os.LookupEnv
+	LookupEnv func(key string) (string, bool)
+}
+
+func (p *EnvSecretProvider) Name() string { return "env" }
+
+func (p *EnvSecretProvider) Secrets(ctx context.Context, keys []string) (map[string]string, error) {
+	lookup := p.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	values := make(map[string]string)
+	for _, key := range keys {
+		value, name, ok, err := lookupSecretEnv(lookup, key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// lookupSecretEnv reads a secret from the environment variable key or the
+// file named by key_FILE, and returns the name of the variable it used.
+// Setting both is an error, unless key is empty.
+func lookupSecretEnv(lookup func(string) (string, bool), key string) (value, name string, ok bool, err error) {
+	value, direct := lookup(key)
+	path, indirect := lookup(key + "_FILE")
+	if !indirect {
+		return value, key, direct, nil
+	}
+	name = key + "_FILE"
+	if direct && value != "" {
+		return "", name, false, fmt.Errorf("cannot be combined with %s", key)
+	}
+	value, err = readSecretFile(path)
+	if err != nil {
+		return "", name, false, err
+	}
+	return value, name, true, nil
+}
+
+// readSecretFile reads a secret without the line break editors and
+// `echo` leave at its end
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// FileSecretProvider reads each secret from the file in Dir named by
+// SecretName, as a Kubernetes Secret volume lays them out. Secrets without
+// a file are left out.
+type FileSecretProvider struct {
+	Dir string
+}
+
+func (p *FileSecretProvider) Name() string { return "file:" + p.Dir }
+
+func (p *FileSecretProvider) Secrets(ctx context.Context, keys []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, key := range keys {
+		value, err := readSecretFile(filepath.Join(p.Dir, SecretName(key)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// VaultSecretProvider reads secrets from one secret of a Vault KV version
+// 2 engine, or a server with the same API, mounted at Mount. Each secret
+// setting is the field of the secret at Path named by SecretName; fields
+// that are missing or not strings are left out, as is the whole secret
+// when Vault does not have it.
+type VaultSecretProvider struct {
+	Addr  string
+	Token string
+	Mount string
+	Path  string
+	// Client sends the requests; nil means a client with a 10s timeout
+	Client *http.Client
+}
+
+func (p *VaultSecretProvider) Name() string {
+	return "vault:" + strings.Trim(p.Mount, "/") + "/" + strings.Trim(p.Path, "/")
+}
+
+func (p *VaultSecretProvider) Secrets(ctx context.Context, keys []string) (map[string]string, error) {
+	url := strings.TrimRight(p.Addr, "/") + "/v1/" + strings.Trim(p.Mount, "/") + "/data/" + strings.Trim(p.Path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: vaultTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return map[string]string{}, nil
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+	values := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := body.Data.Data[SecretName(key)].(string); ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
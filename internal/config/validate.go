@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// minProductionSecretLength is the shortest JWT_SECRET accepted in
+// production
+const minProductionSecretLength = 32
+
+// FieldError is a problem with one setting, named by its environment
+// variable, or by its flag or file setting when the problem is there
+type FieldError struct {
+	Key     string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Key + ": " + e.Message
+}
+
+// ValidationError reports every problem Validate found, one per line
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problems):", len(e.Errors))
+	for _, err := range e.Errors {
+		b.WriteString("\n  - ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// IsProduction reports whether the configuration is for production, where
+// Validate also requires the database and a strong JWT secret
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// settings that could not be parsed, values out of range,
+// malformed URLs, conflicting ports and, in production, missing required
+// settings. It returns nil when there is nothing to report.
+func (c *Config) Validate() error {
+	v := &validator{errs: append([]FieldError(nil), c.loadErrors...)}
+
+	if c.Environment == "" {
+		v.add("ENVIRONMENT", "must not be empty")
+	}
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		v.add("LOG_LEVEL", fmt.Sprintf("must be one of debug, info, warn, error; got %q", c.LogLevel))
+	}
+
+	// Server
+	v.port("APP_PORT", c.Port)
+	v.port("METRICS_PORT", c.MetricsPort)
+	if c.Port == c.MetricsPort {
+		v.add("METRICS_PORT", fmt.Sprintf("must differ from APP_PORT (both are %d)", c.Port))
+	}
+	v.positive("MAX_HEADER_SIZE", c.MaxHeaderSize)
+	v.positive("MAX_BODY_SIZE", c.MaxBodySize)
+	v.nonNegative("READ_TIMEOUT", c.ReadTimeout)
+	v.nonNegative("WRITE_TIMEOUT", c.WriteTimeout)
+	v.nonNegative("IDLE_TIMEOUT", c.IdleTimeout)
+	v.nonNegative("READ_HEADER_TIMEOUT", c.ReadHeaderTimeout)
+	if c.RequestTimeout < 0 {
+		v.add("MIDDLEWARE_REQUEST_TIMEOUT", "must not be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		v.add("MIDDLEWARE_SHUTDOWN_TIMEOUT", "must be positive")
+	}
+
+	// Dependencies
+	v.url("DATABASE_URL", c.DatabaseURL, "postgres", "postgresql")
+	v.url("REDIS_URL", c.RedisURL, "redis", "rediss", "unix")
+	v.url("OTEL_EXPORTER_OTLP_ENDPOINT", c.TracingEndpoint, "http", "https")
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		v.add("TRACING_SAMPLE_RATIO", fmt.Sprintf("must be between 0 and 1, got %g", c.TracingSampleRatio))
+	}
+
+	// Tokens
+	v.positive("ACCESS_TOKEN_TTL", c.AccessTokenTTL)
+	v.positive("REFRESH_TOKEN_TTL", c.RefreshTokenTTL)
+	if c.RefreshTokenTTL > 0 && c.RefreshTokenTTL < c.AccessTokenTTL {
+		v.add("REFRESH_TOKEN_TTL", "must not be shorter than ACCESS_TOKEN_TTL")
+	}
+	v.nonNegative("JWT_KEY_ROTATION_INTERVAL", c.JWTKeyRotationInterval)
+
+	if c.OIDCIssuerURL != "" {
+		if c.IsProduction() {
+			v.url("OIDC_ISSUER_URL", c.OIDCIssuerURL, "https")
+		} else {
+			v.url("OIDC_ISSUER_URL", c.OIDCIssuerURL, "http", "https")
+		}
+		v.required("OIDC_CLIENT_ID", c.OIDCClientID, "when OIDC_ISSUER_URL is set")
+		v.required("OIDC_REDIRECT_URL", c.OIDCRedirectURL, "when OIDC_ISSUER_URL is set")
+		v.url("OIDC_REDIRECT_URL", c.OIDCRedirectURL, "http", "https")
+	}
+
+	// Limits
+	v.positive("RATE_LIMIT_REQUESTS_PER_MINUTE", c.RateLimitRequestsPerMinute)
+	v.nonNegative("RATE_LIMIT_BURST", c.RateLimitBurst)
+	v.positive("CONCURRENCY_LIMIT_MIN", c.ConcurrencyLimitMin)
+	if c.ConcurrencyLimitMax < c.ConcurrencyLimitMin {
+		v.add("CONCURRENCY_LIMIT_MAX", "must not be less than CONCURRENCY_LIMIT_MIN")
+	}
+	if c.ConcurrencyLimitInitial < c.ConcurrencyLimitMin || c.ConcurrencyLimitInitial > c.ConcurrencyLimitMax {
+		v.add("CONCURRENCY_LIMIT_INITIAL", "must be between CONCURRENCY_LIMIT_MIN and CONCURRENCY_LIMIT_MAX")
+	}
+	v.positive("IDEMPOTENCY_KEY_TTL", c.IdempotencyKeyTTL)
+
+	// HTTP policies
+	v.nonNegative("CORS_MAX_AGE", c.CORSMaxAge)
+	v.nonNegative("SECURITY_HSTS_MAX_AGE", c.SecurityHSTSMaxAge)
+	if c.IPACLReloadInterval < 0 {
+		v.add("IP_ACL_RELOAD_INTERVAL", "must not be negative")
+	}
+	if c.ResponseCacheEnabled && c.ResponseCacheTTL <= 0 {
+		v.add("RESPONSE_CACHE_TTL", "must be positive when RESPONSE_CACHE_ENABLED is set")
+	}
+
+	// Secrets
+	switch c.SecretsProvider {
+	case "env":
+	case "file":
+		v.required("SECRETS_DIR", c.SecretsDir, "when SECRETS_PROVIDER is file")
+	case "vault":
+		v.required("VAULT_ADDR", c.VaultAddr, "when SECRETS_PROVIDER is vault")
+		v.url("VAULT_ADDR", c.VaultAddr, "http", "https")
+		v.required("VAULT_TOKEN", c.VaultToken, "when SECRETS_PROVIDER is vault")
+		v.required("VAULT_KV_MOUNT", c.VaultKVMount, "when SECRETS_PROVIDER is vault")
+		v.required("VAULT_KV_PATH", c.VaultKVPath, "when SECRETS_PROVIDER is vault")
+	default:
+		v.add("SECRETS_PROVIDER", fmt.Sprintf("must be one of env, file, vault; got %q", c.SecretsProvider))
+	}
+	if c.SecretsRefreshInterval < 0 {
+		v.add("SECRETS_REFRESH_INTERVAL", "must not be negative")
+	}
+
+	// Production refuses to start without its dependencies and secrets
+	if c.IsProduction() {
+		v.required("DATABASE_URL", c.DatabaseURL, "in production")
+		v.required("JWT_SECRET", c.JWTSecret, "in production")
+		if c.JWTSecret != "" && len(c.JWTSecret) < minProductionSecretLength {
+			v.add("JWT_SECRET", fmt.Sprintf("must be at least %d characters in production", minProductionSecretLength))
+		}
+	}
+
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
+
+// validator accumulates the problems found by Validate
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) add(key, message string) {
+	v.errs = append(v.errs, FieldError{Key: key, Message: message})
+}
+
+func (v *validator) required(key, value, when string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(key, "is required "+when)
+	}
+}
+
+func (v *validator) port(key string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(key, fmt.Sprintf("must be between 1 and 65535, got %d", port))
+	}
+}
+
+func (v *validator) positive(key string, n int) {
+	if n <= 0 {
+		v.add(key, fmt.Sprintf("must be positive, got %d", n))
+	}
+}
+
+func (v *validator) nonNegative(key string, n int) {
+	if n < 0 {
+		v.add(key, fmt.Sprintf("must not be negative, got %d", n))
+	}
+}
+
+// url checks that a non-empty value is an absolute URL with one of
+// schemes. The value is left out of the message since it may hold
+// credentials.
+func (v *validator) url(key, value string, schemes ...string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add(key, "is not a valid URL")
+		return
+	}
+	scheme := strings.ToLower(u.Scheme)
+	valid := false
+	for _, s := range schemes {
+		if scheme == s {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		names := strings.Join(schemes, ", ")
+		if n := len(schemes); n > 1 {
+			names = strings.Join(schemes[:n-1], ", ") + " or " + schemes[n-1]
+		}
+		v.add(key, "must be a "+names+" URL")
+		return
+	}
+	if u.Host == "" && scheme != "unix" {
+		v.add(key, "must include a host")
+	}
+}
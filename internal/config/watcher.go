@@ -0,0 +1,180 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce is how long Watch waits for a burst of file events to end
+// before reloading
+const reloadDebounce = 100 * time.Millisecond
+
+// configMapDataDir is the symlink Kubernetes swaps to update the files of
+// a mounted ConfigMap
+const configMapDataDir = "..data"
+
+// ReloadResult describes a reload by the yaml path of settings: Changed
+// lists the live settings that took new values and Rejected the settings
+// that changed but need a restart, which keep their running values
+type ReloadResult struct {
+	Changed  []string
+	Rejected []string
+}
+
+// Watcher holds the running configuration and reloads it from its layers.
+// Reloads apply changes to live settings and notify subscribers; changes
+// to the other settings are rejected until the server restarts.
+type Watcher struct {
+	opts    Options
+	current atomic.Pointer[Config]
+
+	mu          sync.Mutex
+	subscribers []func(old, new *Config)
+}
+
+// NewWatcher creates a Watcher running cfg, which was loaded with opts
+func NewWatcher(cfg *Config, opts Options) *Watcher {
+	w := &Watcher{opts: opts}
+	w.current.Store(cfg)
+	return w
+}
+
+// Current returns the running configuration. It must not be modified.
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// Subscribe registers fn to be called with the previous and the new
+// configuration after each reload that changes live settings.
+// Subscribers are called in order and must not call Subscribe or Reload.
+func (w *Watcher) Subscribe(fn func(old, new *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Reload loads the configuration again and swaps it in if it is valid.
+// On error the running configuration stays in place.
+func (w *Watcher) Reload() (ReloadResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := LoadWith(w.opts)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	if err := next.Validate(); err != nil {
+		return ReloadResult{}, err
+	}
+
+	var result ReloadResult
+	old := w.current.Load()
+	oldSettings := collectSettings(reflect.ValueOf(old).Elem(), "", "")
+	nextSettings := collectSettings(reflect.ValueOf(next).Elem(), "", "")
+
+	// Keep the running values of restart-only settings, then settle the
+	// defaults that depend on them
+	for i, s := range nextSettings {
+		if !s.live && !reflect.DeepEqual(s.value.Interface(), oldSettings[i].value.Interface()) {
+			result.Rejected = append(result.Rejected, s.path)
+			s.value.Set(oldSettings[i].value)
+			next.sources[s.path] = old.sources[s.path]
+		}
+	}
+	next.applyEnvironmentDefaults()
+	for i, s := range nextSettings {
+		if s.live && !reflect.DeepEqual(s.value.Interface(), oldSettings[i].value.Interface()) {
+			result.Changed = append(result.Changed, s.path)
+		}
+	}
+
+	w.current.Store(next)
+	if len(result.Changed) > 0 {
+		for _, fn := range w.subscribers {
+			fn(old, next)
+		}
+	}
+	return result, nil
+}
+
+// Watch reloads the configuration on SIGHUP, every SecretsRefreshInterval
+// and, when it has a file, when the file changes, until ctx is done. The
+// directory of the file is watched since editors and ConfigMap updates
+// replace the file rather than write to it. Each reload, and each error
+// watching the file, is passed to onReload.
+func (w *Watcher) Watch(ctx context.Context, onReload func(result ReloadResult, err error)) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if w.opts.File != "" {
+		fw, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("failed to watch config file: %w", err)
+		}
+		defer fw.Close()
+		if err := fw.Add(filepath.Dir(w.opts.File)); err != nil {
+			return fmt.Errorf("failed to watch config file: %w", err)
+		}
+		events, errs = fw.Events, fw.Errors
+	}
+
+	var refresh <-chan time.Time
+	if interval := w.Current().SecretsRefreshInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			onReload(w.Reload())
+		case <-refresh:
+			onReload(w.Reload())
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if w.isFileEvent(event) {
+				debounce.Reset(reloadDebounce)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			onReload(ReloadResult{}, fmt.Errorf("failed to watch config file: %w", err))
+		case <-debounce.C:
+			onReload(w.Reload())
+		}
+	}
+}
+
+// isFileEvent reports whether event may have changed the config file
+func (w *Watcher) isFileEvent(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Clean(event.Name)
+	return name == filepath.Clean(w.opts.File) || filepath.Base(name) == configMapDataDir
+}
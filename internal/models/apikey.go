@@ -0,0 +1,151 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key owner types
+const (
+	OwnerTypeUser           = "user"
+	OwnerTypeServiceAccount = "service_account"
+)
+
+// API key scopes
+const (
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeAPIKeysManage = "api-keys:manage"
+	ScopeAdmin         = "admin"
+)
+
+// APIKey represents a hashed API key linked to a user or service account
+type APIKey struct {
+	ID         string     `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	Hash       string     `json:"-" db:"hash"`
+	OwnerID    string     `json:"owner_id" db:"owner_id"`
+	OwnerType  string     `json:"owner_type" db:"owner_type"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ReplacedBy string     `json:"replaced_by,omitempty" db:"replaced_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+
+	// RateLimit is the key's own quota in requests per minute, used
+	// instead of the default rate limit; zero uses the default
+	RateLimit int `json:"rate_limit,omitempty" db:"rate_limit"`
+}
+
+// NewAPIKey creates a new API key record with generated ID
+func NewAPIKey(name, prefix, hash, ownerID, ownerType string, scopes []string, expiresAt *time.Time) *APIKey {
+	now := time.Now().UTC()
+	return &APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		OwnerID:   ownerID,
+		OwnerType: ownerType,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// IsExpired reports whether the key has passed its expiry time
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// IsRevoked reports whether the key has been revoked
+func (k *APIKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+// HasScope reports whether the key grants the given scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyCreateRequest represents a request to create an API key
+type APIKeyCreateRequest struct {
+	Name      string     `json:"name" validate:"required,min=2,max=100"`
+	OwnerID   string     `json:"owner_id" validate:"omitempty"`
+	OwnerType string     `json:"owner_type" validate:"omitempty,oneof=user service_account"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty"`
+	RateLimit int        `json:"rate_limit,omitempty" validate:"omitempty,min=0"`
+}
+
+// APIKeyUpdateRequest represents a request to update an API key
+type APIKeyUpdateRequest struct {
+	Name      *string    `json:"name" validate:"omitempty,min=2,max=100"`
+	Scopes    []string   `json:"scopes" validate:"omitempty,min=1"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitempty"`
+	RateLimit *int       `json:"rate_limit" validate:"omitempty,min=0"`
+}
+
+// APIKeyRotateRequest represents a request to rotate an API key
+type APIKeyRotateRequest struct {
+	OverlapSeconds *int `json:"overlap_seconds" validate:"omitempty,min=0"`
+}
+
+// APIKeyResponse represents an API key API response
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	OwnerID    string     `json:"owner_id"`
+	OwnerType  string     `json:"owner_type"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	ReplacedBy string     `json:"replaced_by,omitempty"`
+	RateLimit  int        `json:"rate_limit,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ToResponse converts an APIKey to APIKeyResponse
+func (k *APIKey) ToResponse() *APIKeyResponse {
+	return &APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		OwnerID:    k.OwnerID,
+		OwnerType:  k.OwnerType,
+		Scopes:     k.Scopes,
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		ReplacedBy: k.ReplacedBy,
+		RateLimit:  k.RateLimit,
+		CreatedAt:  k.CreatedAt,
+		UpdatedAt:  k.UpdatedAt,
+	}
+}
+
+// APIKeySecretResponse is returned once when a key is created or rotated.
+// The plaintext key is never stored and cannot be retrieved again.
+type APIKeySecretResponse struct {
+	*APIKeyResponse
+	Key string `json:"key"`
+}
+
+// APIKeyListResponse represents a list of API keys
+type APIKeyListResponse struct {
+	APIKeys []*APIKeyResponse `json:"api_keys"`
+	Total   int               `json:"total"`
+}
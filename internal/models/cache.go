@@ -0,0 +1,63 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// UsersSurrogateKey tags every cached response listing users
+const UsersSurrogateKey = "users"
+
+// UserSurrogateKey tags every cached response that shows the user with id
+func UserSurrogateKey(id string) string {
+	return "user:" + id
+}
+
+// CachedResponse is a response stored by the response cache. Keys are its
+// surrogate keys, which purging invalidates it by.
+type CachedResponse struct {
+	StatusCode int                 `json:"status_code"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       []byte              `json:"body,omitempty"`
+	Keys       []string            `json:"keys,omitempty"`
+}
+
+// ETag returns a strong entity tag that changes whenever the user is
+// updated
+func (u *UserResponse) ETag() string {
+	h := sha256.New()
+	writeVersion(h, u.ID, u.UpdatedAt)
+	return entityTag(h.Sum(nil))
+}
+
+// ETag returns a strong entity tag that changes whenever a user on the
+// page is updated or users are added or removed
+func (l *UserListResponse) ETag() string {
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(l.Page) + "/" + strconv.Itoa(l.PageSize) + "/" + strconv.Itoa(l.Total) + "\n"))
+	for _, u := range l.Users {
+		writeVersion(h, u.ID, u.UpdatedAt)
+	}
+	return entityTag(h.Sum(nil))
+}
+
+// LastModified returns the latest update of a user on the page
+func (l *UserListResponse) LastModified() time.Time {
+	var latest time.Time
+	for _, u := range l.Users {
+		if u.UpdatedAt.After(latest) {
+			latest = u.UpdatedAt
+		}
+	}
+	return latest
+}
+
+func writeVersion(h interface{ Write([]byte) (int, error) }, id string, updatedAt time.Time) {
+	h.Write([]byte(id + "@" + strconv.FormatInt(updatedAt.UnixNano(), 10) + "\n"))
+}
+
+func entityTag(sum []byte) string {
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,23 @@
+package models
+
+// FeatureFlagResponse represents a feature flag API response. Override is
+// set when an admin has turned the flag on or off for everyone.
+type FeatureFlagResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Enabled     bool     `json:"enabled"`
+	Roles       []string `json:"roles,omitempty"`
+	Tenants     []string `json:"tenants,omitempty"`
+	Percentage  int      `json:"percentage,omitempty"`
+	Override    *bool    `json:"override,omitempty"`
+}
+
+// FeatureFlagListResponse represents a list of feature flags
+type FeatureFlagListResponse struct {
+	Flags []*FeatureFlagResponse `json:"flags"`
+}
+
+// FeatureFlagOverrideRequest represents a runtime flag override request
+type FeatureFlagOverrideRequest struct {
+	Enabled *bool `json:"enabled"`
+}
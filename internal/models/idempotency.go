@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key. A record that is not Completed marks a request still in
+// flight.
+type IdempotencyRecord struct {
+	Key         string              `json:"key" db:"key"`
+	Fingerprint string              `json:"fingerprint" db:"fingerprint"`
+	Completed   bool                `json:"completed" db:"completed"`
+	StatusCode  int                 `json:"status_code,omitempty" db:"status_code"`
+	Header      map[string][]string `json:"header,omitempty" db:"header"`
+	Body        []byte              `json:"body,omitempty" db:"body"`
+	CreatedAt   time.Time           `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// ProblemContentType is the media type of ProblemDetails
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is an RFC 9457 problem details response. RequestID and
+// TraceID let callers quote the failed request to operators.
+type ProblemDetails struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// Signing key lifecycle states. A key is published as next before it
+// signs anything, so verifiers that cache the JWKS already know it when it
+// becomes active. After rotation the old active key is kept as previous
+// until every token it signed has expired, and is then retired.
+const (
+	SigningKeyNext     = "next"
+	SigningKeyActive   = "active"
+	SigningKeyPrevious = "previous"
+	SigningKeyRetired  = "retired"
+)
+
+// SigningKey is an asymmetric key used to sign access tokens
+type SigningKey struct {
+	ID          string     `json:"id" db:"id"`
+	Algorithm   string     `json:"algorithm" db:"algorithm"`
+	Status      string     `json:"status" db:"status"`
+	PrivateKey  string     `json:"-" db:"private_key"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty" db:"activated_at"`
+	RetireAt    *time.Time `json:"retire_at,omitempty" db:"retire_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty" db:"retired_at"`
+}
+
+// CanVerify reports whether tokens signed with the key are still accepted
+func (k *SigningKey) CanVerify() bool {
+	return k.Status != SigningKeyRetired
+}
+
+// SigningKeyResponse represents a signing key API response
+type SigningKeyResponse struct {
+	ID          string     `json:"id"`
+	Algorithm   string     `json:"algorithm"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetireAt    *time.Time `json:"retire_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+}
+
+// ToResponse converts a SigningKey to SigningKeyResponse
+func (k *SigningKey) ToResponse() *SigningKeyResponse {
+	return &SigningKeyResponse{
+		ID:          k.ID,
+		Algorithm:   k.Algorithm,
+		Status:      k.Status,
+		CreatedAt:   k.CreatedAt,
+		ActivatedAt: k.ActivatedAt,
+		RetireAt:    k.RetireAt,
+		RetiredAt:   k.RetiredAt,
+	}
+}
+
+// SigningKeyListResponse represents a list of signing keys
+type SigningKeyListResponse struct {
+	Keys []*SigningKeyResponse `json:"keys"`
+}
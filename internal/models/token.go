@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuth2 grant types accepted by the token endpoint
+const (
+	GrantTypePassword     = "password"
+	GrantTypeRefreshToken = "refresh_token"
+)
+
+// RefreshToken represents a hashed, single-use refresh token. Tokens issued
+// from the same login share a FamilyID so that reuse of a rotated token can
+// revoke the whole chain.
+type RefreshToken struct {
+	ID         string     `json:"id" db:"id"`
+	FamilyID   string     `json:"family_id" db:"family_id"`
+	UserID     string     `json:"user_id" db:"user_id"`
+	Hash       string     `json:"-" db:"hash"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	UsedAt     *time.Time `json:"used_at,omitempty" db:"used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	ReplacedBy string     `json:"replaced_by,omitempty" db:"replaced_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// NewRefreshToken creates a new refresh token record. An empty familyID
+// starts a new family.
+func NewRefreshToken(familyID, userID, hash string, ttl time.Duration) *RefreshToken {
+	now := time.Now().UTC()
+	id := uuid.New().String()
+	if familyID == "" {
+		familyID = id
+	}
+	return &RefreshToken{
+		ID:        id,
+		FamilyID:  familyID,
+		UserID:    userID,
+		Hash:      hash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// IsActive reports whether the token can still be exchanged
+func (t *RefreshToken) IsActive(now time.Time) bool {
+	return t.UsedAt == nil && t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// TokenRequest represents a request to the token endpoint
+type TokenRequest struct {
+	GrantType    string `json:"grant_type" validate:"required,oneof=password refresh_token"`
+	Email        string `json:"email" validate:"required_if=GrantType password,omitempty,email"`
+	Password     string `json:"password" validate:"required_if=GrantType password"`
+	RefreshToken string `json:"refresh_token" validate:"required_if=GrantType refresh_token"`
+}
+
+// LogoutRequest represents a request to revoke a refresh token family
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// TokenResponse represents an OAuth2-style token response
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope,omitempty"`
+}
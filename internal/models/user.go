@@ -15,6 +15,15 @@ type User struct {
 	Active    bool      `json:"active" db:"active"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// PasswordHash is an argon2id or bcrypt hash; empty when the user has
+	// no local password
+	PasswordHash string `json:"-" db:"password_hash"`
+
+	// OIDCIssuer and OIDCSubject identify the IdP account linked to the
+	// user; empty when no account is linked
+	OIDCIssuer  string `json:"-" db:"oidc_issuer"`
+	OIDCSubject string `json:"-" db:"oidc_subject"`
 }
 
 // NewUser creates a new user with generated ID
@@ -36,6 +45,8 @@ type UserCreateRequest struct {
 	Email string `json:"email" validate:"required,email"`
 	Name  string `json:"name" validate:"required,min=2,max=100"`
 	Role  string `json:"role" validate:"required,oneof=admin user viewer"`
+
+	Password string `json:"password,omitempty" validate:"omitempty,min=12,max=128"`
 }
 
 // UserUpdateRequest represents a request to update a user
@@ -44,6 +55,8 @@ type UserUpdateRequest struct {
 	Name  *string `json:"name" validate:"omitempty,min=2,max=100"`
 	Role  *string `json:"role" validate:"omitempty,oneof=admin user viewer"`
 	Active *bool  `json:"active" validate:"omitempty"`
+
+	Password *string `json:"password,omitempty" validate:"omitempty,min=12,max=128"`
 }
 
 // UserResponse represents a user API response
@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+)
+
+// APIKeyRepository defines the interface for API key data access
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByID(ctx context.Context, id string) (*models.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	ListByOwner(ctx context.Context, ownerID string) ([]*models.APIKey, error)
+	List(ctx context.Context) ([]*models.APIKey, error)
+	Update(ctx context.Context, key *models.APIKey) error
+	TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error
+	Delete(ctx context.Context, id string) error
+}
+
+// PostgresAPIKeyRepository implements APIKeyRepository for PostgreSQL.
+// Scopes are stored space-delimited in a single TEXT column.
+type PostgresAPIKeyRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresAPIKeyRepository creates a new PostgreSQL API key repository
+func NewPostgresAPIKeyRepository(db *sql.DB) *PostgresAPIKeyRepository {
+	return &PostgresAPIKeyRepository{db: db}
+}
+
+const apiKeyColumns = `id, name, prefix, hash, owner_id, owner_type, scopes,
+		expires_at, last_used_at, revoked_at, replaced_by, created_at, updated_at, rate_limit`
+
+// Create creates a new API key
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	query := `
+		INSERT INTO api_keys (` + apiKeyColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		key.ID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		key.OwnerID,
+		key.OwnerType,
+		strings.Join(key.Scopes, " "),
+		key.ExpiresAt,
+		key.LastUsedAt,
+		key.RevokedAt,
+		key.ReplacedBy,
+		key.CreatedAt,
+		key.UpdatedAt,
+		key.RateLimit,
+	)
+	return err
+}
+
+// GetByID retrieves an API key by ID
+func (r *PostgresAPIKeyRepository) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+	return r.getOne(ctx, query, id)
+}
+
+// GetByPrefix retrieves an API key by its visible prefix
+func (r *PostgresAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE prefix = $1`
+	return r.getOne(ctx, query, prefix)
+}
+
+// ListByOwner retrieves all API keys belonging to an owner
+func (r *PostgresAPIKeyRepository) ListByOwner(ctx context.Context, ownerID string) ([]*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE owner_id = $1 ORDER BY created_at DESC`
+	return r.getMany(ctx, query, ownerID)
+}
+
+// List retrieves all API keys
+func (r *PostgresAPIKeyRepository) List(ctx context.Context) ([]*models.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY created_at DESC`
+	return r.getMany(ctx, query)
+}
+
+// Update updates an existing API key
+func (r *PostgresAPIKeyRepository) Update(ctx context.Context, key *models.APIKey) error {
+	query := `
+		UPDATE api_keys
+		SET name = $1, scopes = $2, expires_at = $3, revoked_at = $4, replaced_by = $5, updated_at = $6,
+			rate_limit = $7
+		WHERE id = $8
+	`
+	key.UpdatedAt = time.Now().UTC()
+	_, err := r.db.ExecContext(ctx, query,
+		key.Name,
+		strings.Join(key.Scopes, " "),
+		key.ExpiresAt,
+		key.RevokedAt,
+		key.ReplacedBy,
+		key.UpdatedAt,
+		key.RateLimit,
+		key.ID,
+	)
+	return err
+}
+
+// TouchLastUsed records when an API key was last used
+func (r *PostgresAPIKeyRepository) TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, usedAt, id)
+	return err
+}
+
+// Delete deletes an API key by ID
+func (r *PostgresAPIKeyRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM api_keys WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+func (r *PostgresAPIKeyRepository) getOne(ctx context.Context, query string, args ...interface{}) (*models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *PostgresAPIKeyRepository) getMany(ctx context.Context, query string, args ...interface{}) ([]*models.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		&key.OwnerID,
+		&key.OwnerType,
+		&scopes,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.ReplacedBy,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+		&key.RateLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	return key, nil
+}
+
+// InMemoryAPIKeyRepository provides a simple in-memory implementation for testing
+type InMemoryAPIKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]*models.APIKey
+}
+
+// NewInMemoryAPIKeyRepository creates a new in-memory API key repository
+func NewInMemoryAPIKeyRepository() *InMemoryAPIKeyRepository {
+	return &InMemoryAPIKeyRepository{
+		keys: make(map[string]*models.APIKey),
+	}
+}
+
+// Create creates a new API key
+func (r *InMemoryAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *key
+	r.keys[key.ID] = &copied
+	return nil
+}
+
+// GetByID retrieves an API key by ID
+func (r *InMemoryAPIKeyRepository) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	key, exists := r.keys[id]
+	if !exists {
+		return nil, nil
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// GetByPrefix retrieves an API key by its visible prefix
+func (r *InMemoryAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListByOwner retrieves all API keys belonging to an owner
+func (r *InMemoryAPIKeyRepository) ListByOwner(ctx context.Context, ownerID string) ([]*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]*models.APIKey, 0)
+	for _, key := range r.keys {
+		if key.OwnerID == ownerID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	return keys, nil
+}
+
+// List retrieves all API keys
+func (r *InMemoryAPIKeyRepository) List(ctx context.Context) ([]*models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]*models.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	return keys, nil
+}
+
+// Update updates an existing API key
+func (r *InMemoryAPIKeyRepository) Update(ctx context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key.UpdatedAt = time.Now().UTC()
+	copied := *key
+	r.keys[key.ID] = &copied
+	return nil
+}
+
+// TouchLastUsed records when an API key was last used
+func (r *InMemoryAPIKeyRepository) TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, exists := r.keys[id]; exists {
+		key.LastUsedAt = &usedAt
+	}
+	return nil
+}
+
+// Delete deletes an API key by ID
+func (r *InMemoryAPIKeyRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, id)
+	return nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync/atomic"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// DSNConnector opens PostgreSQL connections with the connection string it
+// holds when each connection is made, so that rotated credentials are used
+// by new connections without reopening the pool. Use it with sql.OpenDB.
+type DSNConnector struct {
+	current atomic.Pointer[driver.Connector]
+}
+
+// NewDSNConnector creates a connector for the connection string dsn
+func NewDSNConnector(dsn string) (*DSNConnector, error) {
+	c := &DSNConnector{}
+	if err := c.SetDSN(dsn); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetDSN replaces the connection string used by new connections. Open
+// connections keep theirs until the pool closes them. An invalid
+// connection string is rejected and the current one kept.
+func (c *DSNConnector) SetDSN(dsn string) error {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return err
+	}
+	connector := stdlib.GetConnector(*config)
+	c.current.Store(&connector)
+	return nil
+}
+
+// Connect opens a connection with the current connection string
+func (c *DSNConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return (*c.current.Load()).Connect(ctx)
+}
+
+// Driver returns the pgx driver
+func (c *DSNConnector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
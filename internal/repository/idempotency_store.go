@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyStore records the responses of requests sent with an
+// Idempotency-Key so that retries can be answered without running the
+// request again
+type IdempotencyStore interface {
+	// Begin reserves key for a request with the given fingerprint for up
+	// to lockTTL. It returns nil when the caller now owns the key, and the
+	// existing record, completed or in flight, otherwise.
+	Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (*models.IdempotencyRecord, error)
+	// Complete stores the response for a reserved key for ttl
+	Complete(ctx context.Context, record *models.IdempotencyRecord, ttl time.Duration) error
+	// Release drops a reservation that was not completed so the request
+	// can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// InMemoryIdempotencyStore provides an in-memory idempotency store for
+// single-instance deployments and testing
+type InMemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]idempotencyEntry
+	now     func() time.Time
+}
+
+type idempotencyEntry struct {
+	record    models.IdempotencyRecord
+	expiresAt time.Time
+}
+
+// NewInMemoryIdempotencyStore creates a new in-memory idempotency store
+func NewInMemoryIdempotencyStore() *InMemoryIdempotencyStore {
+	return &InMemoryIdempotencyStore{
+		records: make(map[string]idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Begin reserves key unless it is already in use
+func (s *InMemoryIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.purge(now)
+
+	if entry, ok := s.records[key]; ok {
+		record := entry.record
+		return &record, nil
+	}
+	s.records[key] = idempotencyEntry{
+		record: models.IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint,
+			CreatedAt:   now.UTC(),
+		},
+		expiresAt: now.Add(lockTTL),
+	}
+	return nil, nil
+}
+
+// Complete stores the response for a reserved key
+func (s *InMemoryIdempotencyStore) Complete(ctx context.Context, record *models.IdempotencyRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *record
+	stored.Completed = true
+	s.records[record.Key] = idempotencyEntry{record: stored, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+// Release drops an uncompleted reservation
+func (s *InMemoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.records[key]; ok && !entry.record.Completed {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// purge drops expired records; callers must hold mu
+func (s *InMemoryIdempotencyStore) purge(now time.Time) {
+	for key, entry := range s.records {
+		if !now.Before(entry.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+// PostgresIdempotencyStore implements IdempotencyStore for PostgreSQL.
+// Headers are stored as JSONB.
+type PostgresIdempotencyStore struct {
+	db *sql.DB
+}
+
+// NewPostgresIdempotencyStore creates a new PostgreSQL idempotency store
+func NewPostgresIdempotencyStore(db *sql.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+// Begin reserves key unless it is already in use. Expired records are
+// taken over in the same statement.
+func (s *PostgresIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (*models.IdempotencyRecord, error) {
+	now := time.Now().UTC()
+	query := `
+		INSERT INTO idempotency_keys (key, fingerprint, completed, created_at, expires_at)
+		VALUES ($1, $2, FALSE, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			completed = FALSE,
+			status_code = NULL,
+			header = NULL,
+			body = NULL,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+		RETURNING key
+	`
+	var reserved string
+	err := s.db.QueryRowContext(ctx, query, key, fingerprint, now, now.Add(lockTTL)).Scan(&reserved)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	query = `
+		SELECT key, fingerprint, completed, COALESCE(status_code, 0), header, body, created_at
+		FROM idempotency_keys
+		WHERE key = $1
+	`
+	record := &models.IdempotencyRecord{}
+	var header []byte
+	err = s.db.QueryRowContext(ctx, query, key).Scan(
+		&record.Key,
+		&record.Fingerprint,
+		&record.Completed,
+		&record.StatusCode,
+		&header,
+		&record.Body,
+		&record.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		// Released between the two statements
+		return s.Begin(ctx, key, fingerprint, lockTTL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		if err := json.Unmarshal(header, &record.Header); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+// Complete stores the response for a reserved key
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, record *models.IdempotencyRecord, ttl time.Duration) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE idempotency_keys
+		SET completed = TRUE, status_code = $2, header = $3, body = $4, expires_at = $5
+		WHERE key = $1
+	`
+	_, err = s.db.ExecContext(ctx, query, record.Key, record.StatusCode, header, record.Body, time.Now().UTC().Add(ttl))
+	return err
+}
+
+// Release drops an uncompleted reservation
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed`, key)
+	return err
+}
+
+// RedisIdempotencyStore implements IdempotencyStore on Redis so that
+// retries reaching another replica are recognised. Records are stored as
+// JSON and expire with Redis TTLs.
+type RedisIdempotencyStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisIdempotencyStore creates a Redis idempotency store. Keys are
+// namespaced with prefix.
+func NewRedisIdempotencyStore(client redis.UniversalClient, prefix string) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Begin reserves key unless it is already in use
+func (s *RedisIdempotencyStore) Begin(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (*models.IdempotencyRecord, error) {
+	pending, err := json.Marshal(&models.IdempotencyRecord{
+		Key:         key,
+		Fingerprint: fingerprint,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		reserved, err := s.client.SetNX(ctx, s.key(key), pending, lockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			return nil, nil
+		}
+
+		data, err := s.client.Get(ctx, s.key(key)).Bytes()
+		if err == redis.Nil {
+			// Released or expired since SETNX; try to reserve again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := &models.IdempotencyRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+}
+
+// Complete stores the response for a reserved key
+func (s *RedisIdempotencyStore) Complete(ctx context.Context, record *models.IdempotencyRecord, ttl time.Duration) error {
+	stored := *record
+	stored.Completed = true
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.key(record.Key), data, ttl).Err()
+}
+
+// releaseScript deletes a record only while it is still in flight
+var releaseScript = redis.NewScript(`
+local data = redis.call("GET", KEYS[1])
+if data and not cjson.decode(data).completed then
+  return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Release drops an uncompleted reservation
+func (s *RedisIdempotencyStore) Release(ctx context.Context, key string) error {
+	return releaseScript.Run(ctx, s.client, []string{s.key(key)}).Err()
+}
+
+func (s *RedisIdempotencyStore) key(key string) string {
+	return s.prefix + "idempotency:" + key
+}
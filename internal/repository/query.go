@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/pipeline-arch/app/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startQuery starts the client span of a database operation. The
+// statement is recorded as db.statement with its literals redacted.
+func startQuery(ctx context.Context, operation, statement string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", operation),
+			attribute.String("db.statement", tracing.RedactSQL(statement)),
+		),
+	)
+}
+
+// finishQuery ends the span of a database operation and logs it with the
+// request and trace IDs of ctx: failures at warn level, everything else at
+// debug level. sql.ErrNoRows is not a failure.
+func finishQuery(ctx context.Context, span trace.Span, operation string, start time.Time, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	tracing.End(span, err)
+
+	log := logger.FromContext(ctx)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("operation", operation).
+			Dur("duration", time.Since(start)).
+			Msg("database query failed")
+		return
+	}
+	log.Debug().
+		Str("operation", operation).
+		Dur("duration", time.Since(start)).
+		Msg("database query")
+}
@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/resilience"
+)
+
+// The resilient repositories wrap another implementation in a resilience
+// policy. Reads are retried; writes only go through the bulkhead and
+// breaker since repeating them is not always safe.
+
+// ResilientUserRepository wraps a UserRepository in a resilience policy
+type ResilientUserRepository struct {
+	repo   UserRepository
+	policy *resilience.Policy
+}
+
+// NewResilientUserRepository creates a new resilient user repository
+func NewResilientUserRepository(repo UserRepository, policy *resilience.Policy) *ResilientUserRepository {
+	return &ResilientUserRepository{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+// Create creates a new user
+func (r *ResilientUserRepository) Create(ctx context.Context, user *models.User) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Create(ctx, user)
+	})
+}
+
+// GetByID retrieves a user by ID
+func (r *ResilientUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (*models.User, error) {
+		return r.repo.GetByID(ctx, id)
+	})
+}
+
+// GetByEmail retrieves a user by email
+func (r *ResilientUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (*models.User, error) {
+		return r.repo.GetByEmail(ctx, email)
+	})
+}
+
+// GetByIdentity retrieves the user linked to an IdP account
+func (r *ResilientUserRepository) GetByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (*models.User, error) {
+		return r.repo.GetByIdentity(ctx, issuer, subject)
+	})
+}
+
+// Update updates an existing user
+func (r *ResilientUserRepository) Update(ctx context.Context, user *models.User) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Update(ctx, user)
+	})
+}
+
+// Delete deletes a user by ID
+func (r *ResilientUserRepository) Delete(ctx context.Context, id string) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Delete(ctx, id)
+	})
+}
+
+// List retrieves a list of users
+func (r *ResilientUserRepository) List(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) ([]*models.User, error) {
+		return r.repo.List(ctx, limit, offset)
+	})
+}
+
+// Count returns the total number of users
+func (r *ResilientUserRepository) Count(ctx context.Context) (int, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (int, error) {
+		return r.repo.Count(ctx)
+	})
+}
+
+// Close closes the wrapped repository
+func (r *ResilientUserRepository) Close() error {
+	return r.repo.Close()
+}
+
+// ResilientAPIKeyRepository wraps an APIKeyRepository in a resilience policy
+type ResilientAPIKeyRepository struct {
+	repo   APIKeyRepository
+	policy *resilience.Policy
+}
+
+// NewResilientAPIKeyRepository creates a new resilient API key repository
+func NewResilientAPIKeyRepository(repo APIKeyRepository, policy *resilience.Policy) *ResilientAPIKeyRepository {
+	return &ResilientAPIKeyRepository{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+// Create creates a new API key
+func (r *ResilientAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Create(ctx, key)
+	})
+}
+
+// GetByID retrieves an API key by ID
+func (r *ResilientAPIKeyRepository) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (*models.APIKey, error) {
+		return r.repo.GetByID(ctx, id)
+	})
+}
+
+// GetByPrefix retrieves an API key by its visible prefix
+func (r *ResilientAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (*models.APIKey, error) {
+		return r.repo.GetByPrefix(ctx, prefix)
+	})
+}
+
+// ListByOwner retrieves all API keys belonging to an owner
+func (r *ResilientAPIKeyRepository) ListByOwner(ctx context.Context, ownerID string) ([]*models.APIKey, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) ([]*models.APIKey, error) {
+		return r.repo.ListByOwner(ctx, ownerID)
+	})
+}
+
+// List retrieves all API keys
+func (r *ResilientAPIKeyRepository) List(ctx context.Context) ([]*models.APIKey, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) ([]*models.APIKey, error) {
+		return r.repo.List(ctx)
+	})
+}
+
+// Update updates an existing API key
+func (r *ResilientAPIKeyRepository) Update(ctx context.Context, key *models.APIKey) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Update(ctx, key)
+	})
+}
+
+// TouchLastUsed records when an API key was last used. It is idempotent,
+// so it is retried.
+func (r *ResilientAPIKeyRepository) TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error {
+	return r.policy.Execute(ctx, func(ctx context.Context) error {
+		return r.repo.TouchLastUsed(ctx, id, usedAt)
+	})
+}
+
+// Delete deletes an API key by ID
+func (r *ResilientAPIKeyRepository) Delete(ctx context.Context, id string) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Delete(ctx, id)
+	})
+}
+
+// ResilientRefreshTokenRepository wraps a RefreshTokenRepository in a
+// resilience policy
+type ResilientRefreshTokenRepository struct {
+	repo   RefreshTokenRepository
+	policy *resilience.Policy
+}
+
+// NewResilientRefreshTokenRepository creates a new resilient refresh token
+// repository
+func NewResilientRefreshTokenRepository(repo RefreshTokenRepository, policy *resilience.Policy) *ResilientRefreshTokenRepository {
+	return &ResilientRefreshTokenRepository{
+		repo:   repo,
+		policy: policy,
+	}
+}
+
+// Create creates a new refresh token
+func (r *ResilientRefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	return r.policy.ExecuteOnce(ctx, func(ctx context.Context) error {
+		return r.repo.Create(ctx, token)
+	})
+}
+
+// GetByHash retrieves a refresh token by the hash of its value
+func (r *ResilientRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	return resilience.Do(ctx, r.policy, func(ctx context.Context) (*models.RefreshToken, error) {
+		return r.repo.GetByHash(ctx, hash)
+	})
+}
+
+// MarkUsed marks an unused token as used. It is not retried: a retry after
+// a lost acknowledgement would report reuse.
+func (r *ResilientRefreshTokenRepository) MarkUsed(ctx context.Context, id, replacedBy string, usedAt time.Time) (bool, error) {
+	return resilience.DoOnce(ctx, r.policy, func(ctx context.Context) (bool, error) {
+		return r.repo.MarkUsed(ctx, id, replacedBy, usedAt)
+	})
+}
+
+// RevokeFamily revokes every token in a family
+func (r *ResilientRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return r.policy.Execute(ctx, func(ctx context.Context) error {
+		return r.repo.RevokeFamily(ctx, familyID, revokedAt)
+	})
+}
+
+// RevokeUser revokes every token belonging to a user
+func (r *ResilientRefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	return r.policy.Execute(ctx, func(ctx context.Context) error {
+		return r.repo.RevokeUser(ctx, userID, revokedAt)
+	})
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// ResponseCacheStore holds responses of read endpoints for the response
+// cache. Entries are tagged with surrogate keys so that a change to an
+// entity purges every response showing it.
+type ResponseCacheStore interface {
+	// Get returns the response stored under key, or nil
+	Get(ctx context.Context, key string) (*models.CachedResponse, error)
+	// Set stores resp under key for ttl, tagged with resp.Keys
+	Set(ctx context.Context, key string, resp *models.CachedResponse, ttl time.Duration) error
+	// Purge drops every response tagged with any of surrogateKeys
+	Purge(ctx context.Context, surrogateKeys ...string) error
+}
+
+// InMemoryResponseCacheStore provides an in-memory response cache for
+// single-instance deployments and testing
+type InMemoryResponseCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]responseCacheEntry
+	tags    map[string]map[string]struct{}
+	now     func() time.Time
+}
+
+type responseCacheEntry struct {
+	resp      models.CachedResponse
+	expiresAt time.Time
+}
+
+// NewInMemoryResponseCacheStore creates a new in-memory response cache
+func NewInMemoryResponseCacheStore() *InMemoryResponseCacheStore {
+	return &InMemoryResponseCacheStore{
+		entries: make(map[string]responseCacheEntry),
+		tags:    make(map[string]map[string]struct{}),
+		now:     time.Now,
+	}
+}
+
+// Get returns the unexpired response stored under key
+func (s *InMemoryResponseCacheStore) Get(ctx context.Context, key string) (*models.CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if !s.now().Before(entry.expiresAt) {
+		s.remove(key)
+		return nil, nil
+	}
+	resp := entry.resp
+	return &resp, nil
+}
+
+// Set stores resp under key
+func (s *InMemoryResponseCacheStore) Set(ctx context.Context, key string, resp *models.CachedResponse, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeExpired()
+	s.remove(key)
+	s.entries[key] = responseCacheEntry{resp: *resp, expiresAt: s.now().Add(ttl)}
+	for _, tag := range resp.Keys {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+// Purge drops the responses tagged with any of surrogateKeys
+func (s *InMemoryResponseCacheStore) Purge(ctx context.Context, surrogateKeys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tag := range surrogateKeys {
+		for key := range s.tags[tag] {
+			s.remove(key)
+		}
+	}
+	return nil
+}
+
+// remove drops the entry under key and its tags; callers must hold mu
+func (s *InMemoryResponseCacheStore) remove(key string) {
+	entry, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	delete(s.entries, key)
+	for _, tag := range entry.resp.Keys {
+		delete(s.tags[tag], key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+// purgeExpired drops expired entries; callers must hold mu
+func (s *InMemoryResponseCacheStore) purgeExpired() {
+	now := s.now()
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			s.remove(key)
+		}
+	}
+}
+
+// RedisResponseCacheStore implements ResponseCacheStore on Redis so that
+// a purge by one replica applies to all. Responses are stored as JSON;
+// each surrogate key is a set of the cache keys it tags, which expires
+// with the last response added to it.
+type RedisResponseCacheStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisResponseCacheStore creates a Redis response cache. Keys are
+// namespaced with prefix.
+func NewRedisResponseCacheStore(client redis.UniversalClient, prefix string) *RedisResponseCacheStore {
+	return &RedisResponseCacheStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Get returns the response stored under key
+func (s *RedisResponseCacheStore) Get(ctx context.Context, key string) (*models.CachedResponse, error) {
+	data, err := s.client.Get(ctx, s.entryKey(key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := &models.CachedResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Set stores resp under key
+func (s *RedisResponseCacheStore) Set(ctx context.Context, key string, resp *models.CachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.entryKey(key), data, ttl)
+		for _, tag := range resp.Keys {
+			pipe.SAdd(ctx, s.tagKey(tag), key)
+			pipe.Expire(ctx, s.tagKey(tag), ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Purge drops the responses tagged with any of surrogateKeys
+func (s *RedisResponseCacheStore) Purge(ctx context.Context, surrogateKeys ...string) error {
+	for _, tag := range surrogateKeys {
+		keys, err := s.client.SMembers(ctx, s.tagKey(tag)).Result()
+		if err != nil {
+			return err
+		}
+		del := make([]string, 0, len(keys)+1)
+		for _, key := range keys {
+			del = append(del, s.entryKey(key))
+		}
+		del = append(del, s.tagKey(tag))
+		if err := s.client.Del(ctx, del...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisResponseCacheStore) entryKey(key string) string {
+	return s.prefix + "response-cache:" + key
+}
+
+func (s *RedisResponseCacheStore) tagKey(tag string) string {
+	return s.prefix + "response-cache-tag:" + tag
+}
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RevocationStore records revoked access tokens. Individual tokens are
+// revoked by jti; all of a user's sessions are revoked with a cutoff time
+// before which every token issued to them is rejected. Entries only need
+// to live as long as the tokens they revoke.
+type RevocationStore interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUser(ctx context.Context, userID string, revokedAt time.Time, ttl time.Duration) error
+	// IsRevoked reports whether the token with the given jti, issued to
+	// userID at issuedAt, has been revoked
+	IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
+}
+
+// revokedBefore reports whether a token issued at issuedAt falls under a
+// user cutoff in Unix microseconds. Locally issued tokens carry iat with
+// microsecond precision, so a token issued right after a revocation, such
+// as on an SSO login that changed the user's role, stays valid.
+func revokedBefore(issuedAt time.Time, cutoff int64) bool {
+	return issuedAt.UnixMicro() < cutoff
+}
+
+// InMemoryRevocationStore provides an in-memory revocation store for
+// single-instance deployments and testing
+type InMemoryRevocationStore struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]userRevocation
+	now    func() time.Time
+}
+
+type userRevocation struct {
+	cutoff    int64
+	expiresAt time.Time
+}
+
+// NewInMemoryRevocationStore creates a new in-memory revocation store
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]userRevocation),
+		now:    time.Now,
+	}
+}
+
+// RevokeToken revokes a single token until it expires
+func (s *InMemoryRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purge()
+	s.tokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeUser revokes every token issued to a user up to revokedAt
+func (s *InMemoryRevocationStore) RevokeUser(ctx context.Context, userID string, revokedAt time.Time, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purge()
+	cutoff := revokedAt.UnixMicro()
+	if existing, ok := s.users[userID]; ok && existing.cutoff > cutoff {
+		cutoff = existing.cutoff
+	}
+	s.users[userID] = userRevocation{cutoff: cutoff, expiresAt: s.now().Add(ttl)}
+	return nil
+}
+
+// IsRevoked reports whether a token has been revoked
+func (s *InMemoryRevocationStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if expiresAt, ok := s.tokens[jti]; ok && jti != "" && now.Before(expiresAt) {
+		return true, nil
+	}
+	if revocation, ok := s.users[userID]; ok && now.Before(revocation.expiresAt) {
+		return revokedBefore(issuedAt, revocation.cutoff), nil
+	}
+	return false, nil
+}
+
+// purge drops expired entries; callers must hold mu
+func (s *InMemoryRevocationStore) purge() {
+	now := s.now()
+	for jti, expiresAt := range s.tokens {
+		if !now.Before(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userID, revocation := range s.users {
+		if !now.Before(revocation.expiresAt) {
+			delete(s.users, userID)
+		}
+	}
+}
+
+// legacyCutoffLimit separates user cutoffs stored in Unix seconds, as
+// older releases did, from cutoffs in Unix microseconds
+const legacyCutoffLimit = 1 << 40
+
+// RedisRevocationStore implements RevocationStore on Redis so that
+// revocations are shared by all replicas. Entries expire with Redis TTLs.
+type RedisRevocationStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRevocationStore creates a Redis revocation store. Keys are
+// namespaced with prefix.
+func NewRedisRevocationStore(client redis.UniversalClient, prefix string) *RedisRevocationStore {
+	return &RedisRevocationStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// RevokeToken revokes a single token until it expires
+func (s *RedisRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, s.tokenKey(jti), 1, ttl).Err()
+}
+
+// RevokeUser revokes every token issued to a user up to revokedAt
+func (s *RedisRevocationStore) RevokeUser(ctx context.Context, userID string, revokedAt time.Time, ttl time.Duration) error {
+	return s.client.Set(ctx, s.userKey(userID), revokedAt.UnixMicro(), ttl).Err()
+}
+
+// IsRevoked reports whether a token has been revoked
+func (s *RedisRevocationStore) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	values, err := s.client.MGet(ctx, s.tokenKey(jti), s.userKey(userID)).Result()
+	if err != nil {
+		return false, err
+	}
+	if jti != "" && values[0] != nil {
+		return true, nil
+	}
+	if raw, ok := values[1].(string); ok {
+		cutoff, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return false, err
+		}
+		if cutoff < legacyCutoffLimit {
+			// Written in seconds by an older release
+			cutoff = (cutoff + 1) * int64(time.Second/time.Microsecond)
+		}
+		return revokedBefore(issuedAt, cutoff), nil
+	}
+	return false, nil
+}
+
+func (s *RedisRevocationStore) tokenKey(jti string) string {
+	return s.prefix + "revoked:jti:" + jti
+}
+
+func (s *RedisRevocationStore) userKey(userID string) string {
+	return s.prefix + "revoked:user:" + userID
+}
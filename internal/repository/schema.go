@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// schemaLockID is the PostgreSQL advisory lock held while the schema is
+// migrated
+const schemaLockID int64 = 0x736368656d61 // "schema"
+
+// migrations create the tables of the PostgreSQL repositories. They run in
+// this order on every start and are idempotent, so an existing database is
+// brought up to date and an up-to-date one is left alone. New statements
+// are appended; released ones are never changed.
+var migrations = []string{
+	// Users
+	`CREATE TABLE IF NOT EXISTS users (
+		id         TEXT PRIMARY KEY,
+		email      TEXT NOT NULL UNIQUE,
+		name       TEXT NOT NULL,
+		role       TEXT NOT NULL,
+		active     BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+
+	// API keys
+	`CREATE TABLE IF NOT EXISTS api_keys (
+		id           TEXT PRIMARY KEY,
+		name         TEXT NOT NULL,
+		prefix       TEXT NOT NULL UNIQUE,
+		hash         TEXT NOT NULL,
+		owner_id     TEXT NOT NULL,
+		owner_type   TEXT NOT NULL,
+		scopes       TEXT NOT NULL DEFAULT '',
+		expires_at   TIMESTAMPTZ,
+		last_used_at TIMESTAMPTZ,
+		revoked_at   TIMESTAMPTZ,
+		replaced_by  TEXT NOT NULL DEFAULT '',
+		created_at   TIMESTAMPTZ NOT NULL,
+		updated_at   TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS api_keys_owner_id_idx ON api_keys (owner_id)`,
+
+	// Local passwords and refresh tokens
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id          TEXT PRIMARY KEY,
+		family_id   TEXT NOT NULL,
+		user_id     TEXT NOT NULL,
+		hash        TEXT NOT NULL UNIQUE,
+		expires_at  TIMESTAMPTZ NOT NULL,
+		used_at     TIMESTAMPTZ,
+		revoked_at  TIMESTAMPTZ,
+		replaced_by TEXT NOT NULL DEFAULT '',
+		created_at  TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id)`,
+	`CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id)`,
+
+	// SSO accounts linked to users
+	`ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS oidc_issuer TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS oidc_subject TEXT NOT NULL DEFAULT ''`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_identity_idx ON users (oidc_issuer, oidc_subject) WHERE oidc_subject <> ''`,
+
+	// Token signing keys
+	`CREATE TABLE IF NOT EXISTS signing_keys (
+		id           TEXT PRIMARY KEY,
+		algorithm    TEXT NOT NULL,
+		status       TEXT NOT NULL,
+		private_key  TEXT NOT NULL,
+		created_at   TIMESTAMPTZ NOT NULL,
+		activated_at TIMESTAMPTZ,
+		retire_at    TIMESTAMPTZ,
+		retired_at   TIMESTAMPTZ
+	)`,
+
+	// Idempotency keys
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key         TEXT PRIMARY KEY,
+		fingerprint TEXT NOT NULL,
+		completed   BOOLEAN NOT NULL DEFAULT FALSE,
+		status_code INTEGER,
+		header      JSONB,
+		body        BYTEA,
+		created_at  TIMESTAMPTZ NOT NULL,
+		expires_at  TIMESTAMPTZ NOT NULL
+	)`,
+
+	// Per-key rate limits
+	`ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limit INTEGER NOT NULL DEFAULT 0`,
+}
+
+// Migrate applies migrations in order. It holds an advisory lock on a
+// connection of its own, so replicas starting together migrate one at a
+// time.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, schemaLockID); err != nil {
+		return err
+	}
+	defer func() {
+		// A lock that cannot be released dies with its connection
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, schemaLockID); err != nil {
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+
+	for i, statement := range migrations {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
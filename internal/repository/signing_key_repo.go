@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sort"
+	"sync"
+
+	"github.com/pipeline-arch/app/internal/models"
+)
+
+// SigningKeyRepository defines the interface for signing key data access
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *models.SigningKey) error
+	List(ctx context.Context) ([]*models.SigningKey, error)
+	Update(ctx context.Context, key *models.SigningKey) error
+
+	// Lock serializes key lifecycle changes across replicas until unlock
+	// is called. Keys listed before locking may be stale.
+	Lock(ctx context.Context) (unlock func(), err error)
+}
+
+// signingKeyLockID is the PostgreSQL advisory lock held while keys are
+// rotated
+const signingKeyLockID int64 = 0x7369676e696e67 // "signing"
+
+// PostgresSigningKeyRepository implements SigningKeyRepository for PostgreSQL
+type PostgresSigningKeyRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresSigningKeyRepository creates a new PostgreSQL signing key repository
+func NewPostgresSigningKeyRepository(db *sql.DB) *PostgresSigningKeyRepository {
+	return &PostgresSigningKeyRepository{db: db}
+}
+
+// Create creates a new signing key
+func (r *PostgresSigningKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	query := `
+		INSERT INTO signing_keys (id, algorithm, status, private_key, created_at, activated_at, retire_at, retired_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		key.ID,
+		key.Algorithm,
+		key.Status,
+		key.PrivateKey,
+		key.CreatedAt,
+		key.ActivatedAt,
+		key.RetireAt,
+		key.RetiredAt,
+	)
+	return err
+}
+
+// List retrieves all signing keys, oldest first
+func (r *PostgresSigningKeyRepository) List(ctx context.Context) ([]*models.SigningKey, error) {
+	query := `
+		SELECT id, algorithm, status, private_key, created_at, activated_at, retire_at, retired_at
+		FROM signing_keys
+		ORDER BY created_at
+	`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.SigningKey
+	for rows.Next() {
+		key := &models.SigningKey{}
+		if err := rows.Scan(
+			&key.ID,
+			&key.Algorithm,
+			&key.Status,
+			&key.PrivateKey,
+			&key.CreatedAt,
+			&key.ActivatedAt,
+			&key.RetireAt,
+			&key.RetiredAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Update updates a signing key's lifecycle state
+func (r *PostgresSigningKeyRepository) Update(ctx context.Context, key *models.SigningKey) error {
+	query := `
+		UPDATE signing_keys
+		SET status = $1, private_key = $2, activated_at = $3, retire_at = $4, retired_at = $5
+		WHERE id = $6
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		key.Status,
+		key.PrivateKey,
+		key.ActivatedAt,
+		key.RetireAt,
+		key.RetiredAt,
+		key.ID,
+	)
+	return err
+}
+
+// Lock takes a session-level advisory lock on a connection of its own,
+// waiting until any other replica releases it
+func (r *PostgresSigningKeyRepository) Lock(ctx context.Context) (func(), error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, signingKeyLockID); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		// Release the lock even when ctx has been cancelled. Should that
+		// fail, discard the connection so the session ends and releases
+		// the lock instead of returning to the pool holding it.
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, signingKeyLockID); err != nil {
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// InMemorySigningKeyRepository provides a simple in-memory implementation for testing
+type InMemorySigningKeyRepository struct {
+	mu   sync.Mutex
+	keys map[string]*models.SigningKey
+
+	// lifecycle is held between Lock and unlock
+	lifecycle sync.Mutex
+}
+
+// NewInMemorySigningKeyRepository creates a new in-memory signing key repository
+func NewInMemorySigningKeyRepository() *InMemorySigningKeyRepository {
+	return &InMemorySigningKeyRepository{
+		keys: make(map[string]*models.SigningKey),
+	}
+}
+
+// Create creates a new signing key
+func (r *InMemorySigningKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *key
+	r.keys[key.ID] = &copied
+	return nil
+}
+
+// List retrieves all signing keys, oldest first
+func (r *InMemorySigningKeyRepository) List(ctx context.Context) ([]*models.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]*models.SigningKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		copied := *key
+		keys = append(keys, &copied)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// Update updates a signing key's lifecycle state
+func (r *InMemorySigningKeyRepository) Update(ctx context.Context, key *models.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.keys[key.ID]; !exists {
+		return sql.ErrNoRows
+	}
+	copied := *key
+	r.keys[key.ID] = &copied
+	return nil
+}
+
+// Lock serializes key lifecycle changes among the services sharing the
+// repository
+func (r *InMemorySigningKeyRepository) Lock(ctx context.Context) (func(), error) {
+	r.lifecycle.Lock()
+	return r.lifecycle.Unlock, nil
+}
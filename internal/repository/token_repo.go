@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/models"
+)
+
+// RefreshTokenRepository defines the interface for refresh token data access
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	// MarkUsed atomically marks an unused token as used. It reports false
+	// when the token had already been used, which signals reuse.
+	MarkUsed(ctx context.Context, id, replacedBy string, usedAt time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error
+}
+
+// PostgresRefreshTokenRepository implements RefreshTokenRepository for PostgreSQL
+type PostgresRefreshTokenRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRefreshTokenRepository creates a new PostgreSQL refresh token repository
+func NewPostgresRefreshTokenRepository(db *sql.DB) *PostgresRefreshTokenRepository {
+	return &PostgresRefreshTokenRepository{db: db}
+}
+
+// Create creates a new refresh token
+func (r *PostgresRefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, family_id, user_id, hash, expires_at, used_at, revoked_at, replaced_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.Hash,
+		token.ExpiresAt,
+		token.UsedAt,
+		token.RevokedAt,
+		token.ReplacedBy,
+		token.CreatedAt,
+	)
+	return err
+}
+
+// GetByHash retrieves a refresh token by the hash of its value
+func (r *PostgresRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	query := `
+		SELECT id, family_id, user_id, hash, expires_at, used_at, revoked_at, replaced_by, created_at
+		FROM refresh_tokens
+		WHERE hash = $1
+	`
+	token := &models.RefreshToken{}
+	err := r.db.QueryRowContext(ctx, query, hash).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.Hash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.ReplacedBy,
+		&token.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// MarkUsed marks a token as used if it has not been used yet
+func (r *PostgresRefreshTokenRepository) MarkUsed(ctx context.Context, id, replacedBy string, usedAt time.Time) (bool, error) {
+	query := `
+		UPDATE refresh_tokens
+		SET used_at = $1, replaced_by = $2
+		WHERE id = $3 AND used_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, usedAt, replacedBy, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected == 1, err
+}
+
+// RevokeFamily revokes every token in a family
+func (r *PostgresRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, revokedAt, familyID)
+	return err
+}
+
+// RevokeUser revokes every token belonging to a user
+func (r *PostgresRefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL`
+	_, err := r.db.ExecContext(ctx, query, revokedAt, userID)
+	return err
+}
+
+// InMemoryRefreshTokenRepository provides a simple in-memory implementation for testing
+type InMemoryRefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*models.RefreshToken
+}
+
+// NewInMemoryRefreshTokenRepository creates a new in-memory refresh token repository
+func NewInMemoryRefreshTokenRepository() *InMemoryRefreshTokenRepository {
+	return &InMemoryRefreshTokenRepository{
+		tokens: make(map[string]*models.RefreshToken),
+	}
+}
+
+// Create creates a new refresh token
+func (r *InMemoryRefreshTokenRepository) Create(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *token
+	r.tokens[token.ID] = &copied
+	return nil
+}
+
+// GetByHash retrieves a refresh token by the hash of its value
+func (r *InMemoryRefreshTokenRepository) GetByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.Hash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// MarkUsed marks a token as used if it has not been used yet
+func (r *InMemoryRefreshTokenRepository) MarkUsed(ctx context.Context, id, replacedBy string, usedAt time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	token, exists := r.tokens[id]
+	if !exists || token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &usedAt
+	token.ReplacedBy = replacedBy
+	return true, nil
+}
+
+// RevokeFamily revokes every token in a family
+func (r *InMemoryRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+// RevokeUser revokes every token belonging to a user
+func (r *InMemoryRefreshTokenRepository) RevokeUser(ctx context.Context, userID string, revokedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, token := range r.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
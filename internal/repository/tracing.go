@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingUserRepository records a span for every call to another
+// UserRepository, whatever its storage. Wrapped around a resilient
+// repository, a call's span covers its retries.
+type TracingUserRepository struct {
+	repo UserRepository
+}
+
+// NewTracingUserRepository creates a new tracing user repository
+func NewTracingUserRepository(repo UserRepository) *TracingUserRepository {
+	return &TracingUserRepository{repo: repo}
+}
+
+func startCall(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "UserRepository."+method, trace.WithAttributes(attrs...))
+}
+
+// Create creates a new user
+func (r *TracingUserRepository) Create(ctx context.Context, user *models.User) error {
+	ctx, span := startCall(ctx, "Create", attribute.String("user.id", user.ID))
+	err := r.repo.Create(ctx, user)
+	tracing.End(span, err)
+	return err
+}
+
+// GetByID retrieves a user by ID
+func (r *TracingUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, span := startCall(ctx, "GetByID", attribute.String("user.id", id))
+	user, err := r.repo.GetByID(ctx, id)
+	tracing.End(span, err)
+	return user, err
+}
+
+// GetByEmail retrieves a user by email. The address is not recorded.
+func (r *TracingUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, span := startCall(ctx, "GetByEmail")
+	user, err := r.repo.GetByEmail(ctx, email)
+	tracing.End(span, err)
+	return user, err
+}
+
+// GetByIdentity retrieves the user linked to an IdP account
+func (r *TracingUserRepository) GetByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	ctx, span := startCall(ctx, "GetByIdentity")
+	user, err := r.repo.GetByIdentity(ctx, issuer, subject)
+	tracing.End(span, err)
+	return user, err
+}
+
+// Update updates an existing user
+func (r *TracingUserRepository) Update(ctx context.Context, user *models.User) error {
+	ctx, span := startCall(ctx, "Update", attribute.String("user.id", user.ID))
+	err := r.repo.Update(ctx, user)
+	tracing.End(span, err)
+	return err
+}
+
+// Delete deletes a user by ID
+func (r *TracingUserRepository) Delete(ctx context.Context, id string) error {
+	ctx, span := startCall(ctx, "Delete", attribute.String("user.id", id))
+	err := r.repo.Delete(ctx, id)
+	tracing.End(span, err)
+	return err
+}
+
+// List retrieves a list of users
+func (r *TracingUserRepository) List(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	ctx, span := startCall(ctx, "List", attribute.Int("limit", limit), attribute.Int("offset", offset))
+	users, err := r.repo.List(ctx, limit, offset)
+	tracing.End(span, err)
+	return users, err
+}
+
+// Count returns the total number of users
+func (r *TracingUserRepository) Count(ctx context.Context) (int, error) {
+	ctx, span := startCall(ctx, "Count")
+	count, err := r.repo.Count(ctx)
+	tracing.End(span, err)
+	return count, err
+}
+
+// Close closes the wrapped repository
+func (r *TracingUserRepository) Close() error {
+	return r.repo.Close()
+}
@@ -3,6 +3,8 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/pipeline-arch/app/internal/models"
@@ -13,6 +15,7 @@ type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	GetByID(ctx context.Context, id string) (*models.User, error)
 	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id string) error
 	List(ctx context.Context, limit, offset int) ([]*models.User, error)
@@ -36,10 +39,12 @@ func NewPostgresUserRepository(db *sql.DB, tableName string) *PostgresUserReposi
 
 // Create creates a new user
 func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User) error {
+	start := time.Now()
 	query := `
-		INSERT INTO users (id, email, name, role, active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO users (id, email, name, role, active, created_at, updated_at, password_hash, oidc_issuer, oidc_subject)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`
+	ctx, span := startQuery(ctx, "users.create", query)
 	_, err := r.db.ExecContext(ctx, query,
 		user.ID,
 		user.Email,
@@ -48,18 +53,24 @@ func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User)
 		user.Active,
 		user.CreatedAt,
 		user.UpdatedAt,
+		user.PasswordHash,
+		user.OIDCIssuer,
+		user.OIDCSubject,
 	)
+	finishQuery(ctx, span, "users.create", start, err)
 	return err
 }
 
 // GetByID retrieves a user by ID
 func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	start := time.Now()
 	query := `
-		SELECT id, email, name, role, active, created_at, updated_at
+		SELECT id, email, name, role, active, created_at, updated_at, password_hash, oidc_issuer, oidc_subject
 		FROM users
 		WHERE id = $1
 	`
 	user := &models.User{}
+	ctx, span := startQuery(ctx, "users.get_by_id", query)
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.Email,
@@ -68,7 +79,11 @@ func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*model
 		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.PasswordHash,
+		&user.OIDCIssuer,
+		&user.OIDCSubject,
 	)
+	finishQuery(ctx, span, "users.get_by_id", start, err)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -80,12 +95,14 @@ func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*model
 
 // GetByEmail retrieves a user by email
 func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	start := time.Now()
 	query := `
-		SELECT id, email, name, role, active, created_at, updated_at
+		SELECT id, email, name, role, active, created_at, updated_at, password_hash, oidc_issuer, oidc_subject
 		FROM users
 		WHERE email = $1
 	`
 	user := &models.User{}
+	ctx, span := startQuery(ctx, "users.get_by_email", query)
 	err := r.db.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
 		&user.Email,
@@ -94,7 +111,43 @@ func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (
 		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.PasswordHash,
+		&user.OIDCIssuer,
+		&user.OIDCSubject,
 	)
+	finishQuery(ctx, span, "users.get_by_email", start, err)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetByIdentity retrieves the user linked to an IdP account
+func (r *PostgresUserRepository) GetByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	start := time.Now()
+	query := `
+		SELECT id, email, name, role, active, created_at, updated_at, password_hash, oidc_issuer, oidc_subject
+		FROM users
+		WHERE oidc_issuer = $1 AND oidc_subject = $2
+	`
+	user := &models.User{}
+	ctx, span := startQuery(ctx, "users.get_by_identity", query)
+	err := r.db.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Name,
+		&user.Role,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.PasswordHash,
+		&user.OIDCIssuer,
+		&user.OIDCSubject,
+	)
+	finishQuery(ctx, span, "users.get_by_identity", start, err)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -108,38 +161,51 @@ func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (
 func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User) error {
 	query := `
 		UPDATE users
-		SET email = $1, name = $2, role = $3, active = $4, updated_at = $5
-		WHERE id = $6
+		SET email = $1, name = $2, role = $3, active = $4, updated_at = $5, password_hash = $6,
+			oidc_issuer = $7, oidc_subject = $8
+		WHERE id = $9
 	`
-	user.UpdatedAt = time.Now().UTC()
+	start := time.Now()
+	user.UpdatedAt = start.UTC()
+	ctx, span := startQuery(ctx, "users.update", query)
 	_, err := r.db.ExecContext(ctx, query,
 		user.Email,
 		user.Name,
 		user.Role,
 		user.Active,
 		user.UpdatedAt,
+		user.PasswordHash,
+		user.OIDCIssuer,
+		user.OIDCSubject,
 		user.ID,
 	)
+	finishQuery(ctx, span, "users.update", start, err)
 	return err
 }
 
 // Delete deletes a user by ID
 func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
+	start := time.Now()
 	query := `DELETE FROM users WHERE id = $1`
+	ctx, span := startQuery(ctx, "users.delete", query)
 	_, err := r.db.ExecContext(ctx, query, id)
+	finishQuery(ctx, span, "users.delete", start, err)
 	return err
 }
 
 // List retrieves a list of users
 func (r *PostgresUserRepository) List(ctx context.Context, limit, offset int) ([]*models.User, error) {
+	start := time.Now()
 	query := `
-		SELECT id, email, name, role, active, created_at, updated_at
+		SELECT id, email, name, role, active, created_at, updated_at, password_hash, oidc_issuer, oidc_subject
 		FROM users
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
 	`
+	ctx, span := startQuery(ctx, "users.list", query)
 	rows, err := r.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
+		finishQuery(ctx, span, "users.list", start, err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -155,20 +221,29 @@ func (r *PostgresUserRepository) List(ctx context.Context, limit, offset int) ([
 			&user.Active,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.PasswordHash,
+			&user.OIDCIssuer,
+			&user.OIDCSubject,
 		)
 		if err != nil {
+			finishQuery(ctx, span, "users.list", start, err)
 			return nil, err
 		}
 		users = append(users, user)
 	}
-	return users, rows.Err()
+	err = rows.Err()
+	finishQuery(ctx, span, "users.list", start, err)
+	return users, err
 }
 
 // Count returns the total number of users
 func (r *PostgresUserRepository) Count(ctx context.Context) (int, error) {
+	start := time.Now()
 	query := `SELECT COUNT(*) FROM users`
 	var count int
+	ctx, span := startQuery(ctx, "users.count", query)
 	err := r.db.QueryRowContext(ctx, query).Scan(&count)
+	finishQuery(ctx, span, "users.count", start, err)
 	return count, err
 }
 
@@ -177,8 +252,10 @@ func (r *PostgresUserRepository) Close() error {
 	return r.db.Close()
 }
 
-// InMemoryUserRepository provides a simple in-memory implementation for testing
+// InMemoryUserRepository provides a simple in-memory implementation for
+// testing. Users are copied in and out, so callers never share them.
 type InMemoryUserRepository struct {
+	mu    sync.RWMutex
 	users map[string]*models.User
 }
 
@@ -191,24 +268,46 @@ func NewInMemoryUserRepository() *InMemoryUserRepository {
 
 // Create creates a new user
 func (r *InMemoryUserRepository) Create(ctx context.Context, user *models.User) error {
-	r.users[user.ID] = user
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *user
+	r.users[user.ID] = &copied
 	return nil
 }
 
 // GetByID retrieves a user by ID
 func (r *InMemoryUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	user, exists := r.users[id]
 	if !exists {
 		return nil, nil
 	}
-	return user, nil
+	copied := *user
+	return &copied, nil
 }
 
 // GetByEmail retrieves a user by email
 func (r *InMemoryUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for _, user := range r.users {
 		if user.Email == email {
-			return user, nil
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetByIdentity retrieves the user linked to an IdP account
+func (r *InMemoryUserRepository) GetByIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, user := range r.users {
+		if user.OIDCIssuer == issuer && user.OIDCSubject == subject {
+			copied := *user
+			return &copied, nil
 		}
 	}
 	return nil, nil
@@ -216,34 +315,53 @@ func (r *InMemoryUserRepository) GetByEmail(ctx context.Context, email string) (
 
 // Update updates an existing user
 func (r *InMemoryUserRepository) Update(ctx context.Context, user *models.User) error {
-	r.users[user.ID] = user
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *user
+	r.users[user.ID] = &copied
 	return nil
 }
 
 // Delete deletes a user by ID
 func (r *InMemoryUserRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	delete(r.users, id)
 	return nil
 }
 
 // List retrieves a list of users
 func (r *InMemoryUserRepository) List(ctx context.Context, limit, offset int) ([]*models.User, error) {
-	users := make([]*models.User, 0, limit)
-	count := 0
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Newest first, like the PostgreSQL repository, so pages are stable
+	all := make([]*models.User, 0, len(r.users))
 	for _, user := range r.users {
-		if count >= offset {
-			users = append(users, user)
-			if len(users) >= limit {
-				break
-			}
+		copied := *user
+		all = append(all, &copied)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.After(all[j].CreatedAt)
 		}
-		count++
+		return all[i].ID < all[j].ID
+	})
+
+	if offset >= len(all) {
+		return []*models.User{}, nil
+	}
+	all = all[offset:]
+	if len(all) > limit {
+		all = all[:limit]
 	}
-	return users, nil
+	return all, nil
 }
 
 // Count returns the total number of users
 func (r *InMemoryUserRepository) Count(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return len(r.users), nil
 }
 
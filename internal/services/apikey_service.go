@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultRotationOverlap is how long a rotated key keeps working
+	// alongside its replacement when the caller does not specify a window.
+	DefaultRotationOverlap = 24 * time.Hour
+
+	// MaxRotationOverlap caps the overlap window for rotated keys
+	MaxRotationOverlap = 7 * 24 * time.Hour
+
+	// lastUsedResolution limits how often last-used timestamps are written
+	lastUsedResolution = time.Minute
+)
+
+// KnownScopes lists the scopes that can be granted to API keys
+var KnownScopes = []string{
+	models.ScopeUsersRead,
+	models.ScopeUsersWrite,
+	models.ScopeAPIKeysManage,
+	models.ScopeAdmin,
+}
+
+// APIKeyService handles API key business logic
+type APIKeyService struct {
+	repo    repository.APIKeyRepository
+	owners  repository.UserRepository
+	log     *zerolog.Logger
+	metrics *metrics.Metrics
+	now     func() time.Time
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(repo repository.APIKeyRepository, log *zerolog.Logger, m *metrics.Metrics) *APIKeyService {
+	return &APIKeyService{
+		repo:    repo,
+		log:     log,
+		metrics: m,
+		now:     func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// SetOwners makes the service reject keys owned by users that have been
+// deleted or deactivated. Keys of service accounts are not checked.
+func (s *APIKeyService) SetOwners(users repository.UserRepository) {
+	s.owners = users
+}
+
+// CreateKey creates a new API key on behalf of caller. The plaintext key is
+// only returned here and is never persisted.
+func (s *APIKeyService) CreateKey(ctx context.Context, caller *auth.Principal, req *models.APIKeyCreateRequest) (*models.APIKeySecretResponse, error) {
+	ownerID, ownerType := req.OwnerID, req.OwnerType
+	if ownerID == "" {
+		ownerID, ownerType = caller.Subject, caller.Type
+	}
+	if ownerType == "" {
+		ownerType = models.OwnerTypeUser
+	}
+	if ownerID != caller.Subject && !caller.HasScope(models.ScopeAdmin) {
+		return nil, errors.NewAppError(
+			errors.ErrCodeForbidden,
+			"Cannot create API keys for another owner",
+			ownerID,
+			nil,
+		)
+	}
+	if err := s.validateScopes(caller, req.Scopes); err != nil {
+		return nil, err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(s.now()) {
+		return nil, errors.ValidationErrors([]string{"expires_at"})
+	}
+	if req.RateLimit != 0 {
+		if err := s.validateRateLimit(caller, req.RateLimit); err != nil {
+			return nil, err
+		}
+	}
+
+	s.log.Info().Str("owner_id", ownerID).Str("owner_type", ownerType).Msg("Creating API key")
+
+	raw, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error generating API key")
+		return nil, errors.ErrInternalServer
+	}
+
+	key := models.NewAPIKey(req.Name, prefix, hash, ownerID, ownerType, req.Scopes, req.ExpiresAt)
+	key.RateLimit = req.RateLimit
+	if err := s.repo.Create(ctx, key); err != nil {
+		s.log.Error().Err(err).Str("owner_id", ownerID).Msg("Error creating API key")
+		return nil, errors.ErrInternalServer
+	}
+
+	s.incOperation("create_api_key", "success")
+	s.log.Info().Str("api_key_id", key.ID).Str("prefix", key.Prefix).Msg("API key created successfully")
+
+	return &models.APIKeySecretResponse{APIKeyResponse: key.ToResponse(), Key: raw}, nil
+}
+
+// ListKeys lists the keys visible to caller. Admins see every key.
+func (s *APIKeyService) ListKeys(ctx context.Context, caller *auth.Principal) (*models.APIKeyListResponse, error) {
+	var (
+		keys []*models.APIKey
+		err  error
+	)
+	if caller.HasScope(models.ScopeAdmin) {
+		keys, err = s.repo.List(ctx)
+	} else {
+		keys, err = s.repo.ListByOwner(ctx, caller.Subject)
+	}
+	if err != nil {
+		s.log.Error().Err(err).Str("subject", caller.Subject).Msg("Error listing API keys")
+		return nil, errors.ErrInternalServer
+	}
+
+	responses := make([]*models.APIKeyResponse, len(keys))
+	for i, key := range keys {
+		responses[i] = key.ToResponse()
+	}
+
+	s.incOperation("list_api_keys", "success")
+	return &models.APIKeyListResponse{APIKeys: responses, Total: len(responses)}, nil
+}
+
+// GetKey retrieves a single key owned by caller
+func (s *APIKeyService) GetKey(ctx context.Context, caller *auth.Principal, id string) (*models.APIKeyResponse, error) {
+	key, err := s.getOwnedKey(ctx, caller, id)
+	if err != nil {
+		return nil, err
+	}
+	s.incOperation("get_api_key", "success")
+	return key.ToResponse(), nil
+}
+
+// UpdateKey changes the name, scopes or expiry of a key
+func (s *APIKeyService) UpdateKey(ctx context.Context, caller *auth.Principal, id string, req *models.APIKeyUpdateRequest) (*models.APIKeyResponse, error) {
+	key, err := s.getOwnedKey(ctx, caller, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsRevoked() {
+		return nil, errors.NewAppError(errors.ErrCodeConflict, "API key has been revoked", id, nil)
+	}
+
+	if req.Name != nil {
+		key.Name = *req.Name
+	}
+	if req.Scopes != nil {
+		if err := s.validateScopes(caller, req.Scopes); err != nil {
+			return nil, err
+		}
+		key.Scopes = req.Scopes
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(s.now()) {
+			return nil, errors.ValidationErrors([]string{"expires_at"})
+		}
+		key.ExpiresAt = req.ExpiresAt
+	}
+	if req.RateLimit != nil && *req.RateLimit != key.RateLimit {
+		if err := s.validateRateLimit(caller, *req.RateLimit); err != nil {
+			return nil, err
+		}
+		key.RateLimit = *req.RateLimit
+	}
+
+	if err := s.repo.Update(ctx, key); err != nil {
+		s.log.Error().Err(err).Str("api_key_id", id).Msg("Error updating API key")
+		return nil, errors.ErrInternalServer
+	}
+
+	s.incOperation("update_api_key", "success")
+	s.log.Info().Str("api_key_id", id).Msg("API key updated successfully")
+
+	return key.ToResponse(), nil
+}
+
+// RevokeKey revokes a key immediately. Revoked keys are kept for auditing.
+func (s *APIKeyService) RevokeKey(ctx context.Context, caller *auth.Principal, id string) error {
+	key, err := s.getOwnedKey(ctx, caller, id)
+	if err != nil {
+		return err
+	}
+	if key.IsRevoked() {
+		return nil
+	}
+
+	now := s.now()
+	key.RevokedAt = &now
+	if err := s.repo.Update(ctx, key); err != nil {
+		s.log.Error().Err(err).Str("api_key_id", id).Msg("Error revoking API key")
+		return errors.ErrInternalServer
+	}
+
+	s.incOperation("revoke_api_key", "success")
+	s.log.Info().Str("api_key_id", id).Str("prefix", key.Prefix).Msg("API key revoked")
+
+	return nil
+}
+
+// RotateKey issues a replacement for a key. The old key keeps working for
+// the overlap window so that deployments can pick up the new secret.
+func (s *APIKeyService) RotateKey(ctx context.Context, caller *auth.Principal, id string, overlap time.Duration) (*models.APIKeySecretResponse, error) {
+	if overlap < 0 || overlap > MaxRotationOverlap {
+		return nil, errors.ValidationErrors([]string{"overlap_seconds"})
+	}
+
+	old, err := s.getOwnedKey(ctx, caller, id)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if old.IsRevoked() || old.IsExpired(now) {
+		return nil, errors.NewAppError(errors.ErrCodeConflict, "API key is no longer active", id, nil)
+	}
+
+	raw, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error generating API key")
+		return nil, errors.ErrInternalServer
+	}
+
+	// Carry the remaining lifetime over to the replacement key: rotating
+	// must not extend how long a credential is valid
+	var expiresAt *time.Time
+	if old.ExpiresAt != nil {
+		next := *old.ExpiresAt
+		expiresAt = &next
+	}
+
+	key := models.NewAPIKey(old.Name, prefix, hash, old.OwnerID, old.OwnerType, old.Scopes, expiresAt)
+	key.RateLimit = old.RateLimit
+	if err := s.repo.Create(ctx, key); err != nil {
+		s.log.Error().Err(err).Str("api_key_id", id).Msg("Error creating rotated API key")
+		return nil, errors.ErrInternalServer
+	}
+
+	overlapEnd := now.Add(overlap)
+	if old.ExpiresAt == nil || overlapEnd.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &overlapEnd
+	}
+	old.ReplacedBy = key.ID
+	if err := s.repo.Update(ctx, old); err != nil {
+		s.log.Error().Err(err).Str("api_key_id", id).Msg("Error updating rotated API key")
+		return nil, errors.ErrInternalServer
+	}
+
+	s.incOperation("rotate_api_key", "success")
+	s.log.Info().
+		Str("api_key_id", id).
+		Str("replaced_by", key.ID).
+		Dur("overlap", overlap).
+		Msg("API key rotated")
+
+	return &models.APIKeySecretResponse{APIKeyResponse: key.ToResponse(), Key: raw}, nil
+}
+
+// Authenticate resolves a plaintext key to a principal. Unknown, expired
+// and revoked keys all produce the same unauthorized error.
+func (s *APIKeyService) Authenticate(ctx context.Context, raw string) (*auth.Principal, error) {
+	invalid := errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid API key", "", nil)
+
+	prefix, ok := auth.ParseAPIKeyPrefix(raw)
+	if !ok {
+		s.incOperation("authenticate_api_key", "invalid")
+		return nil, invalid
+	}
+
+	key, err := s.repo.GetByPrefix(ctx, prefix)
+	if err != nil {
+		s.log.Error().Err(err).Str("prefix", prefix).Msg("Error looking up API key")
+		return nil, errors.ErrInternalServer
+	}
+	if key == nil || !auth.CompareAPIKeyHash(raw, key.Hash) {
+		s.incOperation("authenticate_api_key", "invalid")
+		return nil, invalid
+	}
+
+	now := s.now()
+	if key.IsRevoked() || key.IsExpired(now) {
+		s.incOperation("authenticate_api_key", "inactive")
+		s.log.Warn().Str("api_key_id", key.ID).Str("prefix", key.Prefix).Msg("Inactive API key presented")
+		return nil, invalid
+	}
+
+	if active, err := s.ownerActive(ctx, key); err != nil {
+		return nil, err
+	} else if !active {
+		s.incOperation("authenticate_api_key", "owner_inactive")
+		s.log.Warn().Str("api_key_id", key.ID).Str("owner_id", key.OwnerID).Msg("API key of an inactive user presented")
+		return nil, invalid
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		if err := s.repo.TouchLastUsed(ctx, key.ID, now); err != nil {
+			s.log.Warn().Err(err).Str("api_key_id", key.ID).Msg("Error recording API key usage")
+		}
+	}
+
+	s.incOperation("authenticate_api_key", "success")
+	return &auth.Principal{
+		Subject:   key.OwnerID,
+		Type:      key.OwnerType,
+		Method:    auth.MethodAPIKey,
+		KeyID:     key.ID,
+		Scopes:    key.Scopes,
+		RateLimit: key.RateLimit,
+	}, nil
+}
+
+// ownerActive reports whether the user owning key still exists and is
+// active. Keys are accepted when no owners are set.
+func (s *APIKeyService) ownerActive(ctx context.Context, key *models.APIKey) (bool, error) {
+	if s.owners == nil || key.OwnerType != models.OwnerTypeUser {
+		return true, nil
+	}
+	owner, err := s.owners.GetByID(ctx, key.OwnerID)
+	if err != nil {
+		s.log.Error().Err(err).Str("api_key_id", key.ID).Msg("Error looking up API key owner")
+		return false, errors.ErrInternalServer
+	}
+	return owner != nil && owner.Active, nil
+}
+
+func (s *APIKeyService) getOwnedKey(ctx context.Context, caller *auth.Principal, id string) (*models.APIKey, error) {
+	key, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		s.log.Error().Err(err).Str("api_key_id", id).Msg("Error getting API key")
+		return nil, errors.ErrInternalServer
+	}
+	// Keys owned by someone else are reported as missing to avoid leaking IDs
+	if key == nil || (key.OwnerID != caller.Subject && !caller.HasScope(models.ScopeAdmin)) {
+		return nil, errors.NotFoundError("API key", id)
+	}
+	return key, nil
+}
+
+func (s *APIKeyService) validateScopes(caller *auth.Principal, scopes []string) error {
+	if len(scopes) == 0 {
+		return errors.ValidationErrors([]string{"scopes"})
+	}
+	var invalid []string
+	for _, scope := range scopes {
+		if !isKnownScope(scope) {
+			invalid = append(invalid, scope)
+			continue
+		}
+		// Callers cannot mint keys with more privileges than they hold
+		if !caller.HasScope(scope) {
+			return errors.NewAppError(errors.ErrCodeForbidden, "Cannot grant scope not held by caller", scope, nil)
+		}
+	}
+	if len(invalid) > 0 {
+		return errors.ValidationErrors(invalid)
+	}
+	return nil
+}
+
+// validateRateLimit checks a new key quota. Only admins may set quotas,
+// since they replace the default rate limit.
+func (s *APIKeyService) validateRateLimit(caller *auth.Principal, rateLimit int) error {
+	if rateLimit < 0 {
+		return errors.ValidationErrors([]string{"rate_limit"})
+	}
+	if !caller.HasScope(models.ScopeAdmin) {
+		return errors.NewAppError(errors.ErrCodeForbidden, "Only admins can set API key rate limits", "", nil)
+	}
+	return nil
+}
+
+func (s *APIKeyService) incOperation(operation, status string) {
+	if s.metrics != nil {
+		s.metrics.IncOperation(operation, status)
+	}
+}
+
+func isKnownScope(scope string) bool {
+	for _, known := range KnownScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/rs/zerolog"
+)
+
+// AuthOptions configures token issuance
+type AuthOptions struct {
+	Issuer          string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// AuthService issues and verifies access and refresh tokens
+type AuthService struct {
+	users   repository.UserRepository
+	tokens  repository.RefreshTokenRepository
+	signer  auth.TokenSigner
+	opts    AuthOptions
+	log     *zerolog.Logger
+	metrics *metrics.Metrics
+	now     func() time.Time
+}
+
+// NewAuthService creates a new auth service
+func NewAuthService(users repository.UserRepository, tokens repository.RefreshTokenRepository, signer auth.TokenSigner, opts AuthOptions, log *zerolog.Logger, m *metrics.Metrics) *AuthService {
+	return &AuthService{
+		users:   users,
+		tokens:  tokens,
+		signer:  signer,
+		opts:    opts,
+		log:     log,
+		metrics: m,
+		now:     func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Login exchanges email and password credentials for a token pair
+func (s *AuthService) Login(ctx context.Context, email, password string) (*models.TokenResponse, error) {
+	invalid := errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid email or password", "", nil)
+
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		s.log.Error().Err(err).Str("email", email).Msg("Error getting user for login")
+		return nil, errors.ErrInternalServer
+	}
+	if user == nil || user.PasswordHash == "" {
+		auth.VerifyDummyPassword(password)
+		s.incOperation("login", "invalid")
+		return nil, invalid
+	}
+	if err := auth.VerifyPassword(password, user.PasswordHash); err != nil {
+		s.incOperation("login", "invalid")
+		s.log.Warn().Str("user_id", user.ID).Msg("Failed login attempt")
+		return nil, invalid
+	}
+	if !user.Active {
+		s.incOperation("login", "inactive")
+		return nil, invalid
+	}
+
+	response, err := s.issue(ctx, user, "", uuid.New().String())
+	if err != nil {
+		return nil, err
+	}
+
+	s.incOperation("login", "success")
+	s.log.Info().Str("user_id", user.ID).Msg("User logged in")
+
+	return response, nil
+}
+
+// Refresh rotates a refresh token. Presenting a token that was already
+// rotated is treated as theft and revokes the whole token family.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (*models.TokenResponse, error) {
+	invalid := errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid refresh token", "", nil)
+
+	token, err := s.tokens.GetByHash(ctx, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error getting refresh token")
+		return nil, errors.ErrInternalServer
+	}
+	if token == nil || token.RevokedAt != nil {
+		s.incOperation("refresh", "invalid")
+		return nil, invalid
+	}
+
+	now := s.now()
+	if token.UsedAt != nil {
+		s.revokeFamilyOnReuse(ctx, token)
+		return nil, invalid
+	}
+	if !token.IsActive(now) {
+		s.incOperation("refresh", "expired")
+		return nil, invalid
+	}
+
+	user, err := s.users.GetByID(ctx, token.UserID)
+	if err != nil {
+		s.log.Error().Err(err).Str("user_id", token.UserID).Msg("Error getting user for refresh")
+		return nil, errors.ErrInternalServer
+	}
+	if user == nil || !user.Active {
+		s.incOperation("refresh", "inactive")
+		return nil, invalid
+	}
+
+	// Claim the old token before issuing a new one so that concurrent
+	// refreshes with the same token cannot both succeed
+	nextID := uuid.New().String()
+	claimed, err := s.tokens.MarkUsed(ctx, token.ID, nextID, now)
+	if err != nil {
+		s.log.Error().Err(err).Str("token_id", token.ID).Msg("Error marking refresh token used")
+		return nil, errors.ErrInternalServer
+	}
+	if !claimed {
+		s.revokeFamilyOnReuse(ctx, token)
+		return nil, invalid
+	}
+
+	response, err := s.issue(ctx, user, token.FamilyID, nextID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.incOperation("refresh", "success")
+	return response, nil
+}
+
+// Logout revokes the token family of the given refresh token
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	token, err := s.tokens.GetByHash(ctx, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error getting refresh token for logout")
+		return errors.ErrInternalServer
+	}
+	// Unknown tokens are ignored so logout stays idempotent
+	if token == nil {
+		return nil
+	}
+
+	if err := s.tokens.RevokeFamily(ctx, token.FamilyID, s.now()); err != nil {
+		s.log.Error().Err(err).Str("family_id", token.FamilyID).Msg("Error revoking refresh token family")
+		return errors.ErrInternalServer
+	}
+
+	s.incOperation("logout", "success")
+	s.log.Info().Str("user_id", token.UserID).Str("family_id", token.FamilyID).Msg("User logged out")
+
+	return nil
+}
+
+// VerifyAccessToken validates an access token and returns its principal
+func (s *AuthService) VerifyAccessToken(ctx context.Context, token string) (*auth.Principal, error) {
+	claims, err := s.signer.Verify(token)
+	if err != nil {
+		return nil, errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid access token", "", err)
+	}
+	if s.opts.Issuer != "" && claims.Issuer != s.opts.Issuer {
+		return nil, errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid access token", "", auth.ErrInvalidToken)
+	}
+
+	return &auth.Principal{
+		Subject:   claims.Subject,
+		Type:      models.OwnerTypeUser,
+		Method:    auth.MethodJWT,
+		Role:      claims.Role,
+		TokenID:   claims.ID,
+		Scopes:    claims.Scopes(),
+		IssuedAt:  claims.IssuedTime(),
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// IssueTokens starts a new token family for a user who was authenticated
+// by other means, such as an external identity provider
+func (s *AuthService) IssueTokens(ctx context.Context, user *models.User) (*models.TokenResponse, error) {
+	if !user.Active {
+		return nil, errors.NewAppError(errors.ErrCodeUnauthorized, "User is inactive", "", nil)
+	}
+	return s.issue(ctx, user, "", uuid.New().String())
+}
+
+// issue signs an access token and stores a refresh token with the given
+// ID. An empty familyID starts a new token family.
+func (s *AuthService) issue(ctx context.Context, user *models.User, familyID, refreshID string) (*models.TokenResponse, error) {
+	now := s.now()
+	scopes := auth.ScopesForRole(user.Role)
+
+	accessToken, err := s.signer.Sign(&auth.Claims{
+		Issuer:    s.opts.Issuer,
+		Subject:   user.ID,
+		ExpiresAt: now.Add(s.opts.AccessTokenTTL).Unix(),
+		IssuedAt:  auth.NumericDate(now),
+		NotBefore: now.Unix(),
+		ID:        uuid.New().String(),
+		Role:      user.Role,
+		Scope:     strings.Join(scopes, " "),
+	})
+	if err != nil {
+		s.log.Error().Err(err).Str("user_id", user.ID).Msg("Error signing access token")
+		return nil, errors.ErrInternalServer
+	}
+
+	rawRefresh, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error generating refresh token")
+		return nil, errors.ErrInternalServer
+	}
+	refresh := models.NewRefreshToken(familyID, user.ID, hash, s.opts.RefreshTokenTTL)
+	refresh.ID = refreshID
+	if familyID == "" {
+		refresh.FamilyID = refreshID
+	}
+	if err := s.tokens.Create(ctx, refresh); err != nil {
+		s.log.Error().Err(err).Str("user_id", user.ID).Msg("Error storing refresh token")
+		return nil, errors.ErrInternalServer
+	}
+
+	return &models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.opts.AccessTokenTTL.Seconds()),
+		RefreshToken: rawRefresh,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+func (s *AuthService) revokeFamilyOnReuse(ctx context.Context, token *models.RefreshToken) {
+	s.incOperation("refresh", "reuse_detected")
+	s.log.Warn().
+		Str("user_id", token.UserID).
+		Str("family_id", token.FamilyID).
+		Str("token_id", token.ID).
+		Msg("Refresh token reuse detected, revoking token family")
+
+	if err := s.tokens.RevokeFamily(ctx, token.FamilyID, s.now()); err != nil {
+		s.log.Error().Err(err).Str("family_id", token.FamilyID).Msg("Error revoking refresh token family")
+	}
+}
+
+func (s *AuthService) incOperation(operation, status string) {
+	if s.metrics != nil {
+		s.metrics.IncOperation(operation, status)
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/rs/zerolog"
+)
+
+// OIDCService handles single sign-on through an external OpenID Connect
+// identity provider. Users are provisioned just in time on first login and
+// their role is synced from the IdP's groups on every login.
+type OIDCService struct {
+	provider *auth.OIDCProvider
+	roles    *auth.RoleMapper
+	users    repository.UserRepository
+	tokens   *AuthService
+	log      *zerolog.Logger
+	metrics  *metrics.Metrics
+	revoker  SessionRevoker
+}
+
+// NewOIDCService creates a new OIDC service
+func NewOIDCService(provider *auth.OIDCProvider, roles *auth.RoleMapper, users repository.UserRepository, tokens *AuthService, log *zerolog.Logger, m *metrics.Metrics) *OIDCService {
+	return &OIDCService{
+		provider: provider,
+		roles:    roles,
+		users:    users,
+		tokens:   tokens,
+		log:      log,
+		metrics:  m,
+	}
+}
+
+// SetSessionRevoker makes the service revoke a user's sessions when a
+// login syncs a new role
+func (s *OIDCService) SetSessionRevoker(revoker SessionRevoker) {
+	s.revoker = revoker
+}
+
+// AuthCodeURL returns the IdP URL to redirect the browser to
+func (s *OIDCService) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	url, err := s.provider.AuthCodeURL(ctx, state, nonce, codeChallenge)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error building OIDC authorization URL")
+		return "", errors.NewAppError(errors.ErrCodeServiceUnavailable, "Identity provider unavailable", "", err)
+	}
+	return url, nil
+}
+
+// Callback completes a code flow login: it redeems the code, verifies the
+// ID token, provisions the user and issues local tokens
+func (s *OIDCService) Callback(ctx context.Context, code, codeVerifier, nonce string) (*models.TokenResponse, error) {
+	failed := errors.NewAppError(errors.ErrCodeUnauthorized, "Single sign-on failed", "", nil)
+
+	tokens, err := s.provider.Exchange(ctx, code, codeVerifier)
+	if err != nil {
+		s.incOperation("oidc_login", "exchange_failed")
+		s.log.Warn().Err(err).Msg("OIDC code exchange failed")
+		return nil, failed
+	}
+
+	identity, err := s.provider.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		s.incOperation("oidc_login", "invalid_token")
+		s.log.Warn().Err(err).Msg("OIDC ID token rejected")
+		return nil, failed
+	}
+
+	user, err := s.provision(ctx, identity)
+	if err != nil {
+		s.incOperation("oidc_login", "rejected")
+		return nil, err
+	}
+
+	response, err := s.tokens.IssueTokens(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	s.incOperation("oidc_login", "success")
+	s.log.Info().Str("user_id", user.ID).Str("subject", identity.Subject).Msg("User logged in via OIDC")
+
+	return response, nil
+}
+
+// VerifyAccessToken accepts access tokens issued by the IdP for this API
+// to users who have logged in through single sign-on. The user's role is
+// the one synced at the last login; the token's groups are not applied.
+// It satisfies the same contract as AuthService.VerifyAccessToken.
+func (s *OIDCService) VerifyAccessToken(ctx context.Context, token string) (*auth.Principal, error) {
+	identity, err := s.provider.VerifyAccessToken(ctx, token)
+	if err != nil {
+		return nil, errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid access token", "", err)
+	}
+
+	user, err := s.users.GetByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err != nil {
+		s.log.Error().Err(err).Str("subject", identity.Subject).Msg("Error getting user for OIDC access token")
+		return nil, errors.ErrInternalServer
+	}
+	if user == nil {
+		s.incOperation("oidc_access_token", "unknown_subject")
+		return nil, errors.NewAppError(errors.ErrCodeUnauthorized, "Invalid access token", "", nil)
+	}
+	if !user.Active {
+		s.incOperation("oidc_access_token", "user_inactive")
+		return nil, errors.NewAppError(errors.ErrCodeForbidden, "User is inactive", "", nil)
+	}
+
+	return &auth.Principal{
+		Subject:   user.ID,
+		Type:      models.OwnerTypeUser,
+		Method:    auth.MethodOIDC,
+		Role:      user.Role,
+		TokenID:   identity.TokenID,
+		Scopes:    auth.ScopesForRole(user.Role),
+		IssuedAt:  identity.IssuedAt,
+		ExpiresAt: identity.ExpiresAt,
+	}, nil
+}
+
+// provision finds the user linked to an IdP identity and syncs the user's
+// role from the identity's groups. On the first login the identity is
+// linked to the user with the same email address, or a user is created;
+// both need an email address the IdP has verified.
+func (s *OIDCService) provision(ctx context.Context, identity *auth.Identity) (*models.User, error) {
+	role := s.roles.Role(identity.Groups)
+
+	user, err := s.users.GetByIdentity(ctx, identity.Issuer, identity.Subject)
+	if err != nil {
+		s.log.Error().Err(err).Str("subject", identity.Subject).Msg("Error getting user for OIDC login")
+		return nil, errors.ErrInternalServer
+	}
+
+	linked := false
+	if user == nil {
+		email := strings.ToLower(strings.TrimSpace(identity.Email))
+		if email == "" || !identity.EmailVerified {
+			s.log.Warn().Str("subject", identity.Subject).Msg("OIDC identity has no verified email")
+			return nil, errors.NewAppError(errors.ErrCodeForbidden, "A verified email address is required", "", nil)
+		}
+
+		user, err = s.users.GetByEmail(ctx, email)
+		if err != nil {
+			s.log.Error().Err(err).Str("email", email).Msg("Error getting user for OIDC login")
+			return nil, errors.ErrInternalServer
+		}
+
+		if user == nil {
+			name := identity.Name
+			if name == "" {
+				name = email
+			}
+			user = models.NewUser(email, name, role)
+			user.OIDCIssuer = identity.Issuer
+			user.OIDCSubject = identity.Subject
+			if err := s.users.Create(ctx, user); err != nil {
+				s.log.Error().Err(err).Str("email", email).Msg("Error provisioning OIDC user")
+				return nil, errors.ErrInternalServer
+			}
+			s.incOperation("oidc_provision", "success")
+			s.log.Info().Str("user_id", user.ID).Str("role", role).Msg("Provisioned user from OIDC identity")
+			return user, nil
+		}
+
+		if user.OIDCSubject != "" {
+			s.log.Warn().
+				Str("user_id", user.ID).
+				Str("subject", identity.Subject).
+				Msg("OIDC login for email linked to another identity")
+			return nil, errors.NewAppError(errors.ErrCodeForbidden, "Email address is linked to another account", "", nil)
+		}
+		linked = true
+	}
+
+	if !user.Active {
+		s.log.Warn().Str("user_id", user.ID).Msg("OIDC login for inactive user")
+		return nil, errors.NewAppError(errors.ErrCodeForbidden, "User is inactive", "", nil)
+	}
+
+	roleChanged := user.Role != role
+	if linked || roleChanged || (identity.Name != "" && user.Name != identity.Name) {
+		s.log.Info().
+			Str("user_id", user.ID).
+			Bool("linked", linked).
+			Str("old_role", user.Role).
+			Str("new_role", role).
+			Msg("Syncing user from OIDC identity")
+		user.Role = role
+		if identity.Name != "" {
+			user.Name = identity.Name
+		}
+		user.OIDCIssuer = identity.Issuer
+		user.OIDCSubject = identity.Subject
+		user.UpdatedAt = time.Now().UTC()
+		if err := s.users.Update(ctx, user); err != nil {
+			s.log.Error().Err(err).Str("user_id", user.ID).Msg("Error syncing OIDC user")
+			return nil, errors.ErrInternalServer
+		}
+	}
+
+	// Tokens issued before the change carry the old role's scopes
+	if roleChanged && s.revoker != nil {
+		if err := s.revoker.RevokeUserSessions(ctx, user.ID, "role_changed"); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (s *OIDCService) incOperation(operation, status string) {
+	if s.metrics != nil {
+		s.metrics.IncOperation(operation, status)
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/rs/zerolog"
+)
+
+// DefaultKeyRefreshInterval is how often keys are reloaded from storage so
+// that rotations made by other replicas are picked up
+const DefaultKeyRefreshInterval = time.Minute
+
+// SigningKeyOptions configures the signing key lifecycle
+type SigningKeyOptions struct {
+	// RotationInterval is how long a key stays active before it is rotated
+	// automatically. Zero disables scheduled rotation.
+	RotationInterval time.Duration
+
+	// RetentionPeriod is how long a rotated key keeps verifying tokens. It
+	// must be at least the access token TTL.
+	RetentionPeriod time.Duration
+
+	// RefreshInterval is how often Run reloads keys from storage
+	RefreshInterval time.Duration
+}
+
+// SigningKeyService manages the lifecycle of token signing keys
+type SigningKeyService struct {
+	repo    repository.SigningKeyRepository
+	keys    *auth.KeySet
+	opts    SigningKeyOptions
+	log     *zerolog.Logger
+	metrics *metrics.Metrics
+	now     func() time.Time
+
+	// mu serializes lifecycle changes made by this replica; the repository
+	// lock serializes them across replicas
+	mu sync.Mutex
+}
+
+// NewSigningKeyService creates a new signing key service
+func NewSigningKeyService(repo repository.SigningKeyRepository, keys *auth.KeySet, opts SigningKeyOptions, log *zerolog.Logger, m *metrics.Metrics) *SigningKeyService {
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultKeyRefreshInterval
+	}
+	return &SigningKeyService{
+		repo:    repo,
+		keys:    keys,
+		opts:    opts,
+		log:     log,
+		metrics: m,
+		now:     func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Init loads the key set, generating the first active and next keys when
+// storage is empty
+func (s *SigningKeyService) Init(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replicas starting together must not each create an active key
+	unlock, err := s.repo.Lock(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock signing keys: %w", err)
+	}
+	defer unlock()
+
+	keys, err := s.repo.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	if active, _ := findKeys(keys); active == nil {
+		s.log.Info().Msg("No active signing key found, generating initial keys")
+		if keys, err = s.rotate(ctx, keys); err != nil {
+			return err
+		}
+	}
+	return s.load(ctx, keys)
+}
+
+// Rotate promotes the next key to active, keeps the old active key for
+// verification until its tokens expire and publishes a new next key
+func (s *SigningKeyService) Rotate(ctx context.Context) (*models.SigningKeyListResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.repo.Lock(ctx)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error locking signing keys")
+		return nil, errors.ErrInternalServer
+	}
+	defer unlock()
+
+	keys, err := s.repo.List(ctx)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error listing signing keys")
+		return nil, errors.ErrInternalServer
+	}
+	if keys, err = s.rotate(ctx, keys); err != nil {
+		s.incOperation("signing_key_rotate", "error")
+		s.log.Error().Err(err).Msg("Error rotating signing keys")
+		return nil, errors.ErrInternalServer
+	}
+	if err := s.load(ctx, keys); err != nil {
+		s.log.Error().Err(err).Msg("Error loading rotated signing keys")
+		return nil, errors.ErrInternalServer
+	}
+	return keyList(keys), nil
+}
+
+// ListKeys returns every signing key, including retired ones
+func (s *SigningKeyService) ListKeys(ctx context.Context) (*models.SigningKeyListResponse, error) {
+	keys, err := s.repo.List(ctx)
+	if err != nil {
+		s.log.Error().Err(err).Msg("Error listing signing keys")
+		return nil, errors.ErrInternalServer
+	}
+	return keyList(keys), nil
+}
+
+// JWKS returns the public keys that verifiers should accept
+func (s *SigningKeyService) JWKS() auth.JSONWebKeySet {
+	return s.keys.JWKS()
+}
+
+// Run reloads keys periodically, retires expired keys and rotates the
+// active key when it is due. It blocks until ctx is cancelled.
+func (s *SigningKeyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.refresh(ctx); err != nil {
+				s.log.Error().Err(err).Msg("Error refreshing signing keys")
+			}
+		}
+	}
+}
+
+func (s *SigningKeyService) refresh(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.repo.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+
+	if s.rotationDue(keys) {
+		// Another replica may have rotated since the keys were listed, so
+		// check again under the lock
+		unlock, err := s.repo.Lock(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to lock signing keys: %w", err)
+		}
+		defer unlock()
+		if keys, err = s.repo.List(ctx); err != nil {
+			return fmt.Errorf("failed to list signing keys: %w", err)
+		}
+		if s.rotationDue(keys) {
+			active, _ := findKeys(keys)
+			s.log.Info().Str("kid", active.ID).Msg("Signing key rotation is due")
+			if keys, err = s.rotate(ctx, keys); err != nil {
+				s.incOperation("signing_key_rotate", "error")
+				return err
+			}
+		}
+	}
+	return s.load(ctx, keys)
+}
+
+// rotationDue reports whether the active key in keys has been active for
+// the rotation interval
+func (s *SigningKeyService) rotationDue(keys []*models.SigningKey) bool {
+	active, _ := findKeys(keys)
+	return active != nil && active.ActivatedAt != nil && s.opts.RotationInterval > 0 &&
+		!s.now().Before(active.ActivatedAt.Add(s.opts.RotationInterval))
+}
+
+// rotate performs a rotation on keys and returns the updated list. The
+// repository lock must be held and keys listed under it.
+func (s *SigningKeyService) rotate(ctx context.Context, keys []*models.SigningKey) ([]*models.SigningKey, error) {
+	now := s.now()
+	active, next := findKeys(keys)
+
+	// Without a published next key the new active key cannot have been
+	// cached by verifiers yet; this only happens on first start
+	if next == nil {
+		generated, err := s.create(ctx)
+		if err != nil {
+			return nil, err
+		}
+		next = generated
+		keys = append(keys, next)
+	}
+
+	if active != nil {
+		retireAt := now.Add(s.opts.RetentionPeriod)
+		active.Status = models.SigningKeyPrevious
+		active.RetireAt = &retireAt
+		if err := s.repo.Update(ctx, active); err != nil {
+			return nil, fmt.Errorf("failed to update signing key %s: %w", active.ID, err)
+		}
+	}
+
+	next.Status = models.SigningKeyActive
+	next.ActivatedAt = &now
+	if err := s.repo.Update(ctx, next); err != nil {
+		return nil, fmt.Errorf("failed to update signing key %s: %w", next.ID, err)
+	}
+
+	upcoming, err := s.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys = append(keys, upcoming)
+
+	s.incOperation("signing_key_rotate", "success")
+	s.log.Info().Str("active_kid", next.ID).Str("next_kid", upcoming.ID).Msg("Rotated signing keys")
+
+	return keys, nil
+}
+
+// load retires expired keys and installs the rest in the key set
+func (s *SigningKeyService) load(ctx context.Context, keys []*models.SigningKey) error {
+	now := s.now()
+	for _, key := range keys {
+		if key.Status != models.SigningKeyPrevious || key.RetireAt == nil || now.Before(*key.RetireAt) {
+			continue
+		}
+		// Retired keys never verify again, so drop the private material
+		key.Status = models.SigningKeyRetired
+		key.RetiredAt = &now
+		key.PrivateKey = ""
+		if err := s.repo.Update(ctx, key); err != nil {
+			return fmt.Errorf("failed to retire signing key %s: %w", key.ID, err)
+		}
+		s.log.Info().Str("kid", key.ID).Msg("Retired signing key")
+	}
+
+	previous := s.keys.ActiveKeyID()
+	if err := s.keys.Load(keys); err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	if active := s.keys.ActiveKeyID(); previous != "" && active != previous {
+		s.log.Info().Str("kid", active).Msg("Signing with new active key")
+	}
+	return nil
+}
+
+func (s *SigningKeyService) create(ctx context.Context) (*models.SigningKey, error) {
+	key, err := auth.GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+	return key, nil
+}
+
+func (s *SigningKeyService) incOperation(operation, status string) {
+	if s.metrics != nil {
+		s.metrics.IncOperation(operation, status)
+	}
+}
+
+// findKeys returns the active and next keys, if present
+func findKeys(keys []*models.SigningKey) (active, next *models.SigningKey) {
+	for _, key := range keys {
+		switch key.Status {
+		case models.SigningKeyActive:
+			active = key
+		case models.SigningKeyNext:
+			next = key
+		}
+	}
+	return active, next
+}
+
+func keyList(keys []*models.SigningKey) *models.SigningKeyListResponse {
+	response := &models.SigningKeyListResponse{
+		Keys: make([]*models.SigningKeyResponse, 0, len(keys)),
+	}
+	for _, key := range keys {
+		response.Keys = append(response.Keys, key.ToResponse())
+	}
+	return response
+}
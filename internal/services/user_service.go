@@ -2,13 +2,19 @@ package services
 
 import (
 	"context"
-	"errors"
+	stderrors "errors"
+	"time"
 
+	"github.com/pipeline-arch/app/internal/auth"
 	"github.com/pipeline-arch/app/internal/models"
 	"github.com/pipeline-arch/app/internal/repository"
 	"github.com/pipeline-arch/app/pkg/errors"
+	"github.com/pipeline-arch/app/pkg/logger"
 	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/pipeline-arch/app/pkg/tracing"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // UserService handles user business logic
@@ -16,6 +22,8 @@ type UserService struct {
 	repo    repository.UserRepository
 	log     *zerolog.Logger
 	metrics *metrics.Metrics
+	revoker SessionRevoker
+	purger  CachePurger
 }
 
 // NewUserService creates a new user service
@@ -27,14 +35,39 @@ func NewUserService(repo repository.UserRepository, log *zerolog.Logger, m *metr
 	}
 }
 
+// logFor returns the request logger carried by ctx, or the service logger
+func (s *UserService) logFor(ctx context.Context) *zerolog.Logger {
+	return logger.FromContextOr(ctx, s.log)
+}
+
+// SetSessionRevoker makes the service revoke a user's sessions when the
+// user is deactivated, changes role or is deleted
+func (s *UserService) SetSessionRevoker(revoker SessionRevoker) {
+	s.revoker = revoker
+}
+
+// CachePurger drops cached responses tagged with surrogate keys
+type CachePurger interface {
+	Purge(ctx context.Context, surrogateKeys ...string) error
+}
+
+// SetCachePurger makes the service purge cached responses showing a user
+// when the user is created, updated or deleted
+func (s *UserService) SetCachePurger(purger CachePurger) {
+	s.purger = purger
+}
+
 // CreateUser creates a new user
-func (s *UserService) CreateUser(ctx context.Context, req *models.UserCreateRequest) (*models.UserResponse, error) {
-	s.log.Info().Str("email", req.Email).Msg("Creating new user")
+func (s *UserService) CreateUser(ctx context.Context, req *models.UserCreateRequest) (resp *models.UserResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.CreateUser")
+	defer func() { tracing.End(span, err) }()
+
+	s.logFor(ctx).Info().Str("email", req.Email).Msg("Creating new user")
 
 	// Check if user with email already exists
 	existing, err := s.repo.GetByEmail(ctx, req.Email)
 	if err != nil {
-		s.log.Error().Err(err).Str("email", req.Email).Msg("Error checking existing user")
+		s.logFor(ctx).Error().Err(err).Str("email", req.Email).Msg("Error checking existing user")
 		return nil, errors.ErrInternalServer
 	}
 	if existing != nil {
@@ -48,27 +81,40 @@ func (s *UserService) CreateUser(ctx context.Context, req *models.UserCreateRequ
 
 	// Create new user
 	user := models.NewUser(req.Email, req.Name, req.Role)
+	if req.Password != "" {
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			s.logFor(ctx).Error().Err(err).Str("email", req.Email).Msg("Error hashing password")
+			return nil, errors.ErrInternalServer
+		}
+		user.PasswordHash = hash
+	}
 	if err := s.repo.Create(ctx, user); err != nil {
-		s.log.Error().Err(err).Str("email", req.Email).Msg("Error creating user")
+		s.logFor(ctx).Error().Err(err).Str("email", req.Email).Msg("Error creating user")
 		return nil, errors.ErrInternalServer
 	}
 
+	s.purgeCache(ctx, models.UsersSurrogateKey)
+
 	// Update metrics
 	s.metrics.IncUsers()
 	s.metrics.IncOperation("create", "success")
 
-	s.log.Info().Str("user_id", user.ID).Str("email", user.Email).Msg("User created successfully")
+	s.logFor(ctx).Info().Str("user_id", user.ID).Str("email", user.Email).Msg("User created successfully")
 
 	return user.ToResponse(), nil
 }
 
 // GetUser retrieves a user by ID
-func (s *UserService) GetUser(ctx context.Context, id string) (*models.UserResponse, error) {
-	s.log.Info().Str("user_id", id).Msg("Getting user")
+func (s *UserService) GetUser(ctx context.Context, id string) (resp *models.UserResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetUser", trace.WithAttributes(attribute.String("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	s.logFor(ctx).Info().Str("user_id", id).Msg("Getting user")
 
 	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		s.log.Error().Err(err).Str("user_id", id).Msg("Error getting user")
+		s.logFor(ctx).Error().Err(err).Str("user_id", id).Msg("Error getting user")
 		return nil, errors.ErrInternalServer
 	}
 	if user == nil {
@@ -80,7 +126,10 @@ func (s *UserService) GetUser(ctx context.Context, id string) (*models.UserRespo
 }
 
 // ListUsers retrieves a paginated list of users
-func (s *UserService) ListUsers(ctx context.Context, page, pageSize int) (*models.UserListResponse, error) {
+func (s *UserService) ListUsers(ctx context.Context, page, pageSize int) (resp *models.UserListResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.ListUsers")
+	defer func() { tracing.End(span, err) }()
+
 	if page < 1 {
 		page = 1
 	}
@@ -88,18 +137,18 @@ func (s *UserService) ListUsers(ctx context.Context, page, pageSize int) (*model
 		pageSize = 10
 	}
 
-	s.log.Info().Int("page", page).Int("page_size", pageSize).Msg("Listing users")
+	s.logFor(ctx).Info().Int("page", page).Int("page_size", pageSize).Msg("Listing users")
 
 	offset := (page - 1) * pageSize
 	users, err := s.repo.List(ctx, pageSize, offset)
 	if err != nil {
-		s.log.Error().Err(err).Msg("Error listing users")
+		s.logFor(ctx).Error().Err(err).Msg("Error listing users")
 		return nil, errors.ErrInternalServer
 	}
 
 	total, err := s.repo.Count(ctx)
 	if err != nil {
-		s.log.Error().Err(err).Msg("Error counting users")
+		s.logFor(ctx).Error().Err(err).Msg("Error counting users")
 		return nil, errors.ErrInternalServer
 	}
 
@@ -122,12 +171,15 @@ func (s *UserService) ListUsers(ctx context.Context, page, pageSize int) (*model
 }
 
 // UpdateUser updates an existing user
-func (s *UserService) UpdateUser(ctx context.Context, id string, req *models.UserUpdateRequest) (*models.UserResponse, error) {
-	s.log.Info().Str("user_id", id).Msg("Updating user")
+func (s *UserService) UpdateUser(ctx context.Context, id string, req *models.UserUpdateRequest) (resp *models.UserResponse, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateUser", trace.WithAttributes(attribute.String("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	s.logFor(ctx).Info().Str("user_id", id).Msg("Updating user")
 
 	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		s.log.Error().Err(err).Str("user_id", id).Msg("Error getting user for update")
+		s.logFor(ctx).Error().Err(err).Str("user_id", id).Msg("Error getting user for update")
 		return nil, errors.ErrInternalServer
 	}
 	if user == nil {
@@ -139,7 +191,7 @@ func (s *UserService) UpdateUser(ctx context.Context, id string, req *models.Use
 		// Check if email is already taken by another user
 		existing, err := s.repo.GetByEmail(ctx, *req.Email)
 		if err != nil {
-			s.log.Error().Err(err).Str("email", *req.Email).Msg("Error checking email")
+			s.logFor(ctx).Error().Err(err).Str("email", *req.Email).Msg("Error checking email")
 			return nil, errors.ErrInternalServer
 		}
 		if existing != nil && existing.ID != id {
@@ -155,31 +207,61 @@ func (s *UserService) UpdateUser(ctx context.Context, id string, req *models.Use
 	if req.Name != nil {
 		user.Name = *req.Name
 	}
+	// Outstanding tokens carry the old role and active state, so either
+	// change must end the user's sessions
+	var revokeReason string
+	if req.Role != nil && *req.Role != user.Role {
+		revokeReason = "role_changed"
+	}
+	if req.Active != nil && !*req.Active && user.Active {
+		revokeReason = "deactivated"
+	}
+
 	if req.Role != nil {
 		user.Role = *req.Role
 	}
 	if req.Active != nil {
 		user.Active = *req.Active
 	}
+	if req.Password != nil {
+		hash, err := auth.HashPassword(*req.Password)
+		if err != nil {
+			s.logFor(ctx).Error().Err(err).Str("user_id", id).Msg("Error hashing password")
+			return nil, errors.ErrInternalServer
+		}
+		user.PasswordHash = hash
+	}
+	user.UpdatedAt = time.Now().UTC()
 
 	if err := s.repo.Update(ctx, user); err != nil {
-		s.log.Error().Err(err).Str("user_id", id).Msg("Error updating user")
+		s.logFor(ctx).Error().Err(err).Str("user_id", id).Msg("Error updating user")
 		return nil, errors.ErrInternalServer
 	}
 
+	s.purgeCache(ctx, models.UserSurrogateKey(id))
+
+	if revokeReason != "" {
+		if err := s.revokeSessions(ctx, id, revokeReason); err != nil {
+			return nil, err
+		}
+	}
+
 	s.metrics.IncOperation("update", "success")
-	s.log.Info().Str("user_id", id).Msg("User updated successfully")
+	s.logFor(ctx).Info().Str("user_id", id).Msg("User updated successfully")
 
 	return user.ToResponse(), nil
 }
 
 // DeleteUser deletes a user
-func (s *UserService) DeleteUser(ctx context.Context, id string) error {
-	s.log.Info().Str("user_id", id).Msg("Deleting user")
+func (s *UserService) DeleteUser(ctx context.Context, id string) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.DeleteUser", trace.WithAttributes(attribute.String("user.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	s.logFor(ctx).Info().Str("user_id", id).Msg("Deleting user")
 
 	user, err := s.repo.GetByID(ctx, id)
 	if err != nil {
-		s.log.Error().Err(err).Str("user_id", id).Msg("Error getting user for delete")
+		s.logFor(ctx).Error().Err(err).Str("user_id", id).Msg("Error getting user for delete")
 		return errors.ErrInternalServer
 	}
 	if user == nil {
@@ -187,18 +269,43 @@ func (s *UserService) DeleteUser(ctx context.Context, id string) error {
 	}
 
 	if err := s.repo.Delete(ctx, id); err != nil {
-		s.log.Error().Err(err).Str("user_id", id).Msg("Error deleting user")
+		s.logFor(ctx).Error().Err(err).Str("user_id", id).Msg("Error deleting user")
 		return errors.ErrInternalServer
 	}
 
+	s.purgeCache(ctx, models.UserSurrogateKey(id), models.UsersSurrogateKey)
+
+	if err := s.revokeSessions(ctx, id, "deleted"); err != nil {
+		return err
+	}
+
 	s.metrics.IncOperation("delete", "success")
-	s.log.Info().Str("user_id", id).Msg("User deleted successfully")
+	s.logFor(ctx).Info().Str("user_id", id).Msg("User deleted successfully")
 
 	return nil
 }
 
+func (s *UserService) revokeSessions(ctx context.Context, id, reason string) error {
+	if s.revoker == nil {
+		return nil
+	}
+	return s.revoker.RevokeUserSessions(ctx, id, reason)
+}
+
+// purgeCache drops cached responses with the given surrogate keys. A
+// failed purge does not fail the change; stale responses then expire with
+// the cache TTL.
+func (s *UserService) purgeCache(ctx context.Context, surrogateKeys ...string) {
+	if s.purger == nil {
+		return
+	}
+	if err := s.purger.Purge(ctx, surrogateKeys...); err != nil {
+		s.logFor(ctx).Warn().Err(err).Strs("surrogate_keys", surrogateKeys).Msg("Error purging cached responses")
+	}
+}
+
 var (
-	ErrUserNotFound     = errors.New("user not found")
-	ErrUserAlreadyExists = errors.New("user already exists")
-	ErrInvalidInput     = errors.New("invalid input")
+	ErrUserNotFound     = stderrors.New("user not found")
+	ErrUserAlreadyExists = stderrors.New("user already exists")
+	ErrInvalidInput     = stderrors.New("invalid input")
 )
\ No newline at end of file
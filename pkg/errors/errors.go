@@ -4,8 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-
-	"github.com/go-chi/chi/v5/middleware"
+	"runtime"
+	"strings"
 )
 
 // AppError represents an application error
@@ -17,11 +17,15 @@ type AppError struct {
 	StackTrace string `json:"-"`
 }
 
-// Error returns the error message
+// Error returns the error message with the internal error or, when there
+// is none, the detail
 func (e *AppError) Error() string {
 	if e.Internal != nil {
 		return fmt.Sprintf("%s: %v", e.Message, e.Internal)
 	}
+	if e.Detail != "" {
+		return fmt.Sprintf("%s (%s)", e.Message, e.Detail)
+	}
 	return e.Message
 }
 
@@ -82,7 +86,7 @@ func NewAppError(code int, message string, detail string, internal error) *AppEr
 		Message:   message,
 		Detail:    detail,
 		Internal:  internal,
-		StackTrace: middleware.GetStackDir(2),
+		StackTrace: callerStack(),
 	}
 }
 
@@ -94,7 +98,7 @@ func WrapError(err error, code int, message string) *AppError {
 			Code:      code,
 			Message:   message,
 			Internal:  err,
-			StackTrace: middleware.GetStackDir(2),
+			StackTrace: callerStack(),
 		}
 	}
 	return &AppError{
@@ -102,8 +106,25 @@ func WrapError(err error, code int, message string) *AppError {
 		Message:   message,
 		Detail:    err.Error(),
 		Internal:  err,
-		StackTrace: middleware.GetStackDir(2),
+		StackTrace: callerStack(),
+	}
+}
+
+// callerStack returns the call stack of whoever created the error, leaving
+// out callerStack and the constructor that called it
+func callerStack() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
 	}
+	return b.String()
 }
 
 // NotFoundError creates a not found error
@@ -0,0 +1,242 @@
+// Package features evaluates feature flags with targeting rules. Flags are
+// defined in code, optionally adjusted from a YAML file and FEATURE_*
+// environment variables, and can be overridden at runtime.
+package features
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Name identifies a feature flag
+type Name string
+
+// Flags known to the application
+const (
+	RateLimiting   Name = "rate_limiting"
+	CircuitBreaker Name = "circuit_breaker"
+	RequestLogging Name = "request_logging"
+	LoadShedding   Name = "load_shedding"
+)
+
+// ErrUnknownFlag is returned when overriding a flag that is not defined
+var ErrUnknownFlag = errors.New("unknown feature flag")
+
+// Flag is a feature flag and its targeting rules. A flag that is not
+// Enabled is still on for targets whose role is in Roles or whose tenant
+// is in Tenants, and for Percentage percent of subjects. A subject stays
+// in or out of a rollout for as long as the percentage is unchanged.
+type Flag struct {
+	Name        Name     `yaml:"-"`
+	Description string   `yaml:"description"`
+	Enabled     bool     `yaml:"enabled"`
+	Roles       []string `yaml:"roles"`
+	Tenants     []string `yaml:"tenants"`
+	Percentage  int      `yaml:"percentage"`
+}
+
+// Target is who a flag is evaluated for
+type Target struct {
+	Subject string
+	Role    string
+	Tenant  string
+}
+
+type targetKey struct{}
+
+// WithTarget returns a context carrying the evaluation target
+func WithTarget(ctx context.Context, target Target) context.Context {
+	return context.WithValue(ctx, targetKey{}, target)
+}
+
+// TargetFromContext returns the evaluation target carried by ctx
+func TargetFromContext(ctx context.Context) (Target, bool) {
+	target, ok := ctx.Value(targetKey{}).(Target)
+	return target, ok
+}
+
+// State is a flag together with its runtime override, if any
+type State struct {
+	Flag
+	Override *bool
+}
+
+// Set holds the flags of the application and their runtime overrides. It
+// is safe for concurrent use.
+type Set struct {
+	mu         sync.RWMutex
+	flags      map[Name]Flag
+	overrides  map[Name]bool
+	onEvaluate func(name Name, enabled bool)
+}
+
+// NewSet creates a Set from flags. onEvaluate, when not nil, is called
+// with the result of every evaluation.
+func NewSet(flags []Flag, onEvaluate func(name Name, enabled bool)) *Set {
+	s := &Set{
+		flags:      make(map[Name]Flag, len(flags)),
+		overrides:  make(map[Name]bool),
+		onEvaluate: onEvaluate,
+	}
+	for _, flag := range flags {
+		s.flags[flag.Name] = flag
+	}
+	return s
+}
+
+// Replace swaps in new flag definitions, e.g. after the configuration is
+// reloaded. Overrides of flags that are still defined are kept.
+func (s *Set) Replace(flags []Flag) {
+	defs := make(map[Name]Flag, len(flags))
+	for _, flag := range flags {
+		defs[flag.Name] = flag
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags = defs
+	for name := range s.overrides {
+		if _, ok := defs[name]; !ok {
+			delete(s.overrides, name)
+		}
+	}
+}
+
+// Enabled evaluates the flag for the target carried by ctx. Unknown flags
+// are off.
+func (s *Set) Enabled(ctx context.Context, name Name) bool {
+	target, _ := TargetFromContext(ctx)
+
+	s.mu.RLock()
+	flag, ok := s.flags[name]
+	override, overridden := s.overrides[name]
+	s.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	enabled := override
+	if !overridden {
+		enabled = flag.evaluate(target)
+	}
+	if s.onEvaluate != nil {
+		s.onEvaluate(name, enabled)
+	}
+	return enabled
+}
+
+func (f Flag) evaluate(target Target) bool {
+	if f.Enabled {
+		return true
+	}
+	if target.Role != "" && contains(f.Roles, target.Role) {
+		return true
+	}
+	if target.Tenant != "" && contains(f.Tenants, target.Tenant) {
+		return true
+	}
+	if f.Percentage > 0 && target.Subject != "" {
+		return bucket(f.Name, target.Subject) < f.Percentage
+	}
+	return false
+}
+
+// bucket places a subject in one of 100 buckets, independently per flag so
+// that the same users are not always the first to get every rollout
+func bucket(name Name, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(subject))
+	return int(h.Sum32() % 100)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns every flag with its override, sorted by name
+func (s *Set) List() []State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]State, 0, len(s.flags))
+	for name := range s.flags {
+		states = append(states, s.stateLocked(name))
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// Get returns a single flag with its override
+func (s *Set) Get(name Name) (State, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.flags[name]; !ok {
+		return State{}, false
+	}
+	return s.stateLocked(name), true
+}
+
+func (s *Set) stateLocked(name Name) State {
+	state := State{Flag: s.flags[name]}
+	if override, ok := s.overrides[name]; ok {
+		state.Override = &override
+	}
+	return state
+}
+
+// Override turns a flag on or off for everyone, ignoring its targeting
+// rules until the override is cleared
+func (s *Set) Override(name Name, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.flags[name]; !ok {
+		return ErrUnknownFlag
+	}
+	s.overrides[name] = enabled
+	return nil
+}
+
+// ClearOverride returns a flag to its targeting rules
+func (s *Set) ClearOverride(name Name) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.flags[name]; !ok {
+		return ErrUnknownFlag
+	}
+	delete(s.overrides, name)
+	return nil
+}
+
+var defaultSet atomic.Pointer[Set]
+
+// SetDefault makes s the Set used by Enabled
+func SetDefault(s *Set) {
+	defaultSet.Store(s)
+}
+
+// Enabled evaluates the flag in the default Set for the target carried by
+// ctx. Every flag is off until SetDefault has been called.
+func Enabled(ctx context.Context, name Name) bool {
+	s := defaultSet.Load()
+	if s == nil {
+		return false
+	}
+	return s.Enabled(ctx, name)
+}
@@ -0,0 +1,107 @@
+package features
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileFormat is the layout of a feature flag file:
+//
+//	flags:
+//	  rate_limiting:
+//	    enabled: false
+//	    roles: [admin]
+//	    tenants: [acme]
+//	    percentage: 10
+type fileFormat struct {
+	Flags map[Name]Flag `yaml:"flags"`
+}
+
+// Load returns defaults adjusted by the YAML file at path, if any, and then
+// by environment variables. Flags in the file replace the default of the
+// same name or define new ones. For a flag named rate_limiting the
+// variables are FEATURE_RATE_LIMITING (on for everyone),
+// FEATURE_RATE_LIMITING_ROLES and FEATURE_RATE_LIMITING_TENANTS
+// (comma-separated) and FEATURE_RATE_LIMITING_PERCENTAGE.
+func Load(defaults []Flag, path string) ([]Flag, error) {
+	flags := make(map[Name]Flag, len(defaults))
+	order := make([]Name, 0, len(defaults))
+	for _, flag := range defaults {
+		flags[flag.Name] = flag
+		order = append(order, flag.Name)
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read feature flag file: %w", err)
+		}
+		var file fileFormat
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse feature flag file: %w", err)
+		}
+		for name, flag := range file.Flags {
+			if _, ok := flags[name]; !ok {
+				order = append(order, name)
+			}
+			flag.Name = name
+			if flag.Description == "" {
+				flag.Description = flags[name].Description
+			}
+			flags[name] = flag
+		}
+	}
+
+	result := make([]Flag, 0, len(order))
+	for _, name := range order {
+		flag, err := applyEnv(flags[name])
+		if err != nil {
+			return nil, err
+		}
+		if flag.Percentage < 0 || flag.Percentage > 100 {
+			return nil, fmt.Errorf("feature flag %s: percentage must be between 0 and 100", name)
+		}
+		result = append(result, flag)
+	}
+	return result, nil
+}
+
+func applyEnv(flag Flag) (Flag, error) {
+	prefix := "FEATURE_" + strings.ToUpper(string(flag.Name))
+
+	if value, ok := os.LookupEnv(prefix); ok {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return flag, fmt.Errorf("invalid %s: %w", prefix, err)
+		}
+		flag.Enabled = enabled
+	}
+	if value, ok := os.LookupEnv(prefix + "_ROLES"); ok {
+		flag.Roles = splitList(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "_TENANTS"); ok {
+		flag.Tenants = splitList(value)
+	}
+	if value, ok := os.LookupEnv(prefix + "_PERCENTAGE"); ok {
+		percentage, err := strconv.Atoi(value)
+		if err != nil {
+			return flag, fmt.Errorf("invalid %s_PERCENTAGE: %w", prefix, err)
+		}
+		flag.Percentage = percentage
+	}
+	return flag, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,263 @@
+// Package ipacl decides which client addresses may reach groups of routes,
+// by CIDR allow and deny lists that can be reloaded while serving.
+package ipacl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule admits addresses in Allow that are not in Deny. Entries are CIDRs
+// such as "10.0.0.0/8" or single addresses. An empty Allow admits every
+// address that is not denied.
+type Rule struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Config holds the proxies whose forwarding headers are believed and the
+// rule of each route group.
+//
+// A file holding a Config looks like:
+//
+//	trusted_proxies: [10.0.0.0/8]
+//	groups:
+//	  admin:
+//	    allow: [10.8.0.0/16, 10.0.0.0/8]
+//	    deny: [10.0.99.0/24]
+type Config struct {
+	TrustedProxies []string        `yaml:"trusted_proxies"`
+	Groups         map[string]Rule `yaml:"groups"`
+}
+
+type rule struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+type compiled struct {
+	trusted []netip.Prefix
+	groups  map[string]*rule
+}
+
+// ACL is a Config compiled for lookups. The file it was created with, if
+// any, is read again by Reload; lookups see either the old or the new
+// lists, never a mix.
+type ACL struct {
+	base Config
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+
+	current atomic.Pointer[compiled]
+}
+
+// New compiles base, overlaid with the Config in the YAML file at path if
+// path is not empty. The file's trusted_proxies, when present, replace
+// those of base, and its groups replace the groups of base with the same
+// name.
+func New(base Config, path string) (*ACL, error) {
+	a := &ACL{base: base, path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the file again. On error the lists in use are kept.
+func (a *ACL) Reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cfg := Config{TrustedProxies: a.base.TrustedProxies, Groups: make(map[string]Rule, len(a.base.Groups))}
+	for name, r := range a.base.Groups {
+		cfg.Groups[name] = r
+	}
+
+	var modTime time.Time
+	if a.path != "" {
+		info, err := os.Stat(a.path)
+		if err != nil {
+			return fmt.Errorf("failed to read IP ACL file: %w", err)
+		}
+		modTime = info.ModTime()
+		data, err := os.ReadFile(a.path)
+		if err != nil {
+			return fmt.Errorf("failed to read IP ACL file: %w", err)
+		}
+		var file Config
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("failed to parse IP ACL file: %w", err)
+		}
+		if file.TrustedProxies != nil {
+			cfg.TrustedProxies = file.TrustedProxies
+		}
+		for name, r := range file.Groups {
+			cfg.Groups[name] = r
+		}
+	}
+
+	c, err := compile(cfg)
+	if err != nil {
+		return err
+	}
+	a.current.Store(c)
+	a.modTime = modTime
+	return nil
+}
+
+// Watch reloads the file whenever its modification time changes, checking
+// every interval until ctx is done, and passes the result of each reload
+// to onReload when not nil. Config maps mounted by Kubernetes are picked
+// up this way without a restart.
+func (a *ACL) Watch(ctx context.Context, interval time.Duration, onReload func(err error)) {
+	if a.path == "" || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(a.path)
+		if err == nil {
+			a.mu.Lock()
+			changed := !info.ModTime().Equal(a.modTime)
+			a.mu.Unlock()
+			if !changed {
+				continue
+			}
+			err = a.Reload()
+		}
+		if onReload != nil {
+			onReload(err)
+		}
+	}
+}
+
+// Allowed reports whether addr may reach the routes of group. Groups
+// without a rule admit everyone.
+func (a *ACL) Allowed(group string, addr netip.Addr) bool {
+	r, ok := a.current.Load().groups[group]
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	if contains(r.deny, addr) {
+		return false
+	}
+	return len(r.allow) == 0 || contains(r.allow, addr)
+}
+
+// ClientIP returns the address of the client that sent r. Forwarding
+// headers are only believed when the peer is a trusted proxy: the client
+// is then the rightmost X-Forwarded-For entry that is not a trusted proxy
+// itself, or X-Real-IP when there is no X-Forwarded-For. Otherwise the
+// client is the peer. The zero Addr is returned when RemoteAddr holds no
+// address.
+func (a *ACL) ClientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	peer = peer.Unmap()
+
+	trusted := a.current.Load().trusted
+	if !contains(trusted, peer) {
+		return peer
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				// Whatever sent a malformed entry cannot be traced further
+				break
+			}
+			client = hop.Unmap()
+			if !contains(trusted, client) {
+				break
+			}
+		}
+		return client
+	}
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap()
+	}
+	return peer
+}
+
+func compile(cfg Config) (*compiled, error) {
+	trusted, err := parsePrefixes(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	c := &compiled{trusted: trusted, groups: make(map[string]*rule, len(cfg.Groups))}
+	for name, r := range cfg.Groups {
+		allow, err := parsePrefixes(r.Allow)
+		if err != nil {
+			return nil, fmt.Errorf("IP ACL group %s: %w", name, err)
+		}
+		deny, err := parsePrefixes(r.Deny)
+		if err != nil {
+			return nil, fmt.Errorf("IP ACL group %s: %w", name, err)
+		}
+		c.groups[name] = &rule{allow: allow, deny: deny}
+	}
+	return c, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,9 @@
 package logger
 
 import (
+	"context"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -14,24 +16,9 @@ type Logger struct {
 
 // New creates a new Logger instance
 func New(level string) *Logger {
-	// Parse log level
-	var zerologLevel zerolog.Level
-	switch level {
-	case "debug":
-		zerologLevel = zerolog.DebugLevel
-	case "info":
-		zerologLevel = zerolog.InfoLevel
-	case "warn":
-		zerologLevel = zerolog.WarnLevel
-	case "error":
-		zerologLevel = zerolog.ErrorLevel
-	default:
-		zerologLevel = zerolog.InfoLevel
-	}
-
 	// Configure zerolog
 	zerolog.TimeFieldFormat = time.RFC3339Nano
-	zerolog.SetGlobalLevel(zerologLevel)
+	SetLevel(level)
 
 	// Add console output for development
 	consoleWriter := zerolog.ConsoleWriter{
@@ -49,19 +36,42 @@ func New(level string) *Logger {
 	return &Logger{Logger: &logger}
 }
 
+// SetLevel changes the level of every logger; unknown levels mean info
+func SetLevel(level string) {
+	zerolog.SetGlobalLevel(parseLevel(level))
+}
+
+func parseLevel(level string) zerolog.Level {
+	switch level {
+	case "debug":
+		return zerolog.DebugLevel
+	case "info":
+		return zerolog.InfoLevel
+	case "warn":
+		return zerolog.WarnLevel
+	case "error":
+		return zerolog.ErrorLevel
+	default:
+		return zerolog.InfoLevel
+	}
+}
+
 // WithComponent returns a new logger with a component field
 func (l *Logger) WithComponent(component string) *zerolog.Logger {
-	return l.Logger.With().Str("component", component).Logger()
+	logger := l.Logger.With().Str("component", component).Logger()
+	return &logger
 }
 
 // WithRequestID returns a new logger with a request ID field
 func (l *Logger) WithRequestID(requestID string) *zerolog.Logger {
-	return l.Logger.With().Str("request_id", requestID).Logger()
+	logger := l.Logger.With().Str("request_id", requestID).Logger()
+	return &logger
 }
 
 // WithUser returns a new logger with a user field
 func (l *Logger) WithUser(userID string) *zerolog.Logger {
-	return l.Logger.With().Str("user_id", userID).Logger()
+	logger := l.Logger.With().Str("user_id", userID).Logger()
+	return &logger
 }
 
 // Debug logs a debug message
@@ -96,24 +106,62 @@ func (l *Logger) Panic() *zerolog.Event {
 
 // WithErr logs an error
 func (l *Logger) WithErr(err error) *zerolog.Event {
-	return l.Logger.With().Err(err).Logger()
+	return l.Logger.Error().Err(err)
 }
 
 // WithField adds a field to the logger
 func (l *Logger) WithField(key string, value interface{}) *zerolog.Logger {
-	return l.Logger.With().Interface(key, value).Logger()
+	logger := l.Logger.With().Interface(key, value).Logger()
+	return &logger
 }
 
 // WithFields adds multiple fields to the logger
 func (l *Logger) WithFields(fields map[string]interface{}) *zerolog.Logger {
-	logger := l.Logger
+	logger := *l.Logger
 	for key, value := range fields {
 		logger = logger.With().Interface(key, value).Logger()
 	}
 	return &logger
 }
 
-// Named returns a new logger with the given name
+// Named returns a new logger with a logger field naming it
 func (l *Logger) Named(name string) *Logger {
-	return &Logger{Logger: l.Logger.Named(name)}
-}
\ No newline at end of file
+	logger := l.Logger.With().Str("logger", name).Logger()
+	return &Logger{Logger: &logger}
+}
+
+type contextKey struct{}
+
+var defaultLogger atomic.Pointer[zerolog.Logger]
+
+func init() {
+	l := zerolog.New(os.Stderr).With().Timestamp().Logger()
+	defaultLogger.Store(&l)
+}
+
+// SetDefault sets the logger FromContext returns for contexts that do not
+// carry one
+func SetDefault(l *zerolog.Logger) {
+	defaultLogger.Store(l)
+}
+
+// WithContext returns a copy of ctx carrying l
+func WithContext(ctx context.Context, l *zerolog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx. Request contexts carry one
+// with the request and trace IDs; other contexts get the default logger.
+func FromContext(ctx context.Context) *zerolog.Logger {
+	return FromContextOr(ctx, defaultLogger.Load())
+}
+
+// FromContextOr returns the logger carried by ctx, or fallback when ctx
+// does not carry one. Components given a logger at construction use it as
+// the fallback.
+func FromContextOr(ctx context.Context, fallback *zerolog.Logger) *zerolog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*zerolog.Logger); ok {
+		return l
+	}
+	return fallback
+}
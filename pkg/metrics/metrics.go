@@ -3,19 +3,33 @@ package metrics
 import (
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Metrics holds all application metrics
+// Metrics holds all application metrics. A nil *Metrics records nothing,
+// so components may be built without one.
 type Metrics struct {
 	// HTTP metrics
 	httpRequestsTotal   *prometheus.CounterVec
 	httpRequestDuration *prometheus.HistogramVec
+	httpResponseSize    *prometheus.HistogramVec
 	httpRequestsInFlight prometheus.Gauge
+	httpConcurrencyLimit prometheus.Gauge
+	httpPanics           *prometheus.CounterVec
+	cspViolations        *prometheus.CounterVec
+	ipDenials            *prometheus.CounterVec
+	responseCache        *prometheus.CounterVec
+	configReloads        *prometheus.CounterVec
+
+	// inFlight mirrors httpRequestsInFlight for the load shedder
+	inFlight atomic.Int64
 
 	// Business metrics
 	usersTotal       prometheus.Counter
@@ -23,6 +37,13 @@ type Metrics struct {
 	buildsTotal      prometheus.Counter
 	deploymentsTotal *prometheus.CounterVec
 
+	// Resilience metrics
+	breakerState       *prometheus.GaugeVec
+	breakerTransitions *prometheus.CounterVec
+
+	// Feature flag metrics
+	featureEvaluations *prometheus.CounterVec
+
 	// Runtime metrics
 	goroutines prometheus.Gauge
 	memory     *prometheus.GaugeVec
@@ -30,17 +51,38 @@ type Metrics struct {
 	// Server
 	serverName string
 	metricsPort int
+
+	// registry is where the metrics are registered; nil means the default
+	// Prometheus registry
+	registry *prometheus.Registry
 }
 
-// New creates a new Metrics instance
+// New creates a new Metrics instance registered with the default
+// Prometheus registry. It may only be called once per process.
 func New(name string, port int) *Metrics {
+	return newMetrics(name, port, nil)
+}
+
+// NewWithRegistry creates a new Metrics instance registered with reg, so
+// that several instances, e.g. one per test, can coexist
+func NewWithRegistry(name string, port int, reg *prometheus.Registry) *Metrics {
+	return newMetrics(name, port, reg)
+}
+
+func newMetrics(name string, port int, reg *prometheus.Registry) *Metrics {
 	m := &Metrics{
 		serverName:  name,
 		metricsPort: port,
+		registry:    reg,
+	}
+	var registerer prometheus.Registerer = prometheus.DefaultRegisterer
+	if reg != nil {
+		registerer = reg
 	}
+	factory := promauto.With(registerer)
 
 	// HTTP metrics
-	m.httpRequestsTotal = promauto.NewCounterVec(
+	m.httpRequestsTotal = factory.NewCounterVec(
 		prometheus.CounterOpts{
 			Name:        "http_requests_total",
 			Help:        "Total number of HTTP requests",
@@ -49,7 +91,7 @@ func New(name string, port int) *Metrics {
 		[]string{"method", "path", "status"},
 	)
 
-	m.httpRequestDuration = promauto.NewHistogramVec(
+	m.httpRequestDuration = factory.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:        "http_request_duration_seconds",
 			Help:        "HTTP request duration in seconds",
@@ -59,7 +101,17 @@ func New(name string, port int) *Metrics {
 		[]string{"method", "path"},
 	)
 
-	m.httpRequestsInFlight = promauto.NewGauge(
+	m.httpResponseSize = factory.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:        "http_response_size_bytes",
+			Help:        "HTTP response body size in bytes",
+			ConstLabels: prometheus.Labels{"service": name},
+			Buckets:     prometheus.ExponentialBuckets(100, 10, 6),
+		},
+		[]string{"method", "path"},
+	)
+
+	m.httpRequestsInFlight = factory.NewGauge(
 		prometheus.GaugeOpts{
 			Name:        "http_requests_in_flight",
 			Help:        "Number of HTTP requests currently being processed",
@@ -67,8 +119,61 @@ func New(name string, port int) *Metrics {
 		},
 	)
 
+	m.httpConcurrencyLimit = factory.NewGauge(
+		prometheus.GaugeOpts{
+			Name:        "http_concurrency_limit",
+			Help:        "Adaptive limit on HTTP requests served at once",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+	)
+
+	m.httpPanics = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "http_panics_total",
+			Help:        "Total number of recovered panics by route",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"route"},
+	)
+
+	m.cspViolations = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "csp_violations_total",
+			Help:        "Total number of reported Content-Security-Policy violations by directive",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"directive"},
+	)
+
+	m.ipDenials = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "ip_filter_denials_total",
+			Help:        "Total number of requests denied by the IP filter by route group",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"group"},
+	)
+
+	m.responseCache = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "http_response_cache_lookups_total",
+			Help:        "Total number of response cache lookups by result (hit, miss)",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"result"},
+	)
+
+	m.configReloads = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "config_reloads_total",
+			Help:        "Total number of configuration reloads by result (success, failure)",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"result"},
+	)
+
 	// Business metrics
-	m.usersTotal = promauto.NewCounter(
+	m.usersTotal = factory.NewCounter(
 		prometheus.CounterOpts{
 			Name:        "app_users_total",
 			Help:        "Total number of users created",
@@ -76,7 +181,7 @@ func New(name string, port int) *Metrics {
 		},
 	)
 
-	m.operationsTotal = promauto.NewCounterVec(
+	m.operationsTotal = factory.NewCounterVec(
 		prometheus.CounterOpts{
 			Name:        "app_operations_total",
 			Help:        "Total number of operations by type",
@@ -85,7 +190,7 @@ func New(name string, port int) *Metrics {
 		[]string{"operation", "status"},
 	)
 
-	m.buildsTotal = promauto.NewCounter(
+	m.buildsTotal = factory.NewCounter(
 		prometheus.CounterOpts{
 			Name:        "app_builds_total",
 			Help:        "Total number of builds",
@@ -93,7 +198,7 @@ func New(name string, port int) *Metrics {
 		},
 	)
 
-	m.deploymentsTotal = promauto.NewCounterVec(
+	m.deploymentsTotal = factory.NewCounterVec(
 		prometheus.CounterOpts{
 			Name:        "app_deployments_total",
 			Help:        "Total number of deployments by environment",
@@ -102,8 +207,37 @@ func New(name string, port int) *Metrics {
 		[]string{"environment", "status"},
 	)
 
+	// Resilience metrics
+	m.breakerState = factory.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        "circuit_breaker_state",
+			Help:        "Circuit breaker state (0 closed, 1 open, 2 half-open)",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"name"},
+	)
+
+	m.breakerTransitions = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "circuit_breaker_transitions_total",
+			Help:        "Total number of circuit breaker state transitions",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"name", "from", "to"},
+	)
+
+	// Feature flag metrics
+	m.featureEvaluations = factory.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        "feature_flag_evaluations_total",
+			Help:        "Total number of feature flag evaluations by result",
+			ConstLabels: prometheus.Labels{"service": name},
+		},
+		[]string{"flag", "result"},
+	)
+
 	// Runtime metrics
-	m.goroutines = promauto.NewGauge(
+	m.goroutines = factory.NewGauge(
 		prometheus.GaugeOpts{
 			Name:        "app_goroutines",
 			Help:        "Number of active goroutines",
@@ -111,12 +245,11 @@ func New(name string, port int) *Metrics {
 		},
 	)
 
-	m.memory = promauto.NewGaugeVec(
+	m.memory = factory.NewGaugeVec(
 		prometheus.GaugeOpts{
-			GoCollectInterval: 10 * time.Second,
-			Name:              "app_memory_bytes",
-			Help:              "Memory usage in bytes",
-			ConstLabels:       prometheus.Labels{"service": name},
+			Name:        "app_memory_bytes",
+			Help:        "Memory usage in bytes",
+			ConstLabels: prometheus.Labels{"service": name},
 		},
 		[]string{"type"},
 	)
@@ -124,48 +257,108 @@ func New(name string, port int) *Metrics {
 	return m
 }
 
-// IncRequest increments the request counter
+// IncRequest increments the request counter without a method or status.
+//
+// Deprecated: requests are counted by Middleware; use IncOperation to count
+// what a handler did.
 func (m *Metrics) IncRequest(path string) {
+	if m == nil {
+		return
+	}
 	m.httpRequestsTotal.WithLabelValues("unknown", path, "200").Inc()
 }
 
 // IncRequestWithStatus increments the request counter with status
 func (m *Metrics) IncRequestWithStatus(method, path, status string) {
+	if m == nil {
+		return
+	}
 	m.httpRequestsTotal.WithLabelValues(method, path, status).Inc()
 }
 
 // ObserveDuration records the request duration
 func (m *Metrics) ObserveDuration(method, path string, duration time.Duration) {
+	if m == nil {
+		return
+	}
 	m.httpRequestDuration.WithLabelValues(method, path).Observe(duration.Seconds())
 }
 
+// ObserveResponseSize records the response body size
+func (m *Metrics) ObserveResponseSize(method, path string, bytes int) {
+	if m == nil {
+		return
+	}
+	m.httpResponseSize.WithLabelValues(method, path).Observe(float64(bytes))
+}
+
 // IncUsers increments the user counter
 func (m *Metrics) IncUsers() {
+	if m == nil {
+		return
+	}
 	m.usersTotal.Inc()
 }
 
 // IncOperation increments the operation counter
 func (m *Metrics) IncOperation(operation, status string) {
+	if m == nil {
+		return
+	}
 	m.operationsTotal.WithLabelValues(operation, status).Inc()
 }
 
 // IncBuilds increments the build counter
 func (m *Metrics) IncBuilds() {
+	if m == nil {
+		return
+	}
 	m.buildsTotal.Inc()
 }
 
 // IncDeployments increments the deployment counter
 func (m *Metrics) IncDeployments(environment, status string) {
+	if m == nil {
+		return
+	}
 	m.deploymentsTotal.WithLabelValues(environment, status).Inc()
 }
 
+// RecordBreakerTransition records a circuit breaker state change. state is
+// the numeric value of the new state.
+func (m *Metrics) RecordBreakerTransition(name, from, to string, state int) {
+	if m == nil {
+		return
+	}
+	m.breakerState.WithLabelValues(name).Set(float64(state))
+	m.breakerTransitions.WithLabelValues(name, from, to).Inc()
+}
+
+// RecordFeatureEvaluation counts a feature flag evaluation
+func (m *Metrics) RecordFeatureEvaluation(flag string, enabled bool) {
+	if m == nil {
+		return
+	}
+	result := "disabled"
+	if enabled {
+		result = "enabled"
+	}
+	m.featureEvaluations.WithLabelValues(flag, result).Inc()
+}
+
 // UpdateGoroutines updates the goroutine count
 func (m *Metrics) UpdateGoroutines(count int) {
+	if m == nil {
+		return
+	}
 	m.goroutines.Set(float64(count))
 }
 
 // UpdateMemory updates memory metrics
 func (m *Metrics) UpdateMemory(heap, stack, gc uint64) {
+	if m == nil {
+		return
+	}
 	m.memory.WithLabelValues("heap").Set(float64(heap))
 	m.memory.WithLabelValues("stack").Set(float64(stack))
 	m.memory.WithLabelValues("gc").Set(float64(gc))
@@ -173,6 +366,9 @@ func (m *Metrics) UpdateMemory(heap, stack, gc uint64) {
 
 // Handler returns the Prometheus metrics HTTP handler
 func (m *Metrics) Handler() http.Handler {
+	if m.registry != nil {
+		return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	}
 	return promhttp.Handler()
 }
 
@@ -195,24 +391,120 @@ func (m *Metrics) MetricsServer() *http.Server {
 	}
 }
 
-// Middleware returns a middleware for collecting HTTP metrics
+// Middleware returns a middleware for collecting HTTP metrics. Requests are
+// labelled with the chi route pattern rather than the raw path so that
+// /users/{id} is a single series. Panicking requests are recorded as 500
+// before the panic continues to the recovery middleware.
 func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		m.httpRequestsInFlight.Inc()
-		defer m.httpRequestsInFlight.Dec()
+		m.inFlight.Add(1)
+		defer func() {
+			m.httpRequestsInFlight.Dec()
+			m.inFlight.Add(-1)
+		}()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			rec := recover()
+			status := ww.Status()
+			if rec != nil {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+
+			path := RoutePattern(r)
+			m.RecordHTTPOutcome(r.Method, path, strconv.Itoa(status), time.Since(start))
+			m.ObserveResponseSize(r.Method, path, ww.BytesWritten())
+
+			if rec != nil {
+				panic(rec)
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
 
-		next.ServeHTTP(w, r)
+// InFlight returns the number of requests currently inside Middleware,
+// the value of http_requests_in_flight
+func (m *Metrics) InFlight() int {
+	if m == nil {
+		return 0
+	}
+	return int(m.inFlight.Load())
+}
 
-		duration := time.Since(start)
-		status := strconv.Itoa(200) // In production, get actual status
-		m.IncRequestWithStatus(r.Method, r.URL.Path, status)
-		m.ObserveDuration(r.Method, r.URL.Path, duration)
-	})
+// SetConcurrencyLimit records the current adaptive concurrency limit
+func (m *Metrics) SetConcurrencyLimit(limit int) {
+	if m == nil {
+		return
+	}
+	m.httpConcurrencyLimit.Set(float64(limit))
+}
+
+// RecordPanic counts a recovered panic. route is the route pattern of the
+// request, or the origin of a goroutine started with safego.Go.
+func (m *Metrics) RecordPanic(route string) {
+	if m == nil {
+		return
+	}
+	m.httpPanics.WithLabelValues(route).Inc()
+}
+
+// RecordCSPViolation counts a reported Content-Security-Policy violation
+func (m *Metrics) RecordCSPViolation(directive string) {
+	if m == nil {
+		return
+	}
+	m.cspViolations.WithLabelValues(directive).Inc()
+}
+
+// RecordIPDenial counts a request denied by the IP filter of a route group
+func (m *Metrics) RecordIPDenial(group string) {
+	if m == nil {
+		return
+	}
+	m.ipDenials.WithLabelValues(group).Inc()
+}
+
+// RecordCacheLookup counts a response cache lookup
+func (m *Metrics) RecordCacheLookup(result string) {
+	if m == nil {
+		return
+	}
+	m.responseCache.WithLabelValues(result).Inc()
+}
+
+// RecordConfigReload counts a configuration reload
+func (m *Metrics) RecordConfigReload(result string) {
+	if m == nil {
+		return
+	}
+	m.configReloads.WithLabelValues(result).Inc()
+}
+
+// RoutePattern returns the chi route pattern that served r, or "unmatched"
+// when no route did. It is only complete once routing has finished.
+func RoutePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unmatched"
 }
 
 // RecordHTTPOutcome records the outcome of an HTTP request
 func (m *Metrics) RecordHTTPOutcome(method, path, statusCode string, duration time.Duration) {
+	if m == nil {
+		return
+	}
 	m.httpRequestsTotal.WithLabelValues(method, path, statusCode).Inc()
 	m.httpRequestDuration.WithLabelValues(method, path).Observe(duration.Seconds())
 }
\ No newline at end of file
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired keys are dropped from memory
+const sweepInterval = time.Minute
+
+// MemoryStore keeps rate limit state in process. Limits are per replica.
+type MemoryStore struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tats: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// Allow applies one request for key against limit
+func (s *MemoryStore) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		s.sweep(now)
+	}
+
+	tat, result := gcra(now, s.tats[key], limit)
+	if result.Allowed {
+		s.tats[key] = tat
+	}
+	return result, nil
+}
+
+// sweep drops keys whose burst has fully refilled; callers must hold mu
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, tat := range s.tats {
+		if !tat.After(now) {
+			delete(s.tats, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,147 @@
+// Package ratelimit implements the generic cell rate algorithm (GCRA) with
+// pluggable storage so limits can be shared across replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Limit describes an allowed request rate
+type Limit struct {
+	// Rate requests are allowed per Period on average
+	Rate   int
+	Period time.Duration
+
+	// Burst is how many requests may be made at once; it defaults to Rate
+	Burst int
+}
+
+// PerMinute returns a limit of n requests per minute with a burst of n
+func PerMinute(n int) Limit {
+	return Limit{Rate: n, Period: time.Minute, Burst: n}
+}
+
+// IsZero reports whether the limit is unset
+func (l Limit) IsZero() bool {
+	return l.Rate <= 0 || l.Period <= 0
+}
+
+// String formats the limit as an IETF RateLimit-Policy value
+func (l Limit) String() string {
+	return fmt.Sprintf("%d;w=%d", l.Capacity(), int(l.Period.Seconds()))
+}
+
+// emissionInterval is the time between requests at a steady rate
+func (l Limit) emissionInterval() time.Duration {
+	return l.Period / time.Duration(l.Rate)
+}
+
+// Capacity returns the burst size, which defaults to Rate
+func (l Limit) Capacity() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// burstOffset is how far ahead of now the theoretical arrival time may be
+func (l Limit) burstOffset() time.Duration {
+	return l.emissionInterval() * time.Duration(l.Capacity())
+}
+
+// Result is the outcome of a rate limit check
+type Result struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining int
+
+	// RetryAfter is how long to wait before the next request is allowed;
+	// zero when the request was allowed
+	RetryAfter time.Duration
+
+	// ResetAfter is how long until the full burst is available again
+	ResetAfter time.Duration
+}
+
+// Store tracks the theoretical arrival time of each key
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (*Result, error)
+}
+
+// gcra applies one request to the theoretical arrival time tat. It returns
+// the new tat, which is unchanged when the request is denied.
+func gcra(now, tat time.Time, limit Limit) (time.Time, *Result) {
+	if tat.Before(now) {
+		tat = now
+	}
+
+	emission := limit.emissionInterval()
+	newTAT := tat.Add(emission)
+	allowAt := newTAT.Add(-limit.burstOffset())
+
+	if now.Before(allowAt) {
+		return tat, &Result{
+			Allowed:    false,
+			Limit:      limit,
+			Remaining:  0,
+			RetryAfter: allowAt.Sub(now),
+			ResetAfter: tat.Sub(now),
+		}
+	}
+
+	return newTAT, &Result{
+		Allowed:    true,
+		Limit:      limit,
+		Remaining:  int(now.Sub(allowAt) / emission),
+		ResetAfter: newTAT.Sub(now),
+	}
+}
+
+// Limiter applies one limit to keys in a shared store. Limiters with
+// different names keep separate quotas, so routes can override the default
+// limit by using a limiter of their own.
+type Limiter struct {
+	store Store
+	name  string
+	limit atomic.Pointer[Limit]
+}
+
+// NewLimiter creates a limiter named name enforcing limit on store
+func NewLimiter(store Store, name string, limit Limit) *Limiter {
+	l := &Limiter{
+		store: store,
+		name:  name,
+	}
+	l.SetLimit(limit)
+	return l
+}
+
+// Name returns the limiter name
+func (l *Limiter) Name() string {
+	return l.name
+}
+
+// Limit returns the enforced limit
+func (l *Limiter) Limit() Limit {
+	return *l.limit.Load()
+}
+
+// SetLimit changes the enforced limit. Keys keep their state, so callers
+// who used up the old burst wait until the new rate admits them.
+func (l *Limiter) SetLimit(limit Limit) {
+	l.limit.Store(&limit)
+}
+
+// Allow applies one request for key
+func (l *Limiter) Allow(ctx context.Context, key string) (*Result, error) {
+	return l.store.Allow(ctx, l.name+":"+key, l.Limit())
+}
+
+// AllowLimit applies one request for key against limit instead of the
+// enforced one, for callers with a quota of their own
+func (l *Limiter) AllowLimit(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return l.store.Allow(ctx, l.name+":"+key, limit)
+}
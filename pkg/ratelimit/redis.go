@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraScript applies GCRA atomically. Time comes from the Redis server so
+// that replicas with skewed clocks share one timeline. All durations are in
+// microseconds.
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+
+local key = KEYS[1]
+local emission = tonumber(ARGV[1])
+local burst_offset = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+local tat = tonumber(redis.call("GET", key))
+if not tat or tat < now then
+  tat = now
+end
+
+local new_tat = tat + emission
+local allow_at = new_tat - burst_offset
+local diff = now - allow_at
+
+if diff < 0 then
+  return {0, 0, -diff, tat - now}
+end
+
+redis.call("SET", key, new_tat, "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor(diff / emission), 0, new_tat - now}
+`)
+
+// RedisStore keeps rate limit state in Redis so limits hold across replicas
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore creates a Redis store. Keys are namespaced with prefix.
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Allow applies one request for key against limit
+func (s *RedisStore) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	values, err := gcraScript.Run(ctx, s.client, []string{s.prefix + key},
+		limit.emissionInterval().Microseconds(),
+		limit.burstOffset().Microseconds(),
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+		ResetAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
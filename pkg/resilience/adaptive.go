@@ -0,0 +1,136 @@
+package resilience
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimitOptions configures an AdaptiveLimit. Zero values select the
+// defaults noted on each field.
+type AdaptiveLimitOptions struct {
+	// InitialLimit is the concurrency allowed before any latency has been
+	// observed (default 100)
+	InitialLimit int
+	// MinLimit and MaxLimit bound the limit (defaults 10 and 1000)
+	MinLimit int
+	MaxLimit int
+	// Tolerance is how much slower than the long-term average latency may
+	// get before the limit shrinks (default 2)
+	Tolerance float64
+	// Smoothing is how far the limit moves toward a new estimate per
+	// sample, between 0 and 1 (default 0.2)
+	Smoothing float64
+	// OnChange is called with the new limit whenever its integer value
+	// changes
+	OnChange func(limit int)
+}
+
+// longRTTWindow is the number of samples the long-term latency average
+// spans
+const longRTTWindow = 600
+
+// AdaptiveLimit estimates how many requests a server can work on at once
+// from their latency, in the manner of the gradient algorithm of Netflix's
+// concurrency-limits. While latency stays near its long-term average the
+// limit grows by about its square root per sample; as latency rises the
+// limit shrinks in proportion, down to half per sample. Dropped requests
+// cut the limit multiplicatively, as in AIMD.
+type AdaptiveLimit struct {
+	mu        sync.Mutex
+	limit     float64
+	longRTT   float64
+	min       float64
+	max       float64
+	tolerance float64
+	smoothing float64
+	onChange  func(limit int)
+}
+
+// NewAdaptiveLimit creates an AdaptiveLimit
+func NewAdaptiveLimit(opts AdaptiveLimitOptions) *AdaptiveLimit {
+	if opts.MinLimit <= 0 {
+		opts.MinLimit = 10
+	}
+	if opts.MaxLimit <= 0 {
+		opts.MaxLimit = 1000
+	}
+	if opts.MaxLimit < opts.MinLimit {
+		opts.MaxLimit = opts.MinLimit
+	}
+	if opts.InitialLimit <= 0 {
+		opts.InitialLimit = 100
+	}
+	if opts.Tolerance < 1 {
+		opts.Tolerance = 2
+	}
+	if opts.Smoothing <= 0 || opts.Smoothing > 1 {
+		opts.Smoothing = 0.2
+	}
+
+	l := &AdaptiveLimit{
+		min:       float64(opts.MinLimit),
+		max:       float64(opts.MaxLimit),
+		tolerance: opts.Tolerance,
+		smoothing: opts.Smoothing,
+		onChange:  opts.OnChange,
+	}
+	l.limit = l.clamp(float64(opts.InitialLimit))
+	return l
+}
+
+// Limit returns the current concurrency limit
+func (l *AdaptiveLimit) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Observe feeds the latency of a finished request into the limit.
+// inFlight is the number of requests in flight when it finished, and
+// dropped reports that it timed out or was rejected downstream.
+func (l *AdaptiveLimit) Observe(rtt time.Duration, inFlight int, dropped bool) {
+	l.mu.Lock()
+	before := int(l.limit)
+
+	if dropped {
+		l.limit = l.clamp(l.limit * 0.9)
+	} else if rtt > 0 {
+		l.observe(float64(rtt), float64(inFlight))
+	}
+
+	after := int(l.limit)
+	onChange := l.onChange
+	l.mu.Unlock()
+
+	if after != before && onChange != nil {
+		onChange(after)
+	}
+}
+
+func (l *AdaptiveLimit) observe(rtt, inFlight float64) {
+	if l.longRTT == 0 {
+		l.longRTT = rtt
+	} else {
+		l.longRTT += (rtt - l.longRTT) / longRTTWindow
+	}
+	// Let the average catch up quickly once latency has recovered from a
+	// long period of overload
+	if l.longRTT/rtt > 2 {
+		l.longRTT *= 0.95
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.tolerance*l.longRTT/rtt))
+	estimate := l.limit*gradient + math.Sqrt(l.limit)
+
+	// A server using little of its limit learns nothing about whether it
+	// could take more
+	if estimate > l.limit && inFlight < l.limit/2 {
+		return
+	}
+	l.limit = l.clamp(l.limit*(1-l.smoothing) + estimate*l.smoothing)
+}
+
+func (l *AdaptiveLimit) clamp(limit float64) float64 {
+	return math.Max(l.min, math.Min(l.max, limit))
+}
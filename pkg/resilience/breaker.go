@@ -0,0 +1,252 @@
+// Package resilience protects calls to dependencies with circuit breakers,
+// retries with jittered backoff and retry budgets, and bulkheads.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned without calling the dependency while a
+// breaker is open
+var ErrBreakerOpen = errors.New("resilience: circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+// Breaker states
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+// String returns the state name used in logs and metric labels
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerOptions configures a Breaker. Zero values select the defaults.
+type BreakerOptions struct {
+	// Window is the period over which outcomes are counted while closed.
+	// Defaults to 10s.
+	Window time.Duration
+
+	// MinRequests is how many calls a window needs before the breaker may
+	// open. Defaults to 20.
+	MinRequests int
+
+	// FailureRatio opens the breaker when this share of calls in a window
+	// fail. Defaults to 0.5.
+	FailureRatio float64
+
+	// SlowCallDuration marks calls taking at least this long as slow; zero
+	// disables slow call detection
+	SlowCallDuration time.Duration
+
+	// SlowCallRatio opens the breaker when this share of calls in a window
+	// are slow. Defaults to 0.5.
+	SlowCallRatio float64
+
+	// OpenTimeout is how long the breaker stays open before letting trial
+	// calls through. Defaults to 30s.
+	OpenTimeout time.Duration
+
+	// HalfOpenRequests is how many trial calls must succeed to close the
+	// breaker again. Defaults to 3.
+	HalfOpenRequests int
+
+	// IsFailure decides which errors count as failures. By default every
+	// error except context cancellation does.
+	IsFailure func(err error) bool
+
+	// OnStateChange is called after every transition
+	OnStateChange func(name string, from, to State)
+}
+
+// Breaker is a circuit breaker. While closed it counts failed and slow
+// calls in fixed windows and opens once either ratio is exceeded. While
+// open it fails fast with ErrBreakerOpen. After OpenTimeout it lets a few
+// trial calls through half-open: one failure opens it again, enough
+// successes close it.
+type Breaker struct {
+	name string
+	opts BreakerOptions
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	calls       int
+	failures    int
+	slowCalls   int
+	openedAt    time.Time
+	trials      int
+	successes   int
+
+	now func() time.Time
+}
+
+// NewBreaker creates a closed breaker
+func NewBreaker(name string, opts BreakerOptions) *Breaker {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	if opts.MinRequests <= 0 {
+		opts.MinRequests = 20
+	}
+	if opts.FailureRatio <= 0 {
+		opts.FailureRatio = 0.5
+	}
+	if opts.SlowCallRatio <= 0 {
+		opts.SlowCallRatio = 0.5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.HalfOpenRequests <= 0 {
+		opts.HalfOpenRequests = 3
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return err != nil && !errors.Is(err, context.Canceled)
+		}
+	}
+
+	b := &Breaker{
+		name: name,
+		opts: opts,
+		now:  time.Now,
+	}
+	b.windowStart = b.now()
+	return b
+}
+
+// Name returns the breaker name
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Execute calls fn unless the breaker is open and records the outcome
+func (b *Breaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	start := b.now()
+	err := fn(ctx)
+	b.record(err, b.now().Sub(start))
+	return err
+}
+
+// allow reports whether a call may proceed, moving an open breaker to
+// half-open once its timeout has passed
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	from := b.state
+	now := b.now()
+
+	switch b.state {
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.opts.Window {
+			b.resetWindow(now)
+		}
+	case StateOpen:
+		if now.Sub(b.openedAt) < b.opts.OpenTimeout {
+			b.mu.Unlock()
+			return ErrBreakerOpen
+		}
+		b.state = StateHalfOpen
+		b.trials, b.successes = 0, 0
+		fallthrough
+	case StateHalfOpen:
+		if b.trials >= b.opts.HalfOpenRequests {
+			b.mu.Unlock()
+			return ErrBreakerOpen
+		}
+		b.trials++
+	}
+
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+	return nil
+}
+
+// record counts the outcome of a call
+func (b *Breaker) record(err error, duration time.Duration) {
+	failed := b.opts.IsFailure(err)
+	slow := b.opts.SlowCallDuration > 0 && duration >= b.opts.SlowCallDuration
+
+	b.mu.Lock()
+	from := b.state
+	now := b.now()
+
+	switch b.state {
+	case StateClosed:
+		b.calls++
+		if failed {
+			b.failures++
+		}
+		if slow {
+			b.slowCalls++
+		}
+		if b.calls >= b.opts.MinRequests &&
+			(ratio(b.failures, b.calls) >= b.opts.FailureRatio || ratio(b.slowCalls, b.calls) >= b.opts.SlowCallRatio) {
+			b.open(now)
+		}
+	case StateHalfOpen:
+		if failed || slow {
+			b.open(now)
+			break
+		}
+		b.successes++
+		if b.successes >= b.opts.HalfOpenRequests {
+			b.state = StateClosed
+			b.resetWindow(now)
+		}
+	}
+
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+// open trips the breaker; callers must hold mu
+func (b *Breaker) open(now time.Time) {
+	b.state = StateOpen
+	b.openedAt = now
+}
+
+// resetWindow starts a new counting window; callers must hold mu
+func (b *Breaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.calls, b.failures, b.slowCalls = 0, 0, 0
+}
+
+func (b *Breaker) notify(from, to State) {
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.name, from, to)
+	}
+}
+
+func ratio(n, total int) float64 {
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,57 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrBulkheadFull is returned when a call could not get a bulkhead slot
+var ErrBulkheadFull = errors.New("resilience: bulkhead is full")
+
+// Bulkhead limits concurrent calls to a dependency so that a slow one
+// cannot tie up every request goroutine
+type Bulkhead struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// NewBulkhead creates a bulkhead allowing maxConcurrent calls at once.
+// Calls wait up to maxWait for a slot; zero rejects them immediately.
+func NewBulkhead(maxConcurrent int, maxWait time.Duration) *Bulkhead {
+	return &Bulkhead{
+		slots:   make(chan struct{}, maxConcurrent),
+		maxWait: maxWait,
+	}
+}
+
+// Execute calls fn once a slot is free
+func (b *Bulkhead) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+	defer func() { <-b.slots }()
+	return fn(ctx)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if b.maxWait <= 0 {
+		return ErrBulkheadFull
+	}
+
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBulkheadFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,73 @@
+package resilience
+
+import "context"
+
+// Policy combines the protections applied to one dependency. Any of them
+// may be nil, as may the policy itself. A call holds one bulkhead slot for
+// all its attempts, and every attempt goes through the breaker.
+type Policy struct {
+	Bulkhead *Bulkhead
+	Retry    *RetryPolicy
+	Breaker  *Breaker
+}
+
+// Execute calls fn under the policy, retrying failures. Use it for
+// idempotent operations.
+func (p *Policy) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p == nil {
+		return fn(ctx)
+	}
+	return p.run(ctx, true, fn)
+}
+
+// ExecuteOnce calls fn under the policy without retrying. Use it for
+// operations that are not safe to repeat.
+func (p *Policy) ExecuteOnce(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p == nil {
+		return fn(ctx)
+	}
+	return p.run(ctx, false, fn)
+}
+
+func (p *Policy) run(ctx context.Context, retry bool, fn func(ctx context.Context) error) error {
+	attempt := fn
+	if p.Breaker != nil {
+		attempt = func(ctx context.Context) error {
+			return p.Breaker.Execute(ctx, fn)
+		}
+	}
+
+	call := attempt
+	if retry && p.Retry != nil {
+		call = func(ctx context.Context) error {
+			return p.Retry.Do(ctx, attempt)
+		}
+	}
+
+	if p.Bulkhead != nil {
+		return p.Bulkhead.Execute(ctx, call)
+	}
+	return call(ctx)
+}
+
+// Do runs fn with Execute and returns its result
+func Do[T any](ctx context.Context, p *Policy, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := p.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
+
+// DoOnce runs fn with ExecuteOnce and returns its result
+func DoOnce[T any](ctx context.Context, p *Policy, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := p.ExecuteOnce(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
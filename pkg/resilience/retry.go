@@ -0,0 +1,152 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// retryBudgetWindow is the period over which a RetryBudget counts
+const retryBudgetWindow = 10 * time.Second
+
+// RetryPolicy retries failed calls with exponential backoff and full
+// jitter. Zero values select the defaults.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call. Defaults to 3.
+	MaxAttempts int
+
+	// BaseDelay is the backoff cap before the first retry; it doubles on
+	// every retry up to MaxDelay. Defaults to 50ms and 1s.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Retryable decides which errors are retried. By default everything
+	// except rejections by a breaker or bulkhead and context errors is.
+	Retryable func(err error) bool
+
+	// Budget, when set, caps retries across all calls sharing it
+	Budget *RetryBudget
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable,
+// runs out of attempts or budget, or ctx is done
+func (p *RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = defaultRetryable
+	}
+	if p.Budget != nil {
+		p.Budget.recordRequest()
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if p.Budget != nil && !p.Budget.allowRetry() {
+				return err
+			}
+			if waitErr := sleep(ctx, p.backoff(attempt)); waitErr != nil {
+				return err
+			}
+		}
+
+		err = fn(ctx)
+		if err == nil || !retryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff returns a random delay of up to BaseDelay*2^(attempt-1), capped
+// at MaxDelay
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = 50 * time.Millisecond
+	}
+	if max <= 0 {
+		max = time.Second
+	}
+
+	ceiling := max
+	if shift := attempt - 1; shift < 32 && base<<shift < max {
+		ceiling = base << shift
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func defaultRetryable(err error) bool {
+	return !errors.Is(err, ErrBreakerOpen) &&
+		!errors.Is(err, ErrBulkheadFull) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryBudget caps retries to a share of requests so that retries cannot
+// multiply load on a dependency that is already struggling. Within each
+// 10s window it allows Ratio retries per request plus MinPerSecond retries
+// per second regardless of traffic.
+type RetryBudget struct {
+	ratio        float64
+	minPerWindow float64
+
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	retries     int
+	now         func() time.Time
+}
+
+// NewRetryBudget creates a retry budget
+func NewRetryBudget(ratio float64, minPerSecond int) *RetryBudget {
+	return &RetryBudget{
+		ratio:        ratio,
+		minPerWindow: float64(minPerSecond) * retryBudgetWindow.Seconds(),
+		now:          time.Now,
+	}
+}
+
+func (b *RetryBudget) recordRequest() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	b.requests++
+}
+
+func (b *RetryBudget) allowRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	if float64(b.retries) >= b.ratio*float64(b.requests)+b.minPerWindow {
+		return false
+	}
+	b.retries++
+	return true
+}
+
+// roll starts a new window when the current one has ended; callers must
+// hold mu
+func (b *RetryBudget) roll() {
+	if now := b.now(); now.Sub(b.windowStart) >= retryBudgetWindow {
+		b.windowStart = now
+		b.requests, b.retries = 0, 0
+	}
+}
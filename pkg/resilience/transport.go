@@ -0,0 +1,112 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StatusError reports a response whose status signals that the server is
+// failing or overloaded
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("resilience: server responded %d", e.StatusCode)
+}
+
+// Transport is an http.RoundTripper that sends requests through a policy.
+// 5xx and 429 responses count as failures. Only idempotent requests whose
+// body can be replayed are retried. When every attempt fails with a
+// status, the last response is returned as is.
+type Transport struct {
+	Base   http.RoundTripper
+	Policy *Policy
+}
+
+// NewClient returns a copy of client, or of http.DefaultClient when client
+// is nil, whose requests go through policy
+func NewClient(client *http.Client, policy *Policy) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	c := *client
+	c.Transport = &Transport{Base: client.Transport, Policy: policy}
+	return &c
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	var resp *http.Response
+	attempts := 0
+	call := func(ctx context.Context) error {
+		// Discard the response of the previous failed attempt
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+
+		attempt := req
+		if attempts > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			attempt = req.Clone(ctx)
+			attempt.Body = body
+		}
+		attempts++
+
+		var err error
+		resp, err = base.RoundTrip(attempt)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+			return &StatusError{StatusCode: resp.StatusCode}
+		}
+		return nil
+	}
+
+	var err error
+	if replayable(req) {
+		err = t.Policy.Execute(req.Context(), call)
+	} else {
+		err = t.Policy.ExecuteOnce(req.Context(), call)
+	}
+
+	if err == nil {
+		return resp, nil
+	}
+
+	var statusErr *StatusError
+	if resp != nil {
+		if errors.As(err, &statusErr) {
+			return resp, nil
+		}
+		// A later attempt was rejected by the breaker or budget
+		resp.Body.Close()
+	}
+	return nil, err
+}
+
+// replayable reports whether sending req again is safe
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		if req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
@@ -0,0 +1,81 @@
+// Package safego starts goroutines whose panics are recovered, logged with
+// their stack and reported, instead of crashing the process.
+package safego
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+
+	"github.com/pipeline-arch/app/pkg/logger"
+)
+
+// Background is the origin of goroutines started outside of a request
+const Background = "background"
+
+// Panic is a recovered panic
+type Panic struct {
+	// Value is the value passed to panic
+	Value interface{}
+	// Stack is the stack of the panicking goroutine
+	Stack []byte
+	// Origin names what started the goroutine, such as the route of the
+	// request that was being served, or Background
+	Origin string
+}
+
+// Error describes the panic without its stack
+func (p *Panic) Error() string {
+	return fmt.Sprintf("panic: %v", p.Value)
+}
+
+// Handler is called with every panic recovered by Go, after it is logged
+type Handler func(ctx context.Context, p *Panic)
+
+var handler atomic.Pointer[Handler]
+
+// SetHandler makes h the Handler of recovered panics
+func SetHandler(h Handler) {
+	handler.Store(&h)
+}
+
+type originKey struct{}
+
+// WithOrigin returns a context whose goroutines started by Go report the
+// origin returned by origin. It is called when each goroutine starts, so
+// it may return something that is only known later, like a route.
+func WithOrigin(ctx context.Context, origin func() string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// Go runs fn in a new goroutine with ctx. A panic in fn is recovered,
+// logged at error level with its stack by the logger of ctx, so with the
+// request and trace IDs of the request that started it, and passed to the
+// Handler. A request's context is cancelled when the request ends; pass
+// context.WithoutCancel(ctx) for work that should outlive it.
+func Go(ctx context.Context, fn func(ctx context.Context)) {
+	origin := Background
+	if f, ok := ctx.Value(originKey{}).(func() string); ok {
+		if o := f(); o != "" {
+			origin = o
+		}
+	}
+
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				p := &Panic{Value: v, Stack: debug.Stack(), Origin: origin}
+				logger.FromContext(ctx).Error().
+					Interface("panic", v).
+					Str("origin", origin).
+					Str("stack", string(p.Stack)).
+					Msg("goroutine panic recovered")
+				if h := handler.Load(); h != nil && *h != nil {
+					(*h)(ctx, p)
+				}
+			}
+		}()
+		fn(ctx)
+	}()
+}
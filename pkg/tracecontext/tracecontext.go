@@ -0,0 +1,158 @@
+// Package tracecontext parses and generates W3C Trace Context headers
+// (https://www.w3.org/TR/trace-context/) and carries them, together with
+// the request ID, in a context.
+package tracecontext
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Header names
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// maxTraceStateLength is the longest tracestate that is propagated; the
+// specification allows dropping longer ones
+const maxTraceStateLength = 512
+
+// ErrInvalidTraceParent is returned for a malformed traceparent header
+var ErrInvalidTraceParent = errors.New("tracecontext: invalid traceparent")
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+// String returns the ID as lowercase hex
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeroes
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// String returns the ID as lowercase hex
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is not all zeroes
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// FlagSampled is the trace flag recording that the caller sampled the trace
+const FlagSampled byte = 0x01
+
+// TraceContext is the position of a request in a distributed trace. SpanID
+// is the span of the current hop; the caller's span is ParentID, which is
+// zero for a new trace.
+type TraceContext struct {
+	TraceID  TraceID
+	SpanID   SpanID
+	ParentID SpanID
+	Flags    byte
+	State    string
+}
+
+// New starts a new trace
+func New() TraceContext {
+	var tc TraceContext
+	rand.Read(tc.TraceID[:])
+	rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// Parse reads the traceparent and tracestate headers of an incoming
+// request and returns the context of a new span below the caller's.
+// tracestate is only kept along with a valid traceparent.
+func Parse(traceparent, tracestate string) (TraceContext, error) {
+	var tc TraceContext
+	traceparent = strings.TrimSpace(traceparent)
+
+	// version-traceid-parentid-flags; later versions may append fields
+	if len(traceparent) < 55 || (len(traceparent) > 55 && traceparent[55] != '-') {
+		return tc, ErrInvalidTraceParent
+	}
+	version, err := decodeHex(traceparent[0:2], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(traceparent) != 55) {
+		return tc, ErrInvalidTraceParent
+	}
+	if traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' {
+		return tc, ErrInvalidTraceParent
+	}
+
+	traceID, err := decodeHex(traceparent[3:35], 16)
+	if err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	parentID, err := decodeHex(traceparent[36:52], 8)
+	if err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+	flags, err := decodeHex(traceparent[53:55], 1)
+	if err != nil {
+		return tc, ErrInvalidTraceParent
+	}
+
+	copy(tc.TraceID[:], traceID)
+	copy(tc.ParentID[:], parentID)
+	if !tc.TraceID.IsValid() || !tc.ParentID.IsValid() {
+		return TraceContext{}, ErrInvalidTraceParent
+	}
+	tc.Flags = flags[0] & FlagSampled
+	rand.Read(tc.SpanID[:])
+
+	if tracestate = strings.TrimSpace(tracestate); len(tracestate) <= maxTraceStateLength {
+		tc.State = tracestate
+	}
+	return tc, nil
+}
+
+func decodeHex(s string, n int) ([]byte, error) {
+	if s != strings.ToLower(s) {
+		return nil, ErrInvalidTraceParent
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != n {
+		return nil, ErrInvalidTraceParent
+	}
+	return b, nil
+}
+
+// Sampled reports whether the trace is sampled
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&FlagSampled != 0
+}
+
+// TraceParent formats the traceparent header for the current span, as
+// sent to downstream services and echoed to callers
+func (tc TraceContext) TraceParent() string {
+	return "00-" + tc.TraceID.String() + "-" + tc.SpanID.String() + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+type traceContextKey struct{}
+type requestIDKey struct{}
+
+// WithTraceContext returns a copy of ctx carrying tc
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// FromContext returns the trace context carried by ctx
+func FromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
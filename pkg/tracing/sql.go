@@ -0,0 +1,62 @@
+package tracing
+
+import "strings"
+
+// RedactSQL prepares a SQL statement for the db.statement span attribute.
+// String and numeric literals are replaced with ?, so that values written
+// into a statement never reach the trace backend, and runs of whitespace
+// are collapsed. Placeholders such as $1 are kept.
+func RedactSQL(statement string) string {
+	var b strings.Builder
+	b.Grow(len(statement))
+
+	space := false
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		case space && b.Len() > 0:
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'':
+			// Skip to the closing quote; '' is an escaped quote
+			for i++; i < len(statement); i++ {
+				if statement[i] == '\'' {
+					if i+1 < len(statement) && statement[i+1] == '\'' {
+						i++
+						continue
+					}
+					break
+				}
+			}
+			b.WriteByte('?')
+		case isDigit(c) && !continuesWord(statement, i):
+			for i+1 < len(statement) && (isDigit(statement[i+1]) || statement[i+1] == '.') {
+				i++
+			}
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// continuesWord reports whether the digit at i is part of an identifier
+// or a $n placeholder rather than a literal
+func continuesWord(s string, i int) bool {
+	if i == 0 {
+		return false
+	}
+	c := s[i-1]
+	return c == '$' || c == '_' || isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
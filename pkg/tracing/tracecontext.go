@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/pipeline-arch/app/pkg/tracecontext"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartRequest starts the server span of a request continuing the
+// tracecontext.TraceContext carried by ctx: the span is a child of the
+// caller's span, or the root of the trace the request started. The trace
+// context in the returned ctx is updated with the span's sampling
+// decision. With the provider installed by Setup the span takes the IDs
+// of the trace context, so those already logged stay valid; other
+// providers may choose different IDs, which replace them.
+func StartRequest(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	tc, ok := tracecontext.FromContext(ctx)
+	if !ok {
+		return Start(ctx, name, opts...)
+	}
+
+	if tc.ParentID.IsValid() {
+		state, _ := trace.ParseTraceState(tc.State)
+		ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID(tc.TraceID),
+			SpanID:     trace.SpanID(tc.ParentID),
+			TraceFlags: trace.TraceFlags(tc.Flags),
+			TraceState: state,
+			Remote:     true,
+		}))
+	}
+
+	// While tracing is off the span is the caller's, which must not
+	// replace the request's own span
+	ctx, span := Start(ctx, name, opts...)
+	if sc := span.SpanContext(); sc.IsValid() && !sc.IsRemote() {
+		tc.TraceID = tracecontext.TraceID(sc.TraceID())
+		tc.SpanID = tracecontext.SpanID(sc.SpanID())
+		tc.Flags = byte(sc.TraceFlags()) & tracecontext.FlagSampled
+		ctx = tracecontext.WithTraceContext(ctx, tc)
+	}
+	return ctx, span
+}
+
+// idGenerator gives the server span of a request the trace and span IDs
+// that RequestContext chose for it, and random IDs to every other span
+type idGenerator struct{}
+
+// NewIDs returns the IDs of a root span
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if tc, ok := tracecontext.FromContext(ctx); ok && !tc.ParentID.IsValid() && !trace.SpanContextFromContext(ctx).IsValid() {
+		return trace.TraceID(tc.TraceID), trace.SpanID(tc.SpanID)
+	}
+	tc := tracecontext.New()
+	return trace.TraceID(tc.TraceID), trace.SpanID(tc.SpanID)
+}
+
+// NewSpanID returns the ID of a span below the span carried by ctx
+func (idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	if tc, ok := tracecontext.FromContext(ctx); ok && tc.ParentID.IsValid() && trace.TraceID(tc.TraceID) == traceID {
+		if parent := trace.SpanContextFromContext(ctx); parent.IsRemote() && parent.SpanID() == trace.SpanID(tc.ParentID) {
+			return trace.SpanID(tc.SpanID)
+		}
+	}
+	return trace.SpanID(tracecontext.New().SpanID)
+}
@@ -0,0 +1,84 @@
+// Package tracing records OpenTelemetry spans and exports them over
+// OTLP/HTTP. Spans continue the W3C trace context that pkg/tracecontext
+// keeps for each request, so the trace and span IDs in logs and response
+// headers are those of the exported spans.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the instrumentation scope of the application's spans
+const instrumentationName = "github.com/pipeline-arch/app"
+
+// Options configures Setup
+type Options struct {
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+
+	// Endpoint is the base URL of an OTLP/HTTP receiver, such as
+	// http://otel-collector:4318; spans are posted to Endpoint/v1/traces
+	Endpoint string
+
+	// SampleRatio is the share of new traces that are sampled, from 0 to
+	// 1. Traces continued from a caller follow the caller's decision.
+	SampleRatio float64
+}
+
+// Setup installs a global tracer provider that exports spans to
+// opts.Endpoint in batches, and returns a function that flushes pending
+// spans and stops it. Without an endpoint tracing stays off: spans are
+// not recorded and shutdown does nothing.
+func Setup(ctx context.Context, opts Options) (shutdown func(context.Context) error, err error) {
+	if opts.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if opts.SampleRatio < 0 || opts.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing: sample ratio %v is not between 0 and 1", opts.SampleRatio)
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("tracing: invalid OTLP endpoint %q", opts.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/v1/traces"
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint.String()))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: creating OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+		sdktrace.WithIDGenerator(idGenerator{}),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", opts.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span below the span carried by ctx with the global tracer
+// provider
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records err, if any, on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,276 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyService(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New("debug").Logger
+	admin := &auth.Principal{Subject: "admin-1", Type: models.OwnerTypeUser, Scopes: []string{models.ScopeAdmin}}
+
+	t.Run("CreateAndAuthenticate", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:      "ci-deploy",
+			OwnerID:   "github-actions",
+			OwnerType: models.OwnerTypeServiceAccount,
+			Scopes:    []string{models.ScopeUsersRead},
+		})
+		require.NoError(t, err)
+		assert.True(t, auth.IsAPIKey(created.Key))
+		assert.Contains(t, created.Key, created.Prefix)
+
+		principal, err := svc.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+		assert.Equal(t, "github-actions", principal.Subject)
+		assert.Equal(t, models.OwnerTypeServiceAccount, principal.Type)
+		assert.True(t, principal.HasScope(models.ScopeUsersRead))
+		assert.False(t, principal.HasScope(models.ScopeUsersWrite))
+
+		key, err := svc.GetKey(ctx, admin, created.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, key.LastUsedAt)
+	})
+
+	t.Run("RejectsWrongSecret", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:   "tampered",
+			Scopes: []string{models.ScopeUsersRead},
+		})
+		require.NoError(t, err)
+
+		_, err = svc.Authenticate(ctx, created.Prefix+"_not-the-secret")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Invalid API key")
+	})
+
+	t.Run("CannotEscalateScopes", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+		caller := &auth.Principal{Subject: "user-1", Type: models.OwnerTypeUser, Scopes: []string{models.ScopeUsersRead}}
+
+		_, err := svc.CreateKey(ctx, caller, &models.APIKeyCreateRequest{
+			Name:   "escalate",
+			Scopes: []string{models.ScopeUsersWrite},
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("RevokedKeyIsRejected", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:   "revoke-me",
+			Scopes: []string{models.ScopeUsersRead},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, svc.RevokeKey(ctx, admin, created.ID))
+
+		_, err = svc.Authenticate(ctx, created.Key)
+		require.Error(t, err)
+	})
+
+	t.Run("RotateKeepsOldKeyDuringOverlap", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:   "rotate-me",
+			Scopes: []string{models.ScopeUsersRead},
+		})
+		require.NoError(t, err)
+
+		rotated, err := svc.RotateKey(ctx, admin, created.ID, time.Hour)
+		require.NoError(t, err)
+		assert.NotEqual(t, created.Key, rotated.Key)
+
+		_, err = svc.Authenticate(ctx, created.Key)
+		require.NoError(t, err, "old key should work during the overlap window")
+		_, err = svc.Authenticate(ctx, rotated.Key)
+		require.NoError(t, err)
+
+		old, err := svc.GetKey(ctx, admin, created.ID)
+		require.NoError(t, err)
+		assert.Equal(t, rotated.ID, old.ReplacedBy)
+		require.NotNil(t, old.ExpiresAt)
+
+		// A zero overlap retires the old key immediately
+		again, err := svc.RotateKey(ctx, admin, rotated.ID, 0)
+		require.NoError(t, err)
+		_, err = svc.Authenticate(ctx, rotated.Key)
+		require.Error(t, err)
+		_, err = svc.Authenticate(ctx, again.Key)
+		require.NoError(t, err)
+	})
+
+	t.Run("RotateKeepsExpiry", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+
+		expiresAt := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:      "expiring",
+			Scopes:    []string{models.ScopeUsersRead},
+			ExpiresAt: &expiresAt,
+		})
+		require.NoError(t, err)
+
+		rotated, err := svc.RotateKey(ctx, admin, created.ID, 0)
+		require.NoError(t, err)
+		key, err := svc.GetKey(ctx, admin, rotated.ID)
+		require.NoError(t, err)
+		require.NotNil(t, key.ExpiresAt)
+		assert.True(t, key.ExpiresAt.Equal(expiresAt), "rotation must not extend the key's lifetime")
+	})
+
+	t.Run("RateLimitQuotas", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+		caller := &auth.Principal{Subject: "user-1", Type: models.OwnerTypeUser, Scopes: []string{models.ScopeUsersRead}}
+
+		_, err := svc.CreateKey(ctx, caller, &models.APIKeyCreateRequest{
+			Name:      "greedy",
+			Scopes:    []string{models.ScopeUsersRead},
+			RateLimit: 10000,
+		})
+		require.Error(t, err, "only admins set quotas")
+
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:      "batch-import",
+			OwnerID:   "importer",
+			OwnerType: models.OwnerTypeServiceAccount,
+			Scopes:    []string{models.ScopeUsersRead},
+			RateLimit: 600,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 600, created.RateLimit)
+
+		principal, err := svc.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+		assert.Equal(t, 600, principal.RateLimit)
+
+		rotated, err := svc.RotateKey(ctx, admin, created.ID, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 600, rotated.RateLimit)
+
+		negative := -1
+		_, err = svc.UpdateKey(ctx, admin, rotated.ID, &models.APIKeyUpdateRequest{RateLimit: &negative})
+		require.Error(t, err)
+
+		reset := 0
+		updated, err := svc.UpdateKey(ctx, admin, rotated.ID, &models.APIKeyUpdateRequest{RateLimit: &reset})
+		require.NoError(t, err)
+		assert.Zero(t, updated.RateLimit)
+	})
+
+	t.Run("InactiveOwnersKeysAreRejected", func(t *testing.T) {
+		users := repository.NewInMemoryUserRepository()
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+		svc.SetOwners(users)
+
+		owner := models.NewUser("owner@example.com", "Key Owner", "user")
+		require.NoError(t, users.Create(ctx, owner))
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:      "owned",
+			OwnerID:   owner.ID,
+			OwnerType: models.OwnerTypeUser,
+			Scopes:    []string{models.ScopeUsersRead},
+		})
+		require.NoError(t, err)
+		_, err = svc.Authenticate(ctx, created.Key)
+		require.NoError(t, err)
+
+		owner.Active = false
+		require.NoError(t, users.Update(ctx, owner))
+		_, err = svc.Authenticate(ctx, created.Key)
+		require.Error(t, err, "keys of deactivated users must stop working")
+
+		require.NoError(t, users.Delete(ctx, owner.ID))
+		_, err = svc.Authenticate(ctx, created.Key)
+		require.Error(t, err, "keys of deleted users must stop working")
+	})
+
+	t.Run("OtherOwnersKeysAreHidden", func(t *testing.T) {
+		svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+
+		created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+			Name:   "admin-key",
+			Scopes: []string{models.ScopeUsersRead},
+		})
+		require.NoError(t, err)
+
+		other := &auth.Principal{Subject: "user-2", Scopes: []string{models.ScopeAPIKeysManage}}
+		_, err = svc.GetKey(ctx, other, created.ID)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not found")
+	})
+}
+
+func TestAuthenticateMiddleware(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New("debug").Logger
+	svc := services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil)
+	admin := &auth.Principal{Subject: "admin-1", Scopes: []string{models.ScopeAdmin}}
+
+	created, err := svc.CreateKey(ctx, admin, &models.APIKeyCreateRequest{
+		Name:   "middleware",
+		Scopes: []string{models.ScopeUsersRead},
+	})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Use(api.Authenticate(svc, nil, log))
+	router.With(api.RequireScope(models.ScopeUsersRead)).Get("/read", func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		w.Write([]byte(principal.Subject))
+	})
+	router.With(api.RequireScope(models.ScopeUsersWrite)).Get("/write", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		status int
+	}{
+		{"BearerKey", "/read", "Authorization", "Bearer " + created.Key, http.StatusOK},
+		{"HeaderKey", "/read", api.APIKeyHeader, created.Key, http.StatusOK},
+		{"Anonymous", "/read", "", "", http.StatusUnauthorized},
+		{"InvalidKey", "/read", api.APIKeyHeader, "pk_invalid0_secret", http.StatusUnauthorized},
+		{"MissingScope", "/write", api.APIKeyHeader, created.Key, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.status == http.StatusOK && tt.path == "/read" {
+				assert.Equal(t, "admin-1", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAuthService(t *testing.T) (*services.AuthService, *repository.InMemoryUserRepository) {
+	t.Helper()
+	log := logger.New("debug").Logger
+	users := repository.NewInMemoryUserRepository()
+
+	_, err := services.NewUserService(users, log, nil).CreateUser(context.Background(), &models.UserCreateRequest{
+		Email:    "login@example.com",
+		Name:     "Login User",
+		Role:     "user",
+		Password: "correct horse battery staple",
+	})
+	require.NoError(t, err)
+
+	svc := services.NewAuthService(
+		users,
+		repository.NewInMemoryRefreshTokenRepository(),
+		auth.NewHS256Signer([]byte("test-secret")),
+		services.AuthOptions{
+			Issuer:          "pipeline-arch-test",
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: time.Hour,
+		},
+		log,
+		nil,
+	)
+	return svc, users
+}
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := auth.HashPassword("s3cret-passphrase")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$"))
+
+	assert.NoError(t, auth.VerifyPassword("s3cret-passphrase", hash))
+	assert.ErrorIs(t, auth.VerifyPassword("wrong-passphrase", hash), auth.ErrPasswordMismatch)
+
+	// bcrypt hash of "password"
+	bcryptHash := "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	assert.Error(t, auth.VerifyPassword("not-password", bcryptHash))
+}
+
+func TestHS256Signer(t *testing.T) {
+	signer := auth.NewHS256Signer([]byte("secret"))
+	now := time.Now()
+
+	token, err := signer.Sign(&auth.Claims{
+		Subject:   "user-1",
+		ID:        "jti-1",
+		IssuedAt:  auth.NumericDate(now),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+		Scope:     "users:read users:write",
+	})
+	require.NoError(t, err)
+
+	claims, err := signer.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, []string{"users:read", "users:write"}, claims.Scopes())
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		_, err := auth.NewHS256Signer([]byte("other")).Verify(token)
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		_, err := signer.Verify(parts[0] + "." + parts[1] + "x." + parts[2])
+		assert.Error(t, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired, err := signer.Sign(&auth.Claims{
+			Subject:   "user-1",
+			ID:        "jti-2",
+			ExpiresAt: now.Add(-time.Hour).Unix(),
+		})
+		require.NoError(t, err)
+		_, err = signer.Verify(expired)
+		assert.ErrorIs(t, err, auth.ErrTokenExpired)
+	})
+}
+
+func TestAuthService(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("LoginAndVerify", func(t *testing.T) {
+		svc, _ := setupAuthService(t)
+
+		tokens, err := svc.Login(ctx, "login@example.com", "correct horse battery staple")
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer", tokens.TokenType)
+		assert.Equal(t, 900, tokens.ExpiresIn)
+		assert.True(t, strings.HasPrefix(tokens.RefreshToken, auth.RefreshTokenPrefix))
+
+		principal, err := svc.VerifyAccessToken(ctx, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "user", principal.Role)
+		assert.True(t, principal.HasScope(models.ScopeUsersRead))
+		assert.False(t, principal.HasScope(models.ScopeAdmin))
+	})
+
+	t.Run("LoginWrongPassword", func(t *testing.T) {
+		svc, _ := setupAuthService(t)
+
+		_, err := svc.Login(ctx, "login@example.com", "wrong")
+		require.Error(t, err)
+		_, err = svc.Login(ctx, "nobody@example.com", "wrong")
+		require.Error(t, err)
+	})
+
+	t.Run("RefreshRotatesAndDetectsReuse", func(t *testing.T) {
+		svc, _ := setupAuthService(t)
+
+		first, err := svc.Login(ctx, "login@example.com", "correct horse battery staple")
+		require.NoError(t, err)
+
+		second, err := svc.Refresh(ctx, first.RefreshToken)
+		require.NoError(t, err)
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+		// Replaying the rotated token revokes the whole family
+		_, err = svc.Refresh(ctx, first.RefreshToken)
+		require.Error(t, err)
+		_, err = svc.Refresh(ctx, second.RefreshToken)
+		require.Error(t, err, "descendant tokens must be revoked after reuse")
+	})
+
+	t.Run("LogoutRevokesFamily", func(t *testing.T) {
+		svc, _ := setupAuthService(t)
+
+		first, err := svc.Login(ctx, "login@example.com", "correct horse battery staple")
+		require.NoError(t, err)
+		second, err := svc.Refresh(ctx, first.RefreshToken)
+		require.NoError(t, err)
+
+		require.NoError(t, svc.Logout(ctx, second.RefreshToken))
+		_, err = svc.Refresh(ctx, second.RefreshToken)
+		require.Error(t, err)
+	})
+
+	t.Run("InactiveUserCannotRefresh", func(t *testing.T) {
+		svc, users := setupAuthService(t)
+
+		tokens, err := svc.Login(ctx, "login@example.com", "correct horse battery staple")
+		require.NoError(t, err)
+
+		user, err := users.GetByEmail(ctx, "login@example.com")
+		require.NoError(t, err)
+		user.Active = false
+		require.NoError(t, users.Update(ctx, user))
+
+		_, err = svc.Refresh(ctx, tokens.RefreshToken)
+		require.Error(t, err)
+	})
+}
+
+func TestTokenEndpoint(t *testing.T) {
+	svc, _ := setupAuthService(t)
+	log := logger.New("debug").Logger
+	sessions := services.NewSessionService(repository.NewInMemoryRevocationStore(), repository.NewInMemoryRefreshTokenRepository(), 15*time.Minute, log, nil)
+	handlers := api.NewAuthHandlers(svc, sessions, log)
+
+	router := chi.NewRouter()
+	router.Post("/auth/token", handlers.Token)
+	router.Group(func(r chi.Router) {
+		r.Use(api.Authenticate(services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil), svc, log))
+		r.With(api.RequireAuth).Get("/me", func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := auth.PrincipalFromContext(r.Context())
+			w.Write([]byte(principal.Method))
+		})
+	})
+
+	form := "grant_type=password&username=login%40example.com&password=correct+horse+battery+staple"
+	req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), "access_token")
+
+	body := `{"grant_type":"password","email":"login@example.com","password":"nope"}`
+	req = httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	tokens, err := svc.Login(context.Background(), "login@example.com", "correct horse battery staple")
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, auth.MethodJWT, w.Body.String())
+}
@@ -0,0 +1,251 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalGet(t *testing.T) {
+	_, router := setupTestHandler()
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/api/v1/users/123", "/api/v1/users"} {
+		t.Run(path, func(t *testing.T) {
+			rec := get(path, nil)
+			require.Equal(t, http.StatusOK, rec.Code)
+			etag := rec.Header().Get("ETag")
+			require.NotEmpty(t, etag)
+			assert.NotContains(t, etag, "W/", "ETags are strong")
+			assert.NotEmpty(t, rec.Header().Get(api.SurrogateKeyHeader))
+
+			rec = get(path, http.Header{"If-None-Match": {`"other", ` + etag}})
+			assert.Equal(t, http.StatusNotModified, rec.Code)
+			assert.Empty(t, rec.Body.String())
+			assert.Equal(t, etag, rec.Header().Get("ETag"))
+
+			rec = get(path, http.Header{"If-None-Match": {`"other"`}})
+			assert.Equal(t, http.StatusOK, rec.Code)
+		})
+	}
+
+	assert.NotEqual(t, get("/api/v1/users/1", nil).Header().Get("ETag"), get("/api/v1/users/2", nil).Header().Get("ETag"))
+
+	t.Run("updates change the ETag", func(t *testing.T) {
+		etag := get("/api/v1/users/123", nil).Header().Get("ETag")
+
+		req := asCaller(httptest.NewRequest(http.MethodPut, "/api/v1/users/123", strings.NewReader(`{"name":"Renamed User"}`)), testAdmin)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		rec = get("/api/v1/users/123", http.Header{"If-None-Match": {etag}})
+		assert.Equal(t, http.StatusOK, rec.Code, "the old ETag no longer matches")
+		assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+		assert.Contains(t, rec.Body.String(), "Renamed User")
+	})
+}
+
+func TestUserETag(t *testing.T) {
+	updated := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	user := &models.UserResponse{ID: "1", UpdatedAt: updated}
+	etag := user.ETag()
+	assert.Equal(t, etag, (&models.UserResponse{ID: "1", UpdatedAt: updated}).ETag())
+
+	user.UpdatedAt = updated.Add(time.Microsecond)
+	assert.NotEqual(t, etag, user.ETag())
+
+	list := &models.UserListResponse{Users: []*models.UserResponse{user}, Total: 1, Page: 1, PageSize: 10}
+	listETag := list.ETag()
+	assert.Equal(t, user.UpdatedAt, list.LastModified())
+	list.Total = 2
+	assert.NotEqual(t, listETag, list.ETag(), "added users change the ETag")
+}
+
+func TestCacheControl(t *testing.T) {
+	routes, err := api.ParseRouteCacheControl("/api/v1/users=private, no-cache; /api/v1/users/export=no-store")
+	require.NoError(t, err)
+	assert.Equal(t, []api.RouteCacheControl{
+		{Prefix: "/api/v1/users", CacheControl: "private, no-cache"},
+		{Prefix: "/api/v1/users/export", CacheControl: "no-store"},
+	}, routes)
+
+	_, err = api.ParseRouteCacheControl("api/v1/users=no-store")
+	assert.Error(t, err)
+	_, err = api.ParseRouteCacheControl("/api/v1/users=")
+	assert.Error(t, err)
+
+	handler := api.CacheControl(routes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, path string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Header().Get("Cache-Control")
+	}
+	assert.Equal(t, "private, no-cache", serve(http.MethodGet, "/api/v1/users/1"))
+	assert.Equal(t, "no-store", serve(http.MethodGet, "/api/v1/users/export"))
+	assert.Empty(t, serve(http.MethodPost, "/api/v1/users"))
+	assert.Empty(t, serve(http.MethodGet, "/healthz"))
+}
+
+func TestResponseCache(t *testing.T) {
+	log := logger.New("debug").Logger
+	store := repository.NewInMemoryResponseCacheStore()
+	var lookups []string
+	calls := 0
+	lastModified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	r := chi.NewRouter()
+	r.Use(api.ResponseCache(store, time.Minute, log, func(result string) { lookups = append(lookups, result) }))
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Header().Set("X-Request-ID", "req-1")
+		w.Header().Set(api.SurrogateKeyHeader, models.UserSurrogateKey(chi.URLParam(r, "id")))
+		w.Write([]byte(`{"id":"` + chi.URLParam(r, "id") + `"}`))
+	})
+	r.Get("/uncached", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("no surrogate keys"))
+	})
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/users/1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = get("/users/1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"id":"1"}`, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Header().Get("X-Request-ID"), "per-request headers are not cached")
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, []string{"miss", "hit"}, lookups)
+
+	t.Run("honours conditional requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotModified, get("/users/1", http.Header{"If-None-Match": {`"v1"`}}).Code)
+		assert.Equal(t, http.StatusNotModified, get("/users/1", http.Header{"If-Modified-Since": {lastModified.Format(http.TimeFormat)}}).Code)
+		assert.Equal(t, http.StatusOK, get("/users/1", http.Header{"If-Modified-Since": {lastModified.Add(-time.Second).Format(http.TimeFormat)}}).Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("purges by surrogate key", func(t *testing.T) {
+		get("/users/2", nil)
+		calls = 0
+		require.NoError(t, store.Purge(context.Background(), models.UserSurrogateKey("1")))
+		get("/users/1", nil)
+		get("/users/2", nil)
+		assert.Equal(t, 1, calls, "only the purged user is served again")
+	})
+
+	t.Run("skips responses without surrogate keys", func(t *testing.T) {
+		calls = 0
+		get("/uncached", nil)
+		get("/uncached", nil)
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestUserServicePurgesCache(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New("debug").Logger
+	store := repository.NewInMemoryResponseCacheStore()
+	svc := services.NewUserService(repository.NewInMemoryUserRepository(), log, nil)
+	svc.SetCachePurger(store)
+
+	cache := func(key string, tags ...string) {
+		require.NoError(t, store.Set(ctx, key, &models.CachedResponse{StatusCode: http.StatusOK, Keys: tags}, time.Minute))
+	}
+	cached := func(key string) bool {
+		resp, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		return resp != nil
+	}
+
+	cache("/api/v1/users", models.UsersSurrogateKey)
+	user, err := svc.CreateUser(ctx, &models.UserCreateRequest{Email: "cache@example.com", Name: "Cache User", Role: "user"})
+	require.NoError(t, err)
+	assert.False(t, cached("/api/v1/users"), "creating a user purges lists")
+
+	cache("/api/v1/users", models.UsersSurrogateKey, models.UserSurrogateKey(user.ID))
+	cache("/api/v1/users/"+user.ID, models.UserSurrogateKey(user.ID))
+	cache("/api/v1/users/other", models.UserSurrogateKey("other"))
+	name := "Renamed User"
+	_, err = svc.UpdateUser(ctx, user.ID, &models.UserUpdateRequest{Name: &name})
+	require.NoError(t, err)
+	assert.False(t, cached("/api/v1/users/"+user.ID))
+	assert.False(t, cached("/api/v1/users"), "lists showing the user are purged")
+	assert.True(t, cached("/api/v1/users/other"))
+
+	cache("/api/v1/users/"+user.ID, models.UserSurrogateKey(user.ID))
+	cache("/api/v1/users?page=2", models.UsersSurrogateKey)
+	require.NoError(t, svc.DeleteUser(ctx, user.ID))
+	assert.False(t, cached("/api/v1/users/"+user.ID))
+	assert.False(t, cached("/api/v1/users?page=2"))
+}
+
+func TestUserHandlersServeFreshResponses(t *testing.T) {
+	log := logger.New("debug").Logger
+	store := repository.NewInMemoryResponseCacheStore()
+	svc := services.NewUserService(repository.NewInMemoryUserRepository(), log, nil)
+	svc.SetCachePurger(store)
+	h := api.NewUserHandlers(svc, log)
+
+	r := chi.NewRouter()
+	r.Route("/api/v1/users", func(r chi.Router) {
+		r.Use(api.ResponseCache(store, time.Minute, log, nil))
+		r.Post("/", h.CreateUser)
+		r.Get("/{id}", h.GetUser)
+		r.Put("/{id}", h.UpdateUser)
+	})
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, asCaller(httptest.NewRequest(method, path, strings.NewReader(body)), testAdmin))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/api/v1/users", `{"email":"fresh@example.com","name":"Fresh User","role":"user"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var user models.UserResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+
+	path := "/api/v1/users/" + user.ID
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, path, "").Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, path, `{"name":"Renamed User"}`).Code)
+
+	rec = serve(http.MethodGet, path, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Renamed User", "the update purged the cached response")
+}
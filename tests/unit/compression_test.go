@@ -0,0 +1,126 @@
+package unit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decompress(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(body)
+		require.NoError(t, err)
+		r = gr
+	case "br":
+		r = brotli.NewReader(body)
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	default:
+		r = body
+	}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat(`{"name":"pipeline"}`, 200)
+	handler := api.Compression(api.CompressionOptions{MinSize: 256})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		case "/encoded":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(large))
+		case "/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: one\n\n"))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("data: two\n\n"))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(large[:100]))
+			w.Write([]byte(large[100:]))
+		}
+	}))
+
+	get := func(path, acceptEncoding string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("negotiates encoding", func(t *testing.T) {
+		cases := map[string]string{
+			"gzip":                    "gzip",
+			"gzip, deflate, br":       "br",
+			"gzip, deflate, br, zstd": "zstd",
+			"br;q=0.5, gzip;q=0.8":    "gzip",
+			"*":                       "zstd",
+			"*, zstd;q=0":             "br",
+			"identity":                "",
+			"gzip;q=0":                "",
+		}
+		for accept, want := range cases {
+			rec := get("/", accept)
+			require.Equal(t, http.StatusOK, rec.Code, accept)
+			assert.Equal(t, want, rec.Header().Get("Content-Encoding"), accept)
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"), accept)
+			assert.Equal(t, large, decompress(t, want, rec.Body), accept)
+		}
+	})
+
+	t.Run("weakens etags of compressed responses", func(t *testing.T) {
+		assert.Equal(t, `W/"v1"`, get("/", "gzip").Header().Get("ETag"))
+		assert.Equal(t, `"v1"`, get("/", "").Header().Get("ETag"))
+	})
+
+	t.Run("skips small, incompressible and encoded responses", func(t *testing.T) {
+		for _, path := range []string{"/small", "/image", "/encoded"} {
+			rec := get(path, "br")
+			assert.NotEqual(t, "br", rec.Header().Get("Content-Encoding"), path)
+		}
+		assert.Equal(t, `{}`, get("/small", "br").Body.String())
+	})
+
+	t.Run("skips range requests", func(t *testing.T) {
+		rec := get("/", "gzip", "Range", "bytes=0-10")
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, large, rec.Body.String())
+	})
+
+	t.Run("flushes streaming responses", func(t *testing.T) {
+		rec := get("/stream", "gzip")
+		assert.True(t, rec.Flushed)
+		assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "data: one\n\ndata: two\n\n", decompress(t, "gzip", rec.Body))
+	})
+}
@@ -0,0 +1,161 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupCORS(t *testing.T, policies ...api.CORSPolicy) http.Handler {
+	log := logger.New("debug").Logger
+	cors, err := api.CORS(policies, log)
+	require.NoError(t, err)
+	return cors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestCORSValidation(t *testing.T) {
+	err := api.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Validate()
+	assert.Error(t, err)
+
+	assert.NoError(t, api.CORSPolicy{AllowedOrigins: []string{"*"}}.Validate())
+	assert.NoError(t, api.CORSPolicy{
+		AllowedOrigins:   []string{"https://example.com", "https://*.example.com", "http://localhost:3000"},
+		AllowCredentials: true,
+	}.Validate())
+
+	for _, origin := range []string{"example.com", "https://example.com/", "https://*example.com", "https://api.*.example.com", "ftp://example.com"} {
+		assert.Error(t, api.CORSPolicy{AllowedOrigins: []string{origin}}.Validate(), origin)
+	}
+
+	log := logger.New("debug").Logger
+	_, err = api.CORS([]api.CORSPolicy{{AllowedOrigins: []string{"*"}, AllowCredentials: true}}, log)
+	assert.Error(t, err)
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := setupCORS(t, api.CORSPolicy{
+		AllowedOrigins:   []string{"https://example.com", "https://*.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           5 * time.Minute,
+	})
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/users", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := preflight("https://app.example.com", "POST", "content-type, authorization")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://app.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "POST", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, authorization", rec.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "300", rec.Header().Get("Access-Control-Max-Age"))
+	assert.Contains(t, rec.Header().Values("Vary"), "Origin")
+
+	rejected := map[string]*httptest.ResponseRecorder{
+		"unknown origin":       preflight("https://evil.com", "GET", ""),
+		"suffix lookalike":     preflight("https://evilexample.com", "GET", ""),
+		"bare wildcard domain": preflight("https://.example.com", "GET", ""),
+		"other port":           preflight("https://app.example.com:8443", "GET", ""),
+		"method":               preflight("https://example.com", "DELETE", ""),
+		"header":               preflight("https://example.com", "GET", "X-Custom"),
+	}
+	for name, rec := range rejected {
+		assert.Equal(t, http.StatusNoContent, rec.Code, name)
+		assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"), name)
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler := setupCORS(t, api.CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{"X-Request-ID"},
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	req.Header.Set("Origin", "https://anywhere.test")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "X-Request-ID", rec.Header().Get("Access-Control-Expose-Headers"))
+
+	// Requests without an Origin are not CORS requests
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSRoutePolicies(t *testing.T) {
+	base := api.CORSPolicy{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET"},
+	}
+	routes, err := api.ParseCORSRouteOrigins("/api/v1/admin=https://admin.example.com; /api/v1/internal=", base)
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+
+	_, err = api.ParseCORSRouteOrigins("admin=https://admin.example.com", base)
+	assert.Error(t, err)
+
+	handler := setupCORS(t, append([]api.CORSPolicy{base}, routes...)...)
+
+	allowed := func(path, origin string) bool {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get("Access-Control-Allow-Origin") == origin
+	}
+
+	assert.True(t, allowed("/api/v1/users", "https://example.com"))
+	assert.False(t, allowed("/api/v1/users", "https://admin.example.com"))
+	assert.True(t, allowed("/api/v1/admin/signing-keys", "https://admin.example.com"))
+	assert.False(t, allowed("/api/v1/admin/signing-keys", "https://example.com"))
+	assert.False(t, allowed("/api/v1/internal/stats", "https://example.com"))
+	assert.True(t, allowed("/api/v1/administrators", "https://example.com"))
+}
+
+func TestCORSUpdate(t *testing.T) {
+	log := logger.New("debug").Logger
+	cors, err := api.NewCORSMiddleware([]api.CORSPolicy{{AllowedOrigins: []string{"https://example.com"}}}, log)
+	require.NoError(t, err)
+	handler := cors.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	allowed := func(origin string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get("Access-Control-Allow-Origin") == origin
+	}
+
+	assert.True(t, allowed("https://example.com"))
+	require.NoError(t, cors.Update([]api.CORSPolicy{{AllowedOrigins: []string{"https://app.example.com"}}}))
+	assert.False(t, allowed("https://example.com"))
+	assert.True(t, allowed("https://app.example.com"))
+
+	assert.Error(t, cors.Update([]api.CORSPolicy{{AllowedOrigins: []string{"*"}, AllowCredentials: true}}))
+	assert.True(t, allowed("https://app.example.com"), "invalid policies leave the current ones in place")
+}
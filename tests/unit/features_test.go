@@ -0,0 +1,186 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/features"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureTargeting(t *testing.T) {
+	const beta features.Name = "beta_dashboard"
+	flags := features.NewSet([]features.Flag{
+		{Name: features.RateLimiting, Enabled: true},
+		{Name: beta, Roles: []string{"admin"}, Tenants: []string{"acme"}, Percentage: 30},
+	}, nil)
+
+	target := func(subject, role, tenant string) context.Context {
+		return features.WithTarget(context.Background(), features.Target{Subject: subject, Role: role, Tenant: tenant})
+	}
+
+	assert.True(t, flags.Enabled(context.Background(), features.RateLimiting))
+	assert.False(t, flags.Enabled(context.Background(), "unknown"))
+	assert.False(t, flags.Enabled(context.Background(), beta))
+	assert.True(t, flags.Enabled(target("", "admin", ""), beta))
+	assert.True(t, flags.Enabled(target("", "viewer", "acme"), beta))
+	assert.False(t, flags.Enabled(target("", "viewer", "globex"), beta))
+
+	// Percentage rollouts are stable per subject and close to the target
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		ctx := target(fmt.Sprintf("user-%d", i), "viewer", "")
+		first := flags.Enabled(ctx, beta)
+		assert.Equal(t, first, flags.Enabled(ctx, beta))
+		if first {
+			enabled++
+		}
+	}
+	assert.InDelta(t, 300, enabled, 60)
+}
+
+func TestFeatureOverrides(t *testing.T) {
+	var evaluations []bool
+	flags := features.NewSet([]features.Flag{{Name: features.RequestLogging}}, func(name features.Name, enabled bool) {
+		evaluations = append(evaluations, enabled)
+	})
+	ctx := context.Background()
+
+	require.NoError(t, flags.Override(features.RequestLogging, true))
+	assert.True(t, flags.Enabled(ctx, features.RequestLogging))
+	state, ok := flags.Get(features.RequestLogging)
+	require.True(t, ok)
+	require.NotNil(t, state.Override)
+	assert.True(t, *state.Override)
+
+	require.NoError(t, flags.ClearOverride(features.RequestLogging))
+	assert.False(t, flags.Enabled(ctx, features.RequestLogging))
+	assert.Equal(t, []bool{true, false}, evaluations)
+
+	assert.ErrorIs(t, flags.Override("unknown", true), features.ErrUnknownFlag)
+}
+
+func TestFeatureReplace(t *testing.T) {
+	flags := features.NewSet([]features.Flag{{Name: features.RateLimiting}, {Name: features.LoadShedding}}, nil)
+	ctx := context.Background()
+	require.NoError(t, flags.Override(features.RateLimiting, true))
+	require.NoError(t, flags.Override(features.LoadShedding, true))
+
+	flags.Replace([]features.Flag{{Name: features.RateLimiting}, {Name: features.RequestLogging, Enabled: true}})
+	assert.True(t, flags.Enabled(ctx, features.RateLimiting), "overrides are kept")
+	assert.True(t, flags.Enabled(ctx, features.RequestLogging))
+	_, ok := flags.Get(features.LoadShedding)
+	assert.False(t, ok, "removed flags are dropped")
+}
+
+func TestFeatureLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "features.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+flags:
+  circuit_breaker:
+    enabled: true
+  beta_dashboard:
+    description: New dashboard
+    tenants: [acme]
+    percentage: 10
+`), 0o600))
+	t.Setenv("FEATURE_BETA_DASHBOARD_ROLES", "admin, operator")
+	t.Setenv("FEATURE_RATE_LIMITING", "true")
+
+	flags, err := features.Load([]features.Flag{
+		{Name: features.RateLimiting, Description: "Rate limits"},
+		{Name: features.CircuitBreaker, Description: "Breakers"},
+	}, path)
+	require.NoError(t, err)
+	require.Len(t, flags, 3)
+
+	byName := make(map[features.Name]features.Flag)
+	for _, flag := range flags {
+		byName[flag.Name] = flag
+	}
+	assert.True(t, byName[features.RateLimiting].Enabled)
+	assert.True(t, byName[features.CircuitBreaker].Enabled)
+	assert.Equal(t, "Breakers", byName[features.CircuitBreaker].Description)
+	assert.Equal(t, []string{"acme"}, byName["beta_dashboard"].Tenants)
+	assert.Equal(t, []string{"admin", "operator"}, byName["beta_dashboard"].Roles)
+	assert.Equal(t, 10, byName["beta_dashboard"].Percentage)
+
+	t.Setenv("FEATURE_RATE_LIMITING_PERCENTAGE", "150")
+	_, err = features.Load([]features.Flag{{Name: features.RateLimiting}}, "")
+	assert.Error(t, err)
+}
+
+func TestFeatureHandlers(t *testing.T) {
+	log := logger.New("debug").Logger
+	flags := features.NewSet([]features.Flag{{Name: features.RateLimiting, Description: "Rate limits"}}, nil)
+	h := api.NewFeatureHandlers(flags, log)
+
+	router := chi.NewRouter()
+	router.Get("/features", h.ListFeatures)
+	router.Put("/features/{name}", h.OverrideFeature)
+	router.Delete("/features/{name}/override", h.ClearFeatureOverride)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPut, "/features/rate_limiting", `{"enabled":true}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var flag models.FeatureFlagResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &flag))
+	require.NotNil(t, flag.Override)
+	assert.True(t, *flag.Override)
+	assert.True(t, flags.Enabled(context.Background(), features.RateLimiting))
+
+	rec = do(http.MethodGet, "/features", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list models.FeatureFlagListResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Flags, 1)
+	assert.Equal(t, "rate_limiting", list.Flags[0].Name)
+
+	rec = do(http.MethodDelete, "/features/rate_limiting/override", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, flags.Enabled(context.Background(), features.RateLimiting))
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPut, "/features/unknown", `{"enabled":true}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/features/rate_limiting", `{}`).Code)
+}
+
+func TestWhenEnabled(t *testing.T) {
+	flags := features.NewSet([]features.Flag{{Name: features.RequestLogging}}, nil)
+	features.SetDefault(flags)
+	defer features.SetDefault(nil)
+
+	tag := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Tagged", "true")
+			next.ServeHTTP(w, r)
+		})
+	}
+	handler := api.WhenEnabled(features.RequestLogging, tag)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, rec.Header().Get("X-Tagged"))
+
+	require.NoError(t, flags.Override(features.RequestLogging, true))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "true", rec.Header().Get("X-Tagged"))
+}
@@ -2,16 +2,23 @@ package unit
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
 	"github.com/pipeline-arch/app/internal/config"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
 	"github.com/pipeline-arch/app/pkg/logger"
 	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -25,26 +32,45 @@ func setupTestHandler() (*api.Handlers, *chi.Mux) {
 		MetricsPort: 9090,
 	}
 
-	log := logger.New("debug")
-	m := metrics.New("pipeline-arch-test", 9091)
+	log := logger.New("debug").Logger
+	m := metrics.NewWithRegistry("pipeline-arch-test", 9091, prometheus.NewRegistry())
+
+	repo := repository.NewInMemoryUserRepository()
+	for _, user := range []*models.User{
+		{ID: "1", Email: "user1@example.com", Name: "User One", Role: "admin", Active: true},
+		{ID: "2", Email: "user2@example.com", Name: "User Two", Role: "user", Active: true},
+		{ID: "123", Email: "user@example.com", Name: "Test User", Role: "user", Active: true},
+	} {
+		user.CreatedAt = time.Now().UTC()
+		user.UpdatedAt = user.CreatedAt
+		repo.Create(context.Background(), user)
+	}
 
 	handlers := api.NewHandlers(cfg, m, log)
+	users := api.NewUserHandlers(services.NewUserService(repo, log, m), log)
 	router := chi.NewRouter()
 
 	// Setup test routes
 	router.Get("/healthz", handlers.Healthz)
 	router.Get("/readyz", handlers.Readyz)
-	router.Get("/api/v1/users", handlers.ListUsers)
-	router.Post("/api/v1/users", handlers.CreateUser)
-	router.Get("/api/v1/users/{id}", handlers.GetUser)
-	router.Put("/api/v1/users/{id}", handlers.UpdateUser)
-	router.Delete("/api/v1/users/{id}", handlers.DeleteUser)
+	router.Get("/api/v1/users", users.ListUsers)
+	router.Post("/api/v1/users", users.CreateUser)
+	router.Get("/api/v1/users/{id}", users.GetUser)
+	router.Put("/api/v1/users/{id}", users.UpdateUser)
+	router.Delete("/api/v1/users/{id}", users.DeleteUser)
 
 	return handlers, router
 }
 
+var testAdmin = &auth.Principal{Subject: "1", Type: models.OwnerTypeUser, Role: "admin", Scopes: auth.ScopesForRole("admin")}
+
+// asCaller returns r as sent by the authenticated principal p
+func asCaller(r *http.Request, p *auth.Principal) *http.Request {
+	return r.WithContext(auth.WithPrincipal(r.Context(), p))
+}
+
 func TestHealthz(t *testing.T) {
-	handlers, router := setupTestHandler()
+	_, router := setupTestHandler()
 	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
 	w := httptest.NewRecorder()
 
@@ -55,7 +81,7 @@ func TestHealthz(t *testing.T) {
 }
 
 func TestReadyz(t *testing.T) {
-	handlers, router := setupTestHandler()
+	_, router := setupTestHandler()
 	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
 	w := httptest.NewRecorder()
 
@@ -111,7 +137,7 @@ func TestCreateUser(t *testing.T) {
 	}
 	jsonBody, _ := json.Marshal(body)
 
-	req := httptest.NewRequest(http.MethodPost, "/api/v1/users", bytes.NewBuffer(jsonBody))
+	req := asCaller(httptest.NewRequest(http.MethodPost, "/api/v1/users", bytes.NewBuffer(jsonBody)), testAdmin)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -156,7 +182,7 @@ func TestUpdateUser(t *testing.T) {
 	}
 	jsonBody, _ := json.Marshal(body)
 
-	req := httptest.NewRequest(http.MethodPut, "/api/v1/users/123", bytes.NewBuffer(jsonBody))
+	req := asCaller(httptest.NewRequest(http.MethodPut, "/api/v1/users/123", bytes.NewBuffer(jsonBody)), testAdmin)
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
@@ -172,6 +198,75 @@ func TestUpdateUser(t *testing.T) {
 	assert.Equal(t, "Updated User", user["name"])
 }
 
+func TestUserChangesRequireAdmin(t *testing.T) {
+	user := &auth.Principal{Subject: "123", Type: models.OwnerTypeUser, Role: "user", Scopes: auth.ScopesForRole("user")}
+	send := func(router http.Handler, method, path, body string, caller *auth.Principal) *httptest.ResponseRecorder {
+		req := asCaller(httptest.NewRequest(method, path, bytes.NewBufferString(body)), caller)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("users cannot create users", func(t *testing.T) {
+		_, router := setupTestHandler()
+		w := send(router, http.MethodPost, "/api/v1/users", `{"email":"evil@example.com","name":"Evil Admin","role":"admin"}`, user)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("users cannot change their own role", func(t *testing.T) {
+		_, router := setupTestHandler()
+		w := send(router, http.MethodPut, "/api/v1/users/123", `{"role":"admin"}`, user)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send(router, http.MethodGet, "/api/v1/users/123", "", user)
+		assert.Contains(t, w.Body.String(), `"role":"user"`)
+	})
+
+	t.Run("users cannot change their active state", func(t *testing.T) {
+		_, router := setupTestHandler()
+		w := send(router, http.MethodPut, "/api/v1/users/123", `{"active":true}`, user)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("users cannot update other users", func(t *testing.T) {
+		_, router := setupTestHandler()
+		w := send(router, http.MethodPut, "/api/v1/users/1", `{"password":"a-new-password-123"}`, user)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		w = send(router, http.MethodPut, "/api/v1/users/2", `{"name":"Renamed User"}`, user)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("users can update their own profile", func(t *testing.T) {
+		_, router := setupTestHandler()
+		w := send(router, http.MethodPut, "/api/v1/users/123", `{"name":"Renamed User","password":"a-new-password-123"}`, user)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "Renamed User")
+	})
+
+	t.Run("admins can change roles and passwords of other users", func(t *testing.T) {
+		_, router := setupTestHandler()
+		w := send(router, http.MethodPut, "/api/v1/users/123", `{"role":"viewer","active":false,"password":"a-new-password-123"}`, testAdmin)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"role":"viewer"`)
+		assert.Contains(t, w.Body.String(), `"active":false`)
+	})
+
+	t.Run("unauthenticated callers are treated as non-admins", func(t *testing.T) {
+		_, router := setupTestHandler()
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/users/123", bytes.NewBufferString(`{"role":"admin"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		req = httptest.NewRequest(http.MethodPut, "/api/v1/users/123", bytes.NewBufferString(`{"name":"Renamed User"}`))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
 func TestDeleteUser(t *testing.T) {
 	_, router := setupTestHandler()
 	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/123", nil)
@@ -186,6 +281,10 @@ func TestDeleteUser(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, "User deleted successfully", response["message"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users/123", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
 }
 
 func TestUserResponseToJSON(t *testing.T) {
@@ -197,8 +296,7 @@ func TestUserResponseToJSON(t *testing.T) {
 		MetricsPort: 9090,
 	}
 
-	log := logger.New("debug")
-	_ = metrics.New("pipeline-arch-test", 9091)
+	log := logger.New("debug").Logger
 	handlers := api.NewHandlers(cfg, nil, log)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -0,0 +1,246 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStores(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	stores := map[string]repository.IdempotencyStore{
+		"memory": repository.NewInMemoryIdempotencyStore(),
+		"redis":  repository.NewRedisIdempotencyStore(client, "test:"),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			record, err := store.Begin(ctx, "key-1", "fp-1", time.Minute)
+			require.NoError(t, err)
+			assert.Nil(t, record, "new keys are reserved")
+
+			record, err = store.Begin(ctx, "key-1", "fp-1", time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, record)
+			assert.False(t, record.Completed)
+
+			require.NoError(t, store.Release(ctx, "key-1"))
+			record, err = store.Begin(ctx, "key-1", "fp-1", time.Minute)
+			require.NoError(t, err)
+			assert.Nil(t, record, "released keys can be reserved again")
+
+			require.NoError(t, store.Complete(ctx, &models.IdempotencyRecord{
+				Key:         "key-1",
+				Fingerprint: "fp-1",
+				StatusCode:  http.StatusCreated,
+				Header:      map[string][]string{"Content-Type": {"application/json"}},
+				Body:        []byte(`{"id":"1"}`),
+			}, time.Hour))
+			require.NoError(t, store.Release(ctx, "key-1"), "completed keys are not released")
+
+			record, err = store.Begin(ctx, "key-1", "fp-2", time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, record)
+			assert.True(t, record.Completed)
+			assert.Equal(t, "fp-1", record.Fingerprint)
+			assert.Equal(t, http.StatusCreated, record.StatusCode)
+			assert.Equal(t, `{"id":"1"}`, string(record.Body))
+			assert.Equal(t, "application/json", record.Header["Content-Type"][0])
+		})
+	}
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	log := logger.New("debug").Logger
+	var created atomic.Int32
+	inFlight := make(chan struct{})
+	release := make(chan struct{})
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if subject := r.Header.Get("X-Test-User"); subject != "" {
+				r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: subject, Type: "user"}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.Use(api.Idempotency(repository.NewInMemoryIdempotencyStore(), time.Hour, log))
+	router.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		n := strconv.Itoa(int(created.Add(1)))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/users/"+n)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"n":` + n + `}`))
+	})
+	router.Post("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.Post("/fail", func(w http.ResponseWriter, r *http.Request) {
+		created.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	post := func(path, user, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		if key != "" {
+			req.Header.Set(api.IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("replays the first response", func(t *testing.T) {
+		created.Store(0)
+		first := post("/users", "alice", "create-1", `{"email":"a@example.com"}`)
+		require.Equal(t, http.StatusCreated, first.Code)
+
+		retry := post("/users", "alice", "create-1", `{"email":"a@example.com"}`)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "/users/1", retry.Header().Get("Location"))
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, int32(1), created.Load())
+	})
+
+	t.Run("rejects key reuse with a different payload", func(t *testing.T) {
+		post("/users", "alice", "create-2", `{"email":"b@example.com"}`)
+		rec := post("/users", "alice", "create-2", `{"email":"c@example.com"}`)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	})
+
+	t.Run("keys are scoped to the principal", func(t *testing.T) {
+		created.Store(0)
+		post("/users", "alice", "shared", `{}`)
+		rec := post("/users", "bob", "shared", `{}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Empty(t, rec.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, int32(2), created.Load())
+	})
+
+	t.Run("server errors are not stored", func(t *testing.T) {
+		created.Store(0)
+		assert.Equal(t, http.StatusInternalServerError, post("/fail", "alice", "fail-1", `{}`).Code)
+		assert.Equal(t, http.StatusInternalServerError, post("/fail", "alice", "fail-1", `{}`).Code)
+		assert.Equal(t, int32(2), created.Load())
+	})
+
+	t.Run("concurrent duplicates conflict", func(t *testing.T) {
+		done := make(chan int)
+		go func() {
+			done <- post("/slow", "alice", "slow-1", `{}`).Code
+		}()
+		<-inFlight
+
+		rec := post("/slow", "alice", "slow-1", `{}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+		close(release)
+		assert.Equal(t, http.StatusCreated, <-done)
+	})
+
+	t.Run("anonymous requests and requests without a key are not deduplicated", func(t *testing.T) {
+		created.Store(0)
+		post("/users", "", "anon", `{}`)
+		post("/users", "", "anon", `{}`)
+		post("/users", "alice", "", `{}`)
+		post("/users", "alice", "", `{}`)
+		assert.Equal(t, int32(4), created.Load())
+	})
+}
+
+func TestIdempotencySecretResponses(t *testing.T) {
+	log := logger.New("debug").Logger
+	store := repository.NewInMemoryIdempotencyStore()
+	keys := repository.NewInMemoryAPIKeyRepository()
+	handlers := api.NewAPIKeyHandlers(services.NewAPIKeyService(keys, log, nil), log)
+	admin := &auth.Principal{Subject: "admin-1", Type: models.OwnerTypeUser, Scopes: []string{models.ScopeAdmin}}
+
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), admin)))
+		})
+	})
+	router.Use(api.Idempotency(store, time.Hour, log))
+	router.Post("/api-keys", handlers.CreateAPIKey)
+	router.Post("/api-keys/{id}/rotate", handlers.RotateAPIKey)
+
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(api.IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		name string
+		path func(t *testing.T) string
+	}{
+		{"create", func(t *testing.T) string { return "/api-keys" }},
+		{"rotate", func(t *testing.T) string {
+			rec := post("/api-keys", "setup", `{"name":"to-rotate","scopes":["users:read"]}`)
+			require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+			var created models.APIKeySecretResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+			return "/api-keys/" + created.ID + "/rotate"
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := tc.path(t)
+			body := `{"name":"ci-deploy","scopes":["users:read"]}`
+
+			first := post(path, tc.name, body)
+			require.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+			assert.Equal(t, "no-store", first.Header().Get("Cache-Control"))
+			var created models.APIKeySecretResponse
+			require.NoError(t, json.Unmarshal(first.Body.Bytes(), &created))
+			require.NotEmpty(t, created.Key)
+
+			record, err := store.Begin(context.Background(), "user:admin-1:"+tc.name, "", time.Minute)
+			require.NoError(t, err)
+			require.NotNil(t, record)
+			assert.NotContains(t, string(record.Body), created.Key, "the key is not stored")
+
+			before, err := keys.ListByOwner(context.Background(), "admin-1")
+			require.NoError(t, err)
+			retry := post(path, tc.name, body)
+			assert.Equal(t, http.StatusConflict, retry.Code)
+			assert.NotContains(t, retry.Body.String(), created.Key)
+			after, err := keys.ListByOwner(context.Background(), "admin-1")
+			require.NoError(t, err)
+			assert.Len(t, after, len(before), "the retry does not issue another key")
+		})
+	}
+}
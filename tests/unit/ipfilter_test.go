@@ -0,0 +1,161 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/pkg/ipacl"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPACLClientIP(t *testing.T) {
+	acl, err := ipacl.New(ipacl.Config{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}}, "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{name: "untrusted peer", remoteAddr: "203.0.113.9:4000", forwarded: []string{"10.1.1.1"}, want: "203.0.113.9"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.2:4000", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "spoofed first hop", remoteAddr: "10.0.0.2:4000", forwarded: []string{"1.1.1.1, 198.51.100.7, 10.0.0.3"}, want: "198.51.100.7"},
+		{name: "repeated headers", remoteAddr: "10.0.0.2:4000", forwarded: []string{"198.51.100.7", "192.168.1.1"}, want: "198.51.100.7"},
+		{name: "only proxies", remoteAddr: "10.0.0.2:4000", forwarded: []string{"10.0.0.4, 10.0.0.3"}, want: "10.0.0.4"},
+		{name: "malformed hop", remoteAddr: "10.0.0.2:4000", forwarded: []string{"198.51.100.7, junk"}, want: "10.0.0.2"},
+		{name: "real ip header", remoteAddr: "10.0.0.2:4000", realIP: "198.51.100.8", want: "198.51.100.8"},
+		{name: "mapped address", remoteAddr: "[::ffff:10.0.0.2]:4000", forwarded: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "ipv6 peer", remoteAddr: "[2001:db8::1]:4000", want: "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.want, acl.ClientIP(req).String())
+		})
+	}
+}
+
+func TestIPACLAllowed(t *testing.T) {
+	acl, err := ipacl.New(ipacl.Config{Groups: map[string]ipacl.Rule{
+		"admin":    {Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.0.99.0/24"}},
+		"internal": {Deny: []string{"203.0.113.5"}},
+	}}, "")
+	require.NoError(t, err)
+
+	allowed := func(group, addr string) bool {
+		return acl.Allowed(group, netip.MustParseAddr(addr))
+	}
+	assert.True(t, allowed("admin", "10.1.2.3"))
+	assert.True(t, allowed("admin", "::ffff:10.1.2.3"))
+	assert.True(t, allowed("admin", "2001:db8::1"))
+	assert.False(t, allowed("admin", "10.0.99.1"), "deny wins over allow")
+	assert.False(t, allowed("admin", "203.0.113.1"))
+	assert.True(t, allowed("internal", "203.0.113.1"), "empty allow list admits everyone")
+	assert.False(t, allowed("internal", "203.0.113.5"))
+	assert.True(t, allowed("public", "203.0.113.5"), "groups without rules admit everyone")
+	assert.False(t, acl.Allowed("admin", netip.Addr{}))
+
+	_, err = ipacl.New(ipacl.Config{Groups: map[string]ipacl.Rule{"admin": {Allow: []string{"10.0.0.0/33"}}}}, "")
+	assert.Error(t, err)
+	_, err = ipacl.New(ipacl.Config{TrustedProxies: []string{"proxy.local"}}, "")
+	assert.Error(t, err)
+}
+
+func TestIPACLReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("groups:\n  admin:\n    allow: [10.0.0.0/8]\n"), 0o600))
+
+	acl, err := ipacl.New(ipacl.Config{Groups: map[string]ipacl.Rule{
+		"admin":    {Allow: []string{"192.168.0.0/16"}},
+		"internal": {Allow: []string{"192.168.0.0/16"}},
+	}}, path)
+	require.NoError(t, err)
+	assert.True(t, acl.Allowed("admin", netip.MustParseAddr("10.1.1.1")), "file replaces the group")
+	assert.False(t, acl.Allowed("admin", netip.MustParseAddr("192.168.1.1")))
+	assert.False(t, acl.Allowed("internal", netip.MustParseAddr("10.1.1.1")), "other groups are kept")
+
+	reloads := make(chan error, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go acl.Watch(ctx, 10*time.Millisecond, func(err error) { reloads <- err })
+
+	writeFile := func(content string, modTime time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	waitReload := func() error {
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(2 * time.Second):
+			t.Fatal("file not reloaded")
+			return nil
+		}
+	}
+
+	writeFile("groups:\n  admin:\n    allow: [172.16.0.0/12]\n", time.Now().Add(time.Minute))
+	require.NoError(t, waitReload())
+	assert.True(t, acl.Allowed("admin", netip.MustParseAddr("172.16.1.1")))
+	assert.False(t, acl.Allowed("admin", netip.MustParseAddr("10.1.1.1")))
+
+	writeFile("groups:\n  admin:\n    allow: [not-a-cidr]\n", time.Now().Add(2*time.Minute))
+	assert.Error(t, waitReload())
+	assert.True(t, acl.Allowed("admin", netip.MustParseAddr("172.16.1.1")), "previous lists are kept")
+}
+
+func TestIPFilter(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	acl, err := ipacl.New(ipacl.Config{
+		TrustedProxies: []string{"10.0.0.1"},
+		Groups:         map[string]ipacl.Rule{api.IPGroupAdmin: {Allow: []string{"10.8.0.0/16"}}},
+	}, "")
+	require.NoError(t, err)
+
+	var denied []string
+	var seenAddr string
+	handler := api.RealIP(acl)(api.IPFilter(acl, api.IPGroupAdmin, &log, func(group string) {
+		denied = append(denied, group)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenAddr = r.RemoteAddr
+	})))
+
+	serve := func(remoteAddr, forwarded string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/admin/users/1/sessions", nil)
+		req.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("10.0.0.1:5000", "10.8.3.4"))
+	assert.Equal(t, "10.8.3.4", seenAddr)
+	assert.Empty(t, denied)
+
+	assert.Equal(t, http.StatusForbidden, serve("203.0.113.9:5000", "10.8.3.4"), "forwarding headers from untrusted peers are ignored")
+	assert.Equal(t, []string{api.IPGroupAdmin}, denied)
+	assert.Contains(t, buf.String(), `"message":"request denied by IP filter"`)
+	assert.Contains(t, buf.String(), `"client_ip":"203.0.113.9"`)
+}
@@ -0,0 +1,83 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	_, routes := setupTestHandler()
+	router := chi.NewRouter()
+	router.Use(api.MaxBodySize(64))
+	router.Mount("/", routes)
+
+	body := `{"email":"limit@example.com","name":"` + strings.Repeat("x", 100) + `","role":"user"}`
+
+	t.Run("declared length over the limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("streamed body over the limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(body))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("malformed body within the limit", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(`{`))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	routes, err := api.ParseRouteTimeouts("/slow=200ms, /slow/fast=10ms")
+	require.NoError(t, err)
+	require.Len(t, routes, 2)
+
+	_, err = api.ParseRouteTimeouts("slow=1s")
+	assert.Error(t, err)
+	_, err = api.ParseRouteTimeouts("/slow=soon")
+	assert.Error(t, err)
+
+	wait := func(d time.Duration) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(d):
+				w.WriteHeader(http.StatusOK)
+			case <-r.Context().Done():
+			}
+		}
+	}
+	router := chi.NewRouter()
+	router.Use(api.Timeout(20*time.Millisecond, routes))
+	router.Get("/default", wait(50*time.Millisecond))
+	router.Get("/slow", wait(50*time.Millisecond))
+	router.Get("/slow/fast", wait(50*time.Millisecond))
+	router.Get("/slowest", wait(50*time.Millisecond))
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusGatewayTimeout, get("/default"))
+	assert.Equal(t, http.StatusOK, get("/slow"), "overrides may exceed the default")
+	assert.Equal(t, http.StatusGatewayTimeout, get("/slow/fast"), "the longest prefix wins")
+	assert.Equal(t, http.StatusGatewayTimeout, get("/slowest"), "prefixes match whole segments")
+}
@@ -0,0 +1,57 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_requests_total"}, []string{"method", "path", "status"})
+
+	router := chi.NewRouter()
+	router.Use(api.MetricsMiddleware(requests))
+	router.Route("/users", func(r chi.Router) {
+		r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if chi.URLParam(r, "id") == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte("ok"))
+		})
+	})
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/missing", "/nope"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(requests.WithLabelValues("GET", "/users/{id}", "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("GET", "/users/{id}", "404")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requests.WithLabelValues("GET", "unmatched", "404")))
+	assert.Equal(t, 3, testutil.CollectAndCount(requests), "one series per route pattern and status")
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var m *metrics.Metrics
+	assert.NotPanics(t, func() {
+		m.IncUsers()
+		m.IncOperation("create_user", "success")
+		m.RecordHTTPOutcome("GET", "/", "200", time.Second)
+		m.RecordConfigReload("success")
+		m.SetConcurrencyLimit(10)
+	})
+	assert.Equal(t, 0, m.InFlight())
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	rec := httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
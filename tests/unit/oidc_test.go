@@ -0,0 +1,465 @@
+package unit
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oidcClientID = "pipeline-arch-client"
+
+// fakeIssuer is a minimal local OpenID Connect provider
+type fakeIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	key       *rsa.PrivateKey
+	kid       string
+	codes     map[string]fakeGrant
+	jwksCalls int
+}
+
+type fakeGrant struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	f := &fakeIssuer{t: t, codes: make(map[string]fakeGrant)}
+	f.rotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.server.URL,
+			"authorization_endpoint": f.server.URL + "/authorize",
+			"token_endpoint":         f.server.URL + "/token",
+			"jwks_uri":               f.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.jwksCalls++
+		pub := f.key.PublicKey
+		_ = json.NewEncoder(w).Encode(auth.JSONWebKeySet{Keys: []auth.JSONWebKey{{
+			KeyType:   "RSA",
+			KeyID:     f.kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		f.mu.Lock()
+		grant, ok := f.codes[r.PostForm.Get("code")]
+		delete(f.codes, r.PostForm.Get("code"))
+		f.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "opaque",
+			"id_token":     f.sign(grant.claims),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeIssuer) rotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(f.t, err)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.key = key
+	f.kid = base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()[:8])
+}
+
+// authorize simulates a successful login at the IdP and returns a code
+func (f *fakeIssuer) authorize(authURL string, claims map[string]interface{}) string {
+	u, err := url.Parse(authURL)
+	require.NoError(f.t, err)
+	q := u.Query()
+	require.Equal(f.t, "S256", q.Get("code_challenge_method"))
+
+	claims["nonce"] = q.Get("nonce")
+	code := "code-" + q.Get("state")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.codes[code] = fakeGrant{challenge: q.Get("code_challenge"), claims: claims}
+	return code
+}
+
+func (f *fakeIssuer) claims(aud, email string, groups ...string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":            f.server.URL,
+		"sub":            "idp|" + email,
+		"aud":            aud,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"email":          email,
+		"email_verified": true,
+		"name":           "SSO User",
+		"groups":         groups,
+	}
+}
+
+func (f *fakeIssuer) sign(claims map[string]interface{}) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": f.kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	require.NoError(f.t, err)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// setupOIDC returns an OIDC service whose role syncs revoke sessions
+// through a real session service, and the auth service that issues its
+// tokens
+func setupOIDC(t *testing.T) (*fakeIssuer, *services.OIDCService, *repository.InMemoryUserRepository, *services.AuthService, *services.SessionService) {
+	t.Helper()
+	issuer := newFakeIssuer(t)
+	authSvc, users := setupAuthService(t)
+
+	provider := auth.NewOIDCProvider(auth.OIDCConfig{
+		IssuerURL:           issuer.server.URL,
+		ClientID:            oidcClientID,
+		RedirectURL:         "http://localhost/api/v1/auth/oidc/callback",
+		Scopes:              []string{"openid", "email", "groups"},
+		Audience:            "pipeline-arch-api",
+		JWKSRefreshInterval: time.Nanosecond,
+	}, issuer.server.Client())
+
+	groups, err := auth.ParseRoleMapping("platform-admins=admin, developers=user")
+	require.NoError(t, err)
+
+	log := logger.New("debug").Logger
+	svc := services.NewOIDCService(provider, auth.NewRoleMapper(groups, "viewer"), users, authSvc, log, nil)
+	sessions := services.NewSessionService(repository.NewInMemoryRevocationStore(), repository.NewInMemoryRefreshTokenRepository(), 15*time.Minute, log, nil)
+	svc.SetSessionRevoker(sessions)
+	return issuer, svc, users, authSvc, sessions
+}
+
+// linkOIDCUser creates a user linked to the fake issuer's subject for email
+func linkOIDCUser(t *testing.T, users repository.UserRepository, issuer *fakeIssuer, email, role string) *models.User {
+	t.Helper()
+	user := models.NewUser(email, "SSO User", role)
+	user.OIDCIssuer = issuer.server.URL
+	user.OIDCSubject = "idp|" + email
+	require.NoError(t, users.Create(context.Background(), user))
+	return user
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer, svc, users, authSvc, sessions := setupOIDC(t)
+	log := logger.New("debug").Logger
+
+	handlers := api.NewOIDCHandlers(svc, []byte("cookie-key"), false, log)
+	r := chi.NewRouter()
+	r.Get("/auth/oidc/login", handlers.Login)
+	r.Get("/auth/oidc/callback", handlers.Callback)
+
+	login := func() (string, *http.Cookie) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+		require.Equal(t, http.StatusFound, rec.Code)
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.Equal(t, "/auth/oidc", cookies[0].Path)
+		return rec.Header().Get("Location"), cookies[0]
+	}
+
+	callback := func(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// revoked verifies an access token and reports whether it was revoked
+	revoked := func(token string) bool {
+		principal, err := authSvc.VerifyAccessToken(context.Background(), token)
+		require.NoError(t, err)
+		revoked, err := sessions.IsRevoked(context.Background(), principal)
+		require.NoError(t, err)
+		return revoked
+	}
+
+	var adminToken string
+	t.Run("provisions user with mapped role", func(t *testing.T) {
+		location, cookie := login()
+		state := mustQuery(t, location, "state")
+		code := issuer.authorize(location, issuer.claims(oidcClientID, "sso@example.com", "developers", "platform-admins"))
+
+		rec := callback(url.Values{"code": {code}, "state": {state}}, cookie)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+		var tokens map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+		assert.NotEmpty(t, tokens["access_token"])
+		assert.NotEmpty(t, tokens["refresh_token"])
+
+		user, err := users.GetByEmail(context.Background(), "sso@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, user)
+		assert.Equal(t, "admin", user.Role)
+		assert.Empty(t, user.PasswordHash)
+		assert.Equal(t, issuer.server.URL, user.OIDCIssuer)
+		assert.Equal(t, "idp|sso@example.com", user.OIDCSubject)
+
+		adminToken = tokens["access_token"].(string)
+		assert.False(t, revoked(adminToken))
+	})
+
+	t.Run("syncs role on later logins", func(t *testing.T) {
+		location, cookie := login()
+		code := issuer.authorize(location, issuer.claims(oidcClientID, "sso@example.com"))
+
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		user, err := users.GetByEmail(context.Background(), "sso@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "viewer", user.Role)
+		assert.True(t, revoked(adminToken), "tokens with the old role are revoked")
+
+		var tokens map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &tokens))
+		assert.False(t, revoked(tokens["access_token"].(string)), "the token issued with the new role is valid")
+	})
+
+	t.Run("finds linked users by subject", func(t *testing.T) {
+		location, cookie := login()
+		claims := issuer.claims(oidcClientID, "sso@example.com")
+		claims["email"] = "renamed@example.com"
+		claims["email_verified"] = false
+		code := issuer.authorize(location, claims)
+
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		renamed, err := users.GetByEmail(context.Background(), "renamed@example.com")
+		require.NoError(t, err)
+		assert.Nil(t, renamed, "no second user is provisioned")
+	})
+
+	t.Run("rejects email linked to another subject", func(t *testing.T) {
+		location, cookie := login()
+		claims := issuer.claims(oidcClientID, "sso@example.com")
+		claims["sub"] = "idp|someone-else"
+		code := issuer.authorize(location, claims)
+
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("links existing user by verified email", func(t *testing.T) {
+		for name, verified := range map[string]interface{}{"unverified": false, "missing": nil} {
+			location, cookie := login()
+			claims := issuer.claims(oidcClientID, "login@example.com")
+			if verified == nil {
+				delete(claims, "email_verified")
+			} else {
+				claims["email_verified"] = verified
+			}
+			code := issuer.authorize(location, claims)
+
+			rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+			assert.Equal(t, http.StatusForbidden, rec.Code, name)
+		}
+
+		user, err := users.GetByEmail(context.Background(), "login@example.com")
+		require.NoError(t, err)
+		assert.Empty(t, user.OIDCSubject, "unverified emails are not linked")
+
+		location, cookie := login()
+		code := issuer.authorize(location, issuer.claims(oidcClientID, "login@example.com", "developers"))
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		user, err = users.GetByEmail(context.Background(), "login@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "idp|login@example.com", user.OIDCSubject)
+	})
+
+	t.Run("rejects state mismatch", func(t *testing.T) {
+		location, cookie := login()
+		code := issuer.authorize(location, issuer.claims(oidcClientID, "sso@example.com"))
+
+		rec := callback(url.Values{"code": {code}, "state": {"forged"}}, cookie)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("rejects missing state cookie", func(t *testing.T) {
+		location, _ := login()
+		code := issuer.authorize(location, issuer.claims(oidcClientID, "sso@example.com"))
+
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("rejects ID token for another client", func(t *testing.T) {
+		location, cookie := login()
+		code := issuer.authorize(location, issuer.claims("someone-else", "other@example.com"))
+
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("rejects unverified email", func(t *testing.T) {
+		location, cookie := login()
+		claims := issuer.claims(oidcClientID, "unverified@example.com")
+		claims["email_verified"] = false
+		code := issuer.authorize(location, claims)
+
+		rec := callback(url.Values{"code": {code}, "state": {mustQuery(t, location, "state")}}, cookie)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("reports IdP errors", func(t *testing.T) {
+		_, cookie := login()
+		rec := callback(url.Values{"error": {"access_denied"}}, cookie)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestOIDCAccessTokens(t *testing.T) {
+	issuer, svc, users, _, _ := setupOIDC(t)
+	ctx := context.Background()
+
+	t.Run("rejects subjects that never logged in", func(t *testing.T) {
+		_, err := svc.VerifyAccessToken(ctx, issuer.sign(issuer.claims("pipeline-arch-api", "api@example.com", "developers")))
+		assert.Error(t, err)
+
+		user, err := users.GetByEmail(ctx, "api@example.com")
+		require.NoError(t, err)
+		assert.Nil(t, user, "access tokens do not provision users")
+	})
+
+	user := linkOIDCUser(t, users, issuer, "api@example.com", "user")
+
+	t.Run("accepts IdP access token", func(t *testing.T) {
+		principal, err := svc.VerifyAccessToken(ctx, issuer.sign(issuer.claims("pipeline-arch-api", "api@example.com", "developers")))
+		require.NoError(t, err)
+		assert.Equal(t, auth.MethodOIDC, principal.Method)
+		assert.Equal(t, user.ID, principal.Subject)
+		assert.Equal(t, "user", principal.Role)
+	})
+
+	t.Run("uses the role synced at login", func(t *testing.T) {
+		claims := issuer.claims("pipeline-arch-api", "api@example.com", "platform-admins")
+		delete(claims, "email")
+		delete(claims, "email_verified")
+		principal, err := svc.VerifyAccessToken(ctx, issuer.sign(claims))
+		require.NoError(t, err)
+		assert.Equal(t, "user", principal.Role)
+
+		stored, err := users.GetByID(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "user", stored.Role)
+	})
+
+	t.Run("rejects wrong audience", func(t *testing.T) {
+		_, err := svc.VerifyAccessToken(ctx, issuer.sign(issuer.claims(oidcClientID, "api@example.com")))
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects expired token", func(t *testing.T) {
+		claims := issuer.claims("pipeline-arch-api", "api@example.com")
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		_, err := svc.VerifyAccessToken(ctx, issuer.sign(claims))
+		assert.Error(t, err)
+	})
+
+	t.Run("picks up rotated signing keys", func(t *testing.T) {
+		issuer.rotateKey()
+		issuer.mu.Lock()
+		before := issuer.jwksCalls
+		issuer.mu.Unlock()
+
+		_, err := svc.VerifyAccessToken(ctx, issuer.sign(issuer.claims("pipeline-arch-api", "api@example.com")))
+		require.NoError(t, err)
+
+		issuer.mu.Lock()
+		assert.Equal(t, before+1, issuer.jwksCalls)
+		issuer.mu.Unlock()
+	})
+
+	t.Run("token verifier chain falls through to IdP", func(t *testing.T) {
+		authSvc, _ := setupAuthService(t)
+		chain := api.TokenVerifiers{authSvc, svc}
+
+		principal, err := chain.VerifyAccessToken(ctx, issuer.sign(issuer.claims("pipeline-arch-api", "api@example.com")))
+		require.NoError(t, err)
+		assert.Equal(t, auth.MethodOIDC, principal.Method)
+
+		_, err = chain.VerifyAccessToken(ctx, "not-a-token")
+		assert.Error(t, err)
+	})
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := auth.ParseRoleMapping("ops=admin,dev=user")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ops": "admin", "dev": "user"}, mapping)
+
+	_, err = auth.ParseRoleMapping("ops=root")
+	assert.Error(t, err)
+
+	_, err = auth.ParseRoleMapping("ops")
+	assert.Error(t, err)
+}
+
+func mustQuery(t *testing.T, rawURL, key string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u.Query().Get(key)
+}
@@ -0,0 +1,175 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/pipeline-arch/app/pkg/ratelimit"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStores(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	stores := map[string]ratelimit.Store{
+		"memory": ratelimit.NewMemoryStore(),
+		"redis":  ratelimit.NewRedisStore(client, "test:"),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			limit := ratelimit.Limit{Rate: 60, Period: time.Minute, Burst: 3}
+
+			for i := 2; i >= 0; i-- {
+				result, err := store.Allow(ctx, "caller", limit)
+				require.NoError(t, err)
+				assert.True(t, result.Allowed)
+				assert.Equal(t, i, result.Remaining)
+			}
+
+			result, err := store.Allow(ctx, "caller", limit)
+			require.NoError(t, err)
+			assert.False(t, result.Allowed)
+			assert.Equal(t, 0, result.Remaining)
+			assert.Greater(t, result.RetryAfter, time.Duration(0))
+			assert.LessOrEqual(t, result.RetryAfter, time.Second)
+
+			result, err = store.Allow(ctx, "other", limit)
+			require.NoError(t, err)
+			assert.True(t, result.Allowed, "keys have separate quotas")
+		})
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	log := logger.New("debug").Logger
+	limits := api.NewRateLimits(ratelimit.NewMemoryStore(), ratelimit.PerMinute(2), []api.RouteRateLimit{
+		{Prefix: "/auth", Limit: ratelimit.PerMinute(1)},
+	})
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	router := chi.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := r.Header.Get("X-Test-Key"); key != "" {
+				quota, _ := strconv.Atoi(r.Header.Get("X-Test-Quota"))
+				r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: "svc", KeyID: key, RateLimit: quota}))
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.With(api.RateLimiter(limits, log)).Get("/users", ok)
+	router.With(api.RateLimiter(limits, log)).Post("/auth/token", ok)
+	router.With(api.RateLimiter(nil, log)).Get("/unlimited", ok)
+
+	quotas := map[string]string{"key-quota": "3"}
+	do := func(method, path, ip, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if key != "" {
+			req.Header.Set("X-Test-Key", key)
+			req.Header.Set("X-Test-Quota", quotas[key])
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodGet, "/users", "10.0.0.1", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2;w=60", rec.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/users", "10.0.0.1", "").Code)
+
+	rec = do(http.MethodGet, "/users", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+	// Other IPs and API keys from the same IP have their own quota
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/users", "10.0.0.2", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/users", "10.0.0.1", "key-1").Code)
+
+	// Route overrides keep a separate, stricter quota
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/auth/token", "10.0.0.1", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/auth/token", "10.0.0.1", "").Code)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/unlimited", "10.0.0.1", "").Code)
+	}
+
+	t.Run("API keys with a quota get it instead of the default", func(t *testing.T) {
+		rec := do(http.MethodGet, "/users", "10.0.0.3", "key-quota")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "3", rec.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/users", "10.0.0.3", "key-quota").Code)
+		assert.Equal(t, http.StatusOK, do(http.MethodGet, "/users", "10.0.0.3", "key-quota").Code)
+		assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/users", "10.0.0.3", "key-quota").Code)
+
+		// Route limits still apply
+		assert.Equal(t, http.StatusOK, do(http.MethodPost, "/auth/token", "10.0.0.3", "key-quota").Code)
+		assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/auth/token", "10.0.0.3", "key-quota").Code)
+	})
+
+	t.Run("route limits can be replaced", func(t *testing.T) {
+		limits.SetRoutes([]api.RouteRateLimit{{Prefix: "/auth", Limit: ratelimit.PerMinute(5)}, {Prefix: "/users/", Limit: ratelimit.PerMinute(1)}})
+		assert.Equal(t, ratelimit.PerMinute(1), limits.Limiter("/users").Limit())
+		assert.Equal(t, ratelimit.PerMinute(2), limits.Limiter("/usersx").Limit())
+
+		// Callers keep their state on routes that stay limited
+		assert.Equal(t, http.StatusTooManyRequests, do(http.MethodPost, "/auth/token", "10.0.0.1", "").Code)
+		rec := do(http.MethodPost, "/auth/token", "10.0.0.9", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "4", rec.Header().Get("RateLimit-Remaining"))
+
+		limits.SetDefault(ratelimit.PerMinute(10))
+		assert.Equal(t, ratelimit.PerMinute(10), limits.Limiter("/other").Limit())
+	})
+}
+
+func TestParseRouteRateLimits(t *testing.T) {
+	routes, err := api.ParseRouteRateLimits(" /api/v1/auth=20, /api/v1/admin = 30 ,")
+	require.NoError(t, err)
+	assert.Equal(t, []api.RouteRateLimit{
+		{Prefix: "/api/v1/auth", Limit: ratelimit.PerMinute(20)},
+		{Prefix: "/api/v1/admin", Limit: ratelimit.PerMinute(30)},
+	}, routes)
+
+	for _, invalid := range []string{"api/v1=20", "/api/v1", "/api/v1=0", "/api/v1=fast"} {
+		_, err := api.ParseRouteRateLimits(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	ctx := context.Background()
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), "test", ratelimit.PerMinute(1))
+	res, err := limiter.Allow(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.Remaining)
+
+	limiter.SetLimit(ratelimit.PerMinute(100))
+	assert.Equal(t, ratelimit.PerMinute(100), limiter.Limit())
+	res, err = limiter.Allow(ctx, "b")
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 99, res.Remaining)
+}
@@ -0,0 +1,120 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/pkg/safego"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecovery(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	var routes []string
+
+	r := chi.NewRouter()
+	r.Use(api.RequestContext(&log))
+	r.Use(api.Recovery(&log, func(route string) { routes = append(routes, route) }))
+	r.Get("/boom/{id}", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r.Get("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("late boom")
+	})
+	r.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	t.Run("returns problem details", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/boom/1", nil)
+		req.Header.Set("X-Request-ID", "req-boom")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+		var problem models.ProblemDetails
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+		assert.Equal(t, "about:blank", problem.Type)
+		assert.Equal(t, "Internal Server Error", problem.Title)
+		assert.Equal(t, http.StatusInternalServerError, problem.Status)
+		assert.Equal(t, "/boom/1", problem.Instance)
+		assert.Equal(t, "req-boom", problem.RequestID)
+		assert.Len(t, problem.TraceID, 32)
+		assert.Equal(t, "The server encountered an unexpected error", problem.Detail, "the panic value is not disclosed")
+
+		assert.Equal(t, []string{"/boom/{id}"}, routes)
+		logged := buf.String()
+		assert.Contains(t, logged, `"message":"panic recovered"`)
+		assert.Contains(t, logged, `"request_id":"req-boom"`)
+		assert.Contains(t, logged, `"route":"/boom/{id}"`)
+		assert.Contains(t, logged, `"stack":"goroutine `)
+	})
+
+	t.Run("keeps a started response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partial", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "partial", rec.Body.String())
+	})
+
+	t.Run("passes on aborts", func(t *testing.T) {
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+		})
+	})
+}
+
+func TestSafeGo(t *testing.T) {
+	panics := make(chan *safego.Panic, 1)
+	safego.SetHandler(func(ctx context.Context, p *safego.Panic) { panics <- p })
+	t.Cleanup(func() { safego.SetHandler(nil) })
+
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	r := chi.NewRouter()
+	r.Use(api.RequestContext(&log))
+	r.Use(api.Recovery(&log, nil))
+	r.Post("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		safego.Go(r.Context(), func(ctx context.Context) { panic("job failed") })
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/jobs", nil)
+	req.Header.Set("X-Request-ID", "req-job")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	select {
+	case p := <-panics:
+		assert.Equal(t, "job failed", p.Value)
+		assert.Equal(t, "/jobs", p.Origin)
+		assert.NotEmpty(t, p.Stack)
+		assert.Equal(t, "panic: job failed", p.Error())
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+	assert.Contains(t, buf.String(), `"request_id":"req-job"`)
+	assert.Contains(t, buf.String(), `"message":"goroutine panic recovered"`)
+
+	safego.Go(context.Background(), func(ctx context.Context) { panic("background failure") })
+	select {
+	case p := <-panics:
+		assert.Equal(t, safego.Background, p.Origin)
+	case <-time.After(time.Second):
+		t.Fatal("panic not reported")
+	}
+}
@@ -0,0 +1,98 @@
+package unit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/pipeline-arch/app/pkg/tracecontext"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceContextParse(t *testing.T) {
+	tc, err := tracecontext.Parse("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "vendor=opaque")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", tc.ParentID.String())
+	assert.True(t, tc.SpanID.IsValid())
+	assert.NotEqual(t, tc.ParentID, tc.SpanID)
+	assert.True(t, tc.Sampled())
+	assert.Equal(t, "vendor=opaque", tc.State)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+tc.SpanID.String()+"-01", tc.TraceParent())
+
+	// Later versions may append fields
+	_, err = tracecontext.Parse("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "")
+	assert.NoError(t, err)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7-01",
+	} {
+		_, err := tracecontext.Parse(invalid, "")
+		assert.ErrorIs(t, err, tracecontext.ErrInvalidTraceParent, invalid)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	var buf bytes.Buffer
+	base := zerolog.New(&buf)
+
+	var seen struct {
+		requestID string
+		chiID     string
+		tc        tracecontext.TraceContext
+	}
+	handler := api.RequestContext(&base)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.requestID = tracecontext.RequestID(r.Context())
+		seen.chiID = middleware.GetReqID(r.Context())
+		seen.tc, _ = tracecontext.FromContext(r.Context())
+		logger.FromContext(r.Context()).Info().Msg("handled")
+	}))
+
+	t.Run("continues the caller's trace", func(t *testing.T) {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set("X-Request-ID", "req-123")
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "req-123", seen.requestID)
+		assert.Equal(t, "req-123", seen.chiID)
+		assert.Equal(t, "req-123", rec.Header().Get("X-Request-ID"))
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", seen.tc.TraceID.String())
+		assert.Equal(t, seen.tc.TraceParent(), rec.Header().Get("traceparent"))
+
+		line := buf.String()
+		assert.Contains(t, line, `"request_id":"req-123"`)
+		assert.Contains(t, line, `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`)
+		assert.Contains(t, line, `"span_id":"`+seen.tc.SpanID.String()+`"`)
+	})
+
+	t.Run("generates IDs for new requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-ID", "bad id\nwith newline")
+		req.Header.Set("traceparent", "garbage")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.NotEmpty(t, seen.requestID)
+		assert.False(t, strings.Contains(seen.requestID, " "))
+		assert.Equal(t, seen.requestID, rec.Header().Get("X-Request-ID"))
+		assert.True(t, seen.tc.TraceID.IsValid())
+		assert.False(t, seen.tc.ParentID.IsValid())
+		assert.Equal(t, seen.tc.TraceParent(), rec.Header().Get("traceparent"))
+	})
+}
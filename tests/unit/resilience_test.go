@@ -0,0 +1,183 @@
+package unit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pipeline-arch/app/pkg/resilience"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errDependency = fmt.Errorf("dependency failed")
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	var transitions []string
+	breaker := resilience.NewBreaker("db", resilience.BreakerOptions{
+		MinRequests:      4,
+		FailureRatio:     0.5,
+		OpenTimeout:      20 * time.Millisecond,
+		HalfOpenRequests: 2,
+		OnStateChange: func(name string, from, to resilience.State) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+	fail := func(ctx context.Context) error { return errDependency }
+	succeed := func(ctx context.Context) error { return nil }
+
+	require.NoError(t, breaker.Execute(ctx, succeed))
+	require.NoError(t, breaker.Execute(ctx, succeed))
+	require.ErrorIs(t, breaker.Execute(ctx, fail), errDependency)
+	assert.Equal(t, resilience.StateClosed, breaker.State(), "needs MinRequests calls")
+	require.ErrorIs(t, breaker.Execute(ctx, fail), errDependency)
+	assert.Equal(t, resilience.StateOpen, breaker.State())
+
+	called := false
+	err := breaker.Execute(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, resilience.ErrBreakerOpen)
+	assert.False(t, called, "open breakers fail fast")
+
+	// A failed trial call opens the breaker again
+	time.Sleep(30 * time.Millisecond)
+	require.ErrorIs(t, breaker.Execute(ctx, fail), errDependency)
+	assert.Equal(t, resilience.StateOpen, breaker.State())
+
+	// Enough successful trial calls close it
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, breaker.Execute(ctx, succeed))
+	assert.Equal(t, resilience.StateHalfOpen, breaker.State())
+	require.NoError(t, breaker.Execute(ctx, succeed))
+	assert.Equal(t, resilience.StateClosed, breaker.State())
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half_open",
+		"half_open->open",
+		"open->half_open",
+		"half_open->closed",
+	}, transitions)
+}
+
+func TestCircuitBreakerSlowCalls(t *testing.T) {
+	breaker := resilience.NewBreaker("slow", resilience.BreakerOptions{
+		MinRequests:      2,
+		SlowCallDuration: 5 * time.Millisecond,
+		SlowCallRatio:    1,
+	})
+	slow := func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	require.NoError(t, breaker.Execute(context.Background(), slow))
+	require.NoError(t, breaker.Execute(context.Background(), slow))
+	assert.Equal(t, resilience.StateOpen, breaker.State())
+}
+
+func TestRetryPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries until success", func(t *testing.T) {
+		calls := 0
+		policy := &resilience.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+		err := policy.Do(ctx, func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return errDependency
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("does not retry open breakers", func(t *testing.T) {
+		calls := 0
+		policy := &resilience.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+		err := policy.Do(ctx, func(ctx context.Context) error {
+			calls++
+			return resilience.ErrBreakerOpen
+		})
+		assert.ErrorIs(t, err, resilience.ErrBreakerOpen)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("budget caps retries", func(t *testing.T) {
+		calls := 0
+		policy := &resilience.RetryPolicy{
+			MaxAttempts: 5,
+			BaseDelay:   time.Millisecond,
+			Budget:      resilience.NewRetryBudget(0, 0),
+		}
+		err := policy.Do(ctx, func(ctx context.Context) error {
+			calls++
+			return errDependency
+		})
+		assert.ErrorIs(t, err, errDependency)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestBulkhead(t *testing.T) {
+	ctx := context.Background()
+	bulkhead := resilience.NewBulkhead(1, 0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- bulkhead.Execute(ctx, func(ctx context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	err := bulkhead.Execute(ctx, func(ctx context.Context) error { return nil })
+	assert.ErrorIs(t, err, resilience.ErrBulkheadFull)
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.NoError(t, bulkhead.Execute(ctx, func(ctx context.Context) error { return nil }))
+}
+
+func TestResilientTransport(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	client := resilience.NewClient(server.Client(), &resilience.Policy{
+		Retry: &resilience.RetryPolicy{BaseDelay: time.Millisecond},
+	})
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// POST is not retried and the failing response is returned as is
+	calls.Store(0)
+	resp, err = client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,205 @@
+package unit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevocationStores(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	stores := map[string]repository.RevocationStore{
+		"memory": repository.NewInMemoryRevocationStore(),
+		"redis":  repository.NewRedisRevocationStore(client, "test:"),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			issued := time.Now().Add(-time.Minute)
+
+			revoked, err := store.IsRevoked(ctx, "jti-1", "user-1", issued)
+			require.NoError(t, err)
+			assert.False(t, revoked)
+
+			require.NoError(t, store.RevokeToken(ctx, "jti-1", time.Now().Add(time.Hour)))
+			revoked, err = store.IsRevoked(ctx, "jti-1", "user-1", issued)
+			require.NoError(t, err)
+			assert.True(t, revoked)
+
+			revoked, err = store.IsRevoked(ctx, "jti-2", "user-1", issued)
+			require.NoError(t, err)
+			assert.False(t, revoked)
+
+			require.NoError(t, store.RevokeUser(ctx, "user-1", time.Now(), time.Hour))
+			revoked, err = store.IsRevoked(ctx, "jti-2", "user-1", issued)
+			require.NoError(t, err)
+			assert.True(t, revoked, "tokens issued before the cutoff are revoked")
+
+			revoked, err = store.IsRevoked(ctx, "jti-3", "user-1", time.Now().Add(time.Minute))
+			require.NoError(t, err)
+			assert.False(t, revoked, "tokens issued after the cutoff are valid")
+
+			revoked, err = store.IsRevoked(ctx, "jti-2", "user-2", issued)
+			require.NoError(t, err)
+			assert.False(t, revoked)
+
+			cutoff := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+			require.NoError(t, store.RevokeUser(ctx, "user-3", cutoff, time.Hour))
+			revoked, err = store.IsRevoked(ctx, "jti-4", "user-3", cutoff.Add(-time.Millisecond))
+			require.NoError(t, err)
+			assert.True(t, revoked, "tokens issued earlier in the cutoff's second are revoked")
+
+			revoked, err = store.IsRevoked(ctx, "jti-5", "user-3", cutoff.Add(time.Millisecond))
+			require.NoError(t, err)
+			assert.False(t, revoked, "tokens issued later in the cutoff's second are valid")
+		})
+	}
+
+	t.Run("redis cutoffs in seconds", func(t *testing.T) {
+		ctx := context.Background()
+		store := repository.NewRedisRevocationStore(client, "legacy:")
+		cutoff := time.Now().Truncate(time.Second)
+		require.NoError(t, client.Set(ctx, "legacy:revoked:user:user-1", cutoff.Unix(), time.Hour).Err())
+
+		revoked, err := store.IsRevoked(ctx, "jti-1", "user-1", cutoff)
+		require.NoError(t, err)
+		assert.True(t, revoked, "tokens issued in the cutoff's second stay revoked")
+
+		revoked, err = store.IsRevoked(ctx, "jti-2", "user-1", cutoff.Add(time.Second))
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	})
+}
+
+func TestSessionRevocation(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New("debug").Logger
+
+	users := repository.NewInMemoryUserRepository()
+	refreshTokens := repository.NewInMemoryRefreshTokenRepository()
+	sessions := services.NewSessionService(repository.NewInMemoryRevocationStore(), refreshTokens, 15*time.Minute, log, nil)
+
+	userSvc := services.NewUserService(users, log, nil)
+	userSvc.SetSessionRevoker(sessions)
+
+	authSvc := services.NewAuthService(
+		users,
+		refreshTokens,
+		auth.NewHS256Signer([]byte("test-secret")),
+		services.AuthOptions{AccessTokenTTL: 15 * time.Minute, RefreshTokenTTL: time.Hour},
+		log,
+		nil,
+	)
+
+	router := chi.NewRouter()
+	router.Use(api.Authenticate(services.NewAPIKeyService(repository.NewInMemoryAPIKeyRepository(), log, nil), authSvc, log))
+	router.Use(api.CheckRevocation(sessions, log))
+	router.With(api.RequireAuth).Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	router.With(api.RequireScope(models.ScopeAdmin)).
+		Delete("/admin/users/{id}/sessions", api.NewSessionHandlers(sessions, log).RevokeUserSessions)
+
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	createUser := func(email, role string) *models.UserResponse {
+		user, err := userSvc.CreateUser(ctx, &models.UserCreateRequest{
+			Email:    email,
+			Name:     "Session User",
+			Role:     role,
+			Password: "correct horse battery staple",
+		})
+		require.NoError(t, err)
+		return user
+	}
+
+	// Tokens carry second resolution, so make sure they predate revocations
+	login := func(email string) *models.TokenResponse {
+		tokens, err := authSvc.Login(ctx, email, "correct horse battery staple")
+		require.NoError(t, err)
+		time.Sleep(1100 * time.Millisecond)
+		return tokens
+	}
+
+	t.Run("deactivating a user revokes tokens", func(t *testing.T) {
+		user := createUser("deactivate@example.com", "user")
+		tokens := login(user.Email)
+		require.Equal(t, http.StatusOK, get(tokens.AccessToken))
+
+		inactive := false
+		_, err := userSvc.UpdateUser(ctx, user.ID, &models.UserUpdateRequest{Active: &inactive})
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, get(tokens.AccessToken))
+		_, err = authSvc.Refresh(ctx, tokens.RefreshToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("changing role revokes tokens", func(t *testing.T) {
+		user := createUser("role@example.com", "user")
+		tokens := login(user.Email)
+
+		role := "viewer"
+		_, err := userSvc.UpdateUser(ctx, user.ID, &models.UserUpdateRequest{Role: &role})
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusUnauthorized, get(tokens.AccessToken))
+	})
+
+	t.Run("renaming a user keeps tokens", func(t *testing.T) {
+		user := createUser("rename@example.com", "user")
+		tokens := login(user.Email)
+
+		name := "Renamed User"
+		_, err := userSvc.UpdateUser(ctx, user.ID, &models.UserUpdateRequest{Name: &name})
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, get(tokens.AccessToken))
+	})
+
+	t.Run("admin can kill all sessions", func(t *testing.T) {
+		admin := createUser("admin-sessions@example.com", "admin")
+		target := createUser("target@example.com", "user")
+		adminTokens := login(admin.Email)
+		targetTokens := login(target.Email)
+
+		req := httptest.NewRequest(http.MethodDelete, "/admin/users/"+target.ID+"/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+targetTokens.AccessToken)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		req = httptest.NewRequest(http.MethodDelete, "/admin/users/"+target.ID+"/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+adminTokens.AccessToken)
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		assert.Equal(t, http.StatusUnauthorized, get(targetTokens.AccessToken))
+		assert.Equal(t, http.StatusOK, get(adminTokens.AccessToken))
+	})
+}
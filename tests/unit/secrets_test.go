@@ -0,0 +1,244 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func writeSecretFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestConfigSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DATABASE_URL_FILE", writeSecretFile(t, dir, "database-url", "postgres://app:pw@db/app\n"))
+	t.Setenv("JWT_SECRET", "from-env")
+
+	cfg, err := config.LoadWith(config.Options{})
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "postgres://app:pw@db/app", cfg.DatabaseURL, "the trailing newline is trimmed")
+	assert.Equal(t, "from-env", cfg.JWTSecret)
+	assert.Equal(t, config.Source{Layer: config.LayerEnv, Name: "DATABASE_URL_FILE"}, cfg.Sources()["database_url"])
+
+	t.Run("rejects a variable set both ways", func(t *testing.T) {
+		t.Setenv("JWT_SECRET_FILE", writeSecretFile(t, dir, "jwt-secret", "from-file"))
+		cfg, err := config.LoadWith(config.Options{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"JWT_SECRET_FILE"}, validationKeys(t, cfg.Validate()))
+	})
+
+	t.Run("reports unreadable files", func(t *testing.T) {
+		t.Setenv("REDIS_URL_FILE", filepath.Join(dir, "missing"))
+		cfg, err := config.LoadWith(config.Options{})
+		require.NoError(t, err)
+		assert.Contains(t, validationKeys(t, cfg.Validate()), "REDIS_URL_FILE")
+	})
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir := t.TempDir()
+	writeSecretFile(t, dir, "jwt-secret", "file-secret\n")
+	t.Setenv("JWT_SECRET", "env-secret")
+	t.Setenv("REDIS_URL", "redis://cache:6379")
+
+	provider := &config.FileSecretProvider{Dir: dir}
+	cfg, err := config.LoadWith(config.Options{Secrets: provider})
+	require.NoError(t, err)
+	assert.Equal(t, "file-secret", cfg.JWTSecret, "the provider overrides the environment")
+	assert.Equal(t, "redis://cache:6379", cfg.RedisURL, "secrets the provider lacks come from the environment")
+	assert.Equal(t, config.Source{Layer: config.LayerSecret, Name: "file:" + dir}, cfg.Sources()["jwt_secret"])
+	assert.Equal(t, config.LayerEnv, cfg.Sources()["redis_url"].Layer)
+}
+
+// vaultStandIn serves a KV version 2 secret at secret/pipeline-arch for
+// the token "root"
+func vaultStandIn(t *testing.T, data map[string]interface{}) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/secret/data/pipeline-arch" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     data,
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVaultSecretProvider(t *testing.T) {
+	srv := vaultStandIn(t, map[string]interface{}{
+		"database-url": "postgres://app:vault@db/app",
+		"jwt-secret":   "vault-secret",
+		"redis-url":    42,
+	})
+	provider := &config.VaultSecretProvider{Addr: srv.URL, Token: "root", Mount: "secret", Path: "pipeline-arch"}
+	assert.Equal(t, "vault:secret/pipeline-arch", provider.Name())
+
+	values, err := provider.Secrets(context.Background(), []string{"DATABASE_URL", "JWT_SECRET", "REDIS_URL", "OIDC_CLIENT_SECRET"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DATABASE_URL": "postgres://app:vault@db/app",
+		"JWT_SECRET":   "vault-secret",
+	}, values)
+
+	t.Run("loads the configuration", func(t *testing.T) {
+		t.Setenv("SECRETS_PROVIDER", "vault")
+		t.Setenv("VAULT_ADDR", srv.URL)
+		t.Setenv("VAULT_TOKEN", "root")
+		cfg, err := config.LoadWith(config.Options{})
+		require.NoError(t, err)
+		require.NoError(t, cfg.Validate())
+
+		secrets, err := config.NewSecretProvider(cfg)
+		require.NoError(t, err)
+		cfg, err = config.LoadWith(config.Options{Secrets: secrets})
+		require.NoError(t, err)
+		assert.Equal(t, "vault-secret", cfg.JWTSecret)
+		assert.Equal(t, "root", cfg.VaultToken, "secrets Vault does not hold keep their environment value")
+	})
+
+	t.Run("a missing secret is empty", func(t *testing.T) {
+		missing := &config.VaultSecretProvider{Addr: srv.URL, Token: "root", Mount: "secret", Path: "other"}
+		values, err := missing.Secrets(context.Background(), []string{"JWT_SECRET"})
+		require.NoError(t, err)
+		assert.Empty(t, values)
+	})
+
+	t.Run("errors stop loading", func(t *testing.T) {
+		denied := &config.VaultSecretProvider{Addr: srv.URL, Token: "wrong", Mount: "secret", Path: "pipeline-arch"}
+		_, err := config.LoadWith(config.Options{Secrets: denied})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "403")
+	})
+}
+
+func TestConfigRedacted(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://app:hunter2@db/app")
+	t.Setenv("JWT_SECRET", "hunter2")
+	cfg, err := config.LoadWith(config.Options{})
+	require.NoError(t, err)
+
+	redacted := cfg.Redacted()
+	assert.Equal(t, config.RedactedValue, redacted.DatabaseURL)
+	assert.Equal(t, config.RedactedValue, redacted.JWTSecret)
+	assert.Empty(t, redacted.RedisURL, "unset secrets stay empty")
+	assert.Equal(t, cfg.Port, redacted.Port)
+	assert.Equal(t, "hunter2", cfg.JWTSecret, "the configuration itself is not modified")
+
+	data, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, config.RedactedValue, decoded["jwt_secret"])
+	assert.Equal(t, "30s", decoded["request_timeout"])
+	assert.EqualValues(t, 8080, decoded["port"])
+
+	out, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "jwt_secret: '[REDACTED]'")
+
+	for name, text := range map[string]string{
+		"json":   string(data),
+		"yaml":   string(out),
+		"%v":     fmt.Sprintf("%v", cfg),
+		"%+v":    fmt.Sprintf("%+v", *cfg),
+		"%#v":    fmt.Sprintf("%#v", cfg),
+		"String": cfg.String(),
+	} {
+		assert.False(t, strings.Contains(text, "hunter2"), "%s output leaks a secret", name)
+	}
+}
+
+func TestConfigEffective(t *testing.T) {
+	t.Setenv("JWT_SECRET", "hunter2")
+	t.Setenv("LOG_LEVEL", "debug")
+	cfg, err := config.LoadWith(config.Options{Args: []string{"--port=9000"}})
+	require.NoError(t, err)
+
+	effective := cfg.Effective()
+	assert.Equal(t, config.Value{Value: 9000, Env: "APP_PORT", Source: config.Source{Layer: config.LayerFlag, Name: "--port"}}, effective["port"])
+	assert.Equal(t, config.Source{Layer: config.LayerEnv, Name: "LOG_LEVEL"}, effective["log_level"].Source)
+	assert.Equal(t, "30s", effective["request_timeout"].Value)
+	assert.Equal(t, config.Value{Value: config.RedactedValue, Env: "JWT_SECRET", Source: config.Source{Layer: config.LayerEnv, Name: "JWT_SECRET"}, Secret: true}, effective["jwt_secret"])
+
+	// A printed configuration reads back the same
+	out, err := yaml.Marshal(effective)
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "hunter2")
+	var printed config.Effective
+	require.NoError(t, yaml.Unmarshal(out, &printed))
+	assert.Empty(t, config.DiffEffective(effective, printed))
+}
+
+func TestDiffEffective(t *testing.T) {
+	staging, err := config.LoadWith(config.Options{})
+	require.NoError(t, err)
+	t.Setenv("ENVIRONMENT", "production")
+	t.Setenv("JWT_SECRET", "hunter2")
+	production, err := config.LoadWith(config.Options{})
+	require.NoError(t, err)
+
+	a, b := staging.Effective(), production.Effective()
+	delete(b, "host")
+	var keys []string
+	for _, diff := range config.DiffEffective(a, b) {
+		keys = append(keys, diff.Key)
+		if diff.Key == "host" {
+			assert.NotNil(t, diff.A)
+			assert.Nil(t, diff.B)
+		}
+	}
+	assert.Equal(t, []string{
+		"cors_allowed_origins",
+		"environment",
+		"host",
+		"jwt_secret",
+		"security_csp_report_only",
+		"security_hsts_max_age",
+	}, keys)
+}
+
+func TestConfigHandlers(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://app:hunter2@db/app")
+	cfg, err := config.LoadWith(config.Options{})
+	require.NoError(t, err)
+	h := api.NewConfigHandlers(func() *config.Config { return cfg })
+
+	rec := httptest.NewRecorder()
+	h.GetConfig(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/config", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.NotContains(t, rec.Body.String(), "hunter2")
+
+	var body map[string]config.Value
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, config.RedactedValue, body["database_url"].Value)
+	assert.True(t, body["database_url"].Secret)
+	assert.Equal(t, config.LayerDefault, body["port"].Source.Layer)
+}
@@ -0,0 +1,151 @@
+package unit
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	pageCSP, err := api.ParseCSP("default-src 'self'; script-src 'self'; img-src 'self' data:")
+	require.NoError(t, err)
+	apiCSP, err := api.ParseCSP("default-src 'none'")
+	require.NoError(t, err)
+
+	var nonce string
+	handler := api.SecurityHeaders([]api.SecurityPolicy{
+		{
+			HSTSMaxAge:                365 * 24 * time.Hour,
+			HSTSIncludeSubdomains:     true,
+			CSP:                       pageCSP,
+			CSPReportURI:              "/csp-report",
+			PermissionsPolicy:         "camera=()",
+			CrossOriginOpenerPolicy:   "same-origin",
+			CrossOriginEmbedderPolicy: "require-corp",
+		},
+		{PathPrefix: "/api", CSP: apiCSP, CSPReportOnly: true},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = api.CSPNonce(r.Context())
+	}))
+
+	serve := func(req *http.Request) http.Header {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header()
+	}
+
+	t.Run("sets page policy", func(t *testing.T) {
+		header := serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, "nosniff", header.Get("X-Content-Type-Options"))
+		assert.Equal(t, "DENY", header.Get("X-Frame-Options"))
+		assert.Equal(t, "camera=()", header.Get("Permissions-Policy"))
+		assert.Equal(t, "same-origin", header.Get("Cross-Origin-Opener-Policy"))
+		assert.Equal(t, "require-corp", header.Get("Cross-Origin-Embedder-Policy"))
+		assert.Empty(t, header.Get("Strict-Transport-Security"), "no HSTS over plain HTTP")
+
+		require.NotEmpty(t, nonce)
+		assert.Equal(t,
+			"default-src 'self'; script-src 'self' 'nonce-"+nonce+"'; img-src 'self' data:; report-uri /csp-report",
+			header.Get("Content-Security-Policy"))
+	})
+
+	t.Run("uses a new nonce per request", func(t *testing.T) {
+		serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		first := nonce
+		serve(httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.NotEqual(t, first, nonce)
+	})
+
+	t.Run("sends HSTS over TLS", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		assert.Equal(t, "max-age=31536000; includeSubDomains", serve(req).Get("Strict-Transport-Security"))
+
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		assert.Equal(t, "max-age=31536000; includeSubDomains", serve(req).Get("Strict-Transport-Security"))
+	})
+
+	t.Run("applies the longest prefix", func(t *testing.T) {
+		header := serve(httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+		assert.Empty(t, header.Get("Content-Security-Policy"))
+		assert.Equal(t, "default-src 'none'", header.Get("Content-Security-Policy-Report-Only"))
+		assert.Empty(t, header.Get("Permissions-Policy"))
+		assert.Empty(t, nonce, "no nonce without script-src or style-src")
+		assert.Equal(t, "nosniff", header.Get("X-Content-Type-Options"))
+
+		header = serve(httptest.NewRequest(http.MethodGet, "/apix", nil))
+		assert.NotEmpty(t, header.Get("Content-Security-Policy"))
+	})
+}
+
+func TestParseCSP(t *testing.T) {
+	directives, err := api.ParseCSP(" Default-Src 'self' ; ; object-src 'none';upgrade-insecure-requests")
+	require.NoError(t, err)
+	assert.Equal(t, []api.CSPDirective{
+		{Name: "default-src", Values: []string{"'self'"}},
+		{Name: "object-src", Values: []string{"'none'"}},
+		{Name: "upgrade-insecure-requests", Values: []string{}},
+	}, directives)
+
+	_, err = api.ParseCSP("default-src 'self'; default-src 'none'")
+	assert.Error(t, err)
+	_, err = api.ParseCSP("script_src 'self'")
+	assert.Error(t, err)
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	var directives []string
+	handler := api.CSPReportHandler(&log, func(directive string) { directives = append(directives, directive) })
+
+	post := func(contentType, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("records report-uri reports", func(t *testing.T) {
+		directives = nil
+		code := post("application/csp-report", `{"csp-report": {
+			"document-uri": "https://example.com/",
+			"blocked-uri": "https://evil.example.com/x.js",
+			"violated-directive": "script-src 'self'"
+		}}`)
+		assert.Equal(t, http.StatusNoContent, code)
+		assert.Equal(t, []string{"script-src"}, directives)
+		assert.Contains(t, buf.String(), `"message":"CSP violation reported"`)
+		assert.Contains(t, buf.String(), `"blocked_uri":"https://evil.example.com/x.js"`)
+	})
+
+	t.Run("records Reporting API reports", func(t *testing.T) {
+		directives = nil
+		code := post("application/reports+json", `[
+			{"type": "csp-violation", "body": {"documentURL": "https://example.com/", "effectiveDirective": "img-src", "disposition": "report"}},
+			{"type": "deprecation", "body": {}},
+			{"type": "csp-violation", "body": {"effectiveDirective": "made-up-directive"}}
+		]`)
+		assert.Equal(t, http.StatusNoContent, code)
+		assert.Equal(t, []string{"img-src", "other"}, directives)
+	})
+
+	t.Run("rejects malformed reports", func(t *testing.T) {
+		directives = nil
+		assert.Equal(t, http.StatusBadRequest, post("application/csp-report", `not json`))
+		assert.Equal(t, http.StatusBadRequest, post("application/csp-report", `{}`))
+		assert.Equal(t, http.StatusBadRequest, post("application/reports+json", `{}`))
+		assert.Empty(t, directives)
+	})
+}
@@ -9,7 +9,6 @@ import (
 	"github.com/pipeline-arch/app/internal/services"
 	"github.com/pipeline-arch/app/pkg/errors"
 	"github.com/pipeline-arch/app/pkg/logger"
-	"github.com/pipeline-arch/app/pkg/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -26,10 +25,7 @@ func (m *MockMetrics) IncRequest(path string) {
 
 func TestUserService(t *testing.T) {
 	ctx := context.Background()
-	repo := repository.NewInMemoryUserRepository()
-	log := logger.New("debug")
-	_ = metrics.New("pipeline-arch-test", 9091)
-	svc := services.NewUserService(repo, log, nil)
+	log := logger.New("debug").Logger
 
 	t.Run("CreateUser", func(t *testing.T) {
 		repo := repository.NewInMemoryUserRepository()
@@ -158,7 +154,7 @@ func TestUserService(t *testing.T) {
 			Name: &name,
 		}
 
-		err := svc.UpdateUser(ctx, "non-existent-id", updateReq)
+		_, err := svc.UpdateUser(ctx, "non-existent-id", updateReq)
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "not found")
 	})
@@ -0,0 +1,112 @@
+package unit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/pkg/ipacl"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/pipeline-arch/app/pkg/resilience"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimit(t *testing.T) {
+	var changes int
+	limit := resilience.NewAdaptiveLimit(resilience.AdaptiveLimitOptions{
+		InitialLimit: 20,
+		MinLimit:     5,
+		MaxLimit:     100,
+		OnChange:     func(int) { changes++ },
+	})
+	assert.Equal(t, 20, limit.Limit())
+
+	// Steady latency under load lets the limit grow
+	for i := 0; i < 50; i++ {
+		limit.Observe(10*time.Millisecond, limit.Limit(), false)
+	}
+	grown := limit.Limit()
+	assert.Greater(t, grown, 20)
+	assert.LessOrEqual(t, grown, 100)
+	assert.Greater(t, changes, 0)
+
+	// A mostly idle server does not grow its limit
+	for i := 0; i < 50; i++ {
+		limit.Observe(10*time.Millisecond, 1, false)
+	}
+	assert.Equal(t, grown, limit.Limit())
+
+	// Latency well above the long-term average shrinks it
+	for i := 0; i < 20; i++ {
+		limit.Observe(100*time.Millisecond, limit.Limit(), false)
+	}
+	shrunk := limit.Limit()
+	assert.Less(t, shrunk, grown)
+
+	// Drops cut it down to the minimum at most
+	for i := 0; i < 100; i++ {
+		limit.Observe(0, shrunk, true)
+	}
+	assert.Equal(t, 5, limit.Limit())
+}
+
+func TestLoadShedder(t *testing.T) {
+	log := logger.New("debug").Logger
+	limit := resilience.NewAdaptiveLimit(resilience.AdaptiveLimitOptions{InitialLimit: 10, MinLimit: 10})
+
+	acl, err := ipacl.New(ipacl.Config{
+		TrustedProxies: []string{"10.0.0.1"},
+		Groups:         map[string]ipacl.Rule{api.IPGroupPriority: {Allow: []string{"10.8.0.0/16"}}},
+	}, "")
+	require.NoError(t, err)
+
+	var inFlight atomic.Int64
+	shedder := api.LoadShedder(limit, func() int { return int(inFlight.Load()) }, []string{"/healthz"}, acl, log)
+	handler := shedder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.8.1.2:4000"
+		if header.Get("X-Forwarded-For") != "" {
+			req.RemoteAddr = "10.0.0.1:4000"
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	inFlight.Store(10)
+	assert.Equal(t, http.StatusOK, serve("/api/v1/users", nil).Code)
+
+	inFlight.Store(11)
+	rec := serve("/api/v1/users", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve("/healthz", nil).Code)
+	assert.Equal(t, http.StatusOK, serve("/api/v1/users", http.Header{api.PriorityHeader: {"high"}}).Code)
+
+	t.Run("ignores priority from untrusted clients", func(t *testing.T) {
+		header := http.Header{api.PriorityHeader: {"high"}, "X-Forwarded-For": {"203.0.113.9"}}
+		assert.Equal(t, http.StatusServiceUnavailable, serve("/api/v1/users", header).Code)
+
+		header.Set("X-Forwarded-For", "10.8.3.4")
+		assert.Equal(t, http.StatusOK, serve("/api/v1/users", header).Code)
+
+		withoutACL := api.LoadShedder(limit, func() int { return int(inFlight.Load()) }, nil, nil, log)(handler)
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set(api.PriorityHeader, "high")
+		rec := httptest.NewRecorder()
+		withoutACL.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+}
@@ -0,0 +1,216 @@
+package unit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/auth"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSigningKeys(t *testing.T, retention time.Duration, fallback *auth.HS256Signer) (*services.SigningKeyService, *auth.KeySet) {
+	t.Helper()
+	log := logger.New("debug").Logger
+	keys := auth.NewKeySet(fallback)
+	svc := services.NewSigningKeyService(
+		repository.NewInMemorySigningKeyRepository(),
+		keys,
+		services.SigningKeyOptions{RetentionPeriod: retention},
+		log,
+		nil,
+	)
+	require.NoError(t, svc.Init(context.Background()))
+	return svc, keys
+}
+
+func testClaims() *auth.Claims {
+	now := time.Now()
+	return &auth.Claims{
+		Subject:   "user-1",
+		ID:        "jti-1",
+		IssuedAt:  auth.NumericDate(now),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}
+}
+
+func countStatus(keys *models.SigningKeyListResponse, status string) int {
+	n := 0
+	for _, key := range keys.Keys {
+		if key.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("init creates active and next keys", func(t *testing.T) {
+		svc, keys := setupSigningKeys(t, time.Hour, nil)
+
+		list, err := svc.ListKeys(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, countStatus(list, models.SigningKeyActive))
+		assert.Equal(t, 1, countStatus(list, models.SigningKeyNext))
+		assert.Len(t, keys.JWKS().Keys, 2)
+
+		token, err := keys.Sign(testClaims())
+		require.NoError(t, err)
+		claims, err := keys.Verify(token)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject)
+	})
+
+	t.Run("rotation promotes next key and keeps previous verifying", func(t *testing.T) {
+		svc, keys := setupSigningKeys(t, time.Hour, nil)
+		before, err := svc.ListKeys(ctx)
+		require.NoError(t, err)
+		var nextID string
+		for _, key := range before.Keys {
+			if key.Status == models.SigningKeyNext {
+				nextID = key.ID
+			}
+		}
+
+		oldToken, err := keys.Sign(testClaims())
+		require.NoError(t, err)
+
+		after, err := svc.Rotate(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, nextID, keys.ActiveKeyID())
+		assert.Equal(t, 1, countStatus(after, models.SigningKeyPrevious))
+		assert.Len(t, keys.JWKS().Keys, 3)
+
+		_, err = keys.Verify(oldToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("retired keys no longer verify", func(t *testing.T) {
+		svc, keys := setupSigningKeys(t, 0, nil)
+
+		oldToken, err := keys.Sign(testClaims())
+		require.NoError(t, err)
+
+		after, err := svc.Rotate(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, countStatus(after, models.SigningKeyRetired))
+		assert.Len(t, keys.JWKS().Keys, 2)
+
+		_, err = keys.Verify(oldToken)
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("legacy HS256 tokens still verify", func(t *testing.T) {
+		legacy := auth.NewHS256Signer([]byte("legacy-secret"))
+		_, keys := setupSigningKeys(t, time.Hour, legacy)
+
+		token, err := legacy.Sign(testClaims())
+		require.NoError(t, err)
+		_, err = keys.Verify(token)
+		assert.NoError(t, err)
+
+		_, withoutFallback := setupSigningKeys(t, time.Hour, nil)
+		_, err = withoutFallback.Verify(token)
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+
+	t.Run("a rotated legacy secret replaces the old one", func(t *testing.T) {
+		_, keys := setupSigningKeys(t, time.Hour, auth.NewHS256Signer([]byte("old-secret")))
+		oldToken, err := auth.NewHS256Signer([]byte("old-secret")).Sign(testClaims())
+		require.NoError(t, err)
+
+		rotated := auth.NewHS256Signer([]byte("new-secret"))
+		keys.SetFallback(rotated)
+		newToken, err := rotated.Sign(testClaims())
+		require.NoError(t, err)
+		_, err = keys.Verify(newToken)
+		assert.NoError(t, err)
+		_, err = keys.Verify(oldToken)
+		assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	})
+}
+
+// slowSigningKeyRepository returns listed keys slowly so that concurrent
+// rotations work from the same, soon stale, list
+type slowSigningKeyRepository struct {
+	*repository.InMemorySigningKeyRepository
+}
+
+func (r slowSigningKeyRepository) List(ctx context.Context) ([]*models.SigningKey, error) {
+	keys, err := r.InMemorySigningKeyRepository.List(ctx)
+	time.Sleep(10 * time.Millisecond)
+	return keys, err
+}
+
+func TestSigningKeyReplicas(t *testing.T) {
+	ctx := context.Background()
+	log := logger.New("debug").Logger
+	repo := slowSigningKeyRepository{repository.NewInMemorySigningKeyRepository()}
+
+	// Replicas sharing storage start and rotate at the same time
+	replicas := make([]*services.SigningKeyService, 4)
+	for i := range replicas {
+		replicas[i] = services.NewSigningKeyService(repo, auth.NewKeySet(nil), services.SigningKeyOptions{RetentionPeriod: time.Hour}, log, nil)
+	}
+	var wg sync.WaitGroup
+	for _, svc := range replicas {
+		wg.Add(1)
+		go func(svc *services.SigningKeyService) {
+			defer wg.Done()
+			assert.NoError(t, svc.Init(ctx))
+			_, err := svc.Rotate(ctx)
+			assert.NoError(t, err)
+		}(svc)
+	}
+	wg.Wait()
+
+	list, err := replicas[0].ListKeys(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, countStatus(list, models.SigningKeyActive))
+	assert.Equal(t, 1, countStatus(list, models.SigningKeyNext))
+	assert.Equal(t, len(replicas), countStatus(list, models.SigningKeyPrevious), "each rotation retires the key the previous one activated")
+	assert.Len(t, list.Keys, len(replicas)+2, "only the first replica creates initial keys")
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	svc, keys := setupSigningKeys(t, time.Hour, nil)
+	log := logger.New("debug").Logger
+	handlers := api.NewSigningKeyHandlers(svc, log)
+
+	rec := httptest.NewRecorder()
+	handlers.JWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Cache-Control"), "max-age=")
+
+	var set auth.JSONWebKeySet
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 2)
+
+	for _, jwk := range set.Keys {
+		assert.Equal(t, "ES256", jwk.Algorithm)
+		assert.Empty(t, jwk.N)
+	}
+
+	// A token signed by the active key verifies against the published set
+	server := httptest.NewServer(http.HandlerFunc(handlers.JWKS))
+	defer server.Close()
+
+	token, err := keys.Sign(testClaims())
+	require.NoError(t, err)
+
+	var claims auth.Claims
+	require.NoError(t, auth.NewRemoteKeySet(server.URL, server.Client()).Verify(context.Background(), token, &claims))
+	assert.Equal(t, "user-1", claims.Subject)
+}
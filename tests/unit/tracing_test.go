@@ -0,0 +1,202 @@
+package unit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pipeline-arch/app/internal/api"
+	"github.com/pipeline-arch/app/internal/models"
+	"github.com/pipeline-arch/app/internal/repository"
+	"github.com/pipeline-arch/app/internal/services"
+	"github.com/pipeline-arch/app/pkg/logger"
+	"github.com/pipeline-arch/app/pkg/tracing"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector stands in for an OpenTelemetry collector receiving OTLP/HTTP
+type collector struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected export", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	c.mu.Unlock()
+
+	resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func (c *collector) span(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func spanAttribute(span *tracepb.Span, key string) string {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value.GetStringValue()
+		}
+	}
+	return ""
+}
+
+// setupTracing exports to a fresh collector stand-in until the returned
+// flush function is called
+func setupTracing(t *testing.T, ratio float64) (*collector, func()) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{
+		ServiceName: "pipeline-arch-test",
+		Endpoint:    srv.URL,
+		SampleRatio: ratio,
+	})
+	require.NoError(t, err)
+	return c, func() { require.NoError(t, shutdown(context.Background())) }
+}
+
+func newTracedRouter(t *testing.T, log *zerolog.Logger) http.Handler {
+	svc := services.NewUserService(repository.NewTracingUserRepository(repository.NewInMemoryUserRepository()), log, nil)
+
+	r := chi.NewRouter()
+	r.Use(api.RequestContext(log))
+	r.Use(api.Tracing)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, err := svc.GetUser(r.Context(), chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	r.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		_, err := svc.CreateUser(r.Context(), &models.UserCreateRequest{Email: "traced@example.com", Name: "Traced", Role: "viewer"})
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusCreated)
+	})
+	return r
+}
+
+func TestTracingExportsSpans(t *testing.T) {
+	c, flush := setupTracing(t, 1)
+	var buf bytes.Buffer
+	log := zerolog.New(&buf)
+	router := newTracedRouter(t, &log)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	flush()
+
+	server := c.span("GET /users/{id}")
+	require.NotNil(t, server, "server span exported")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(server.TraceId))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.ParentSpanId))
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, server.Kind)
+	assert.Equal(t, "/users/{id}", spanAttribute(server, "http.route"))
+
+	// The response and the logs name the exported span
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+hex.EncodeToString(server.SpanId)+"-01", rec.Header().Get("traceparent"))
+	assert.Contains(t, buf.String(), `"span_id":"`+hex.EncodeToString(server.SpanId)+`"`)
+
+	service := c.span("UserService.GetUser")
+	require.NotNil(t, service)
+	assert.Equal(t, server.SpanId, service.ParentSpanId)
+	assert.Equal(t, "42", spanAttribute(service, "user.id"))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, service.Status.Code)
+
+	repo := c.span("UserRepository.GetByID")
+	require.NotNil(t, repo)
+	assert.Equal(t, service.SpanId, repo.ParentSpanId)
+	assert.Equal(t, server.TraceId, repo.TraceId)
+}
+
+func TestTracingSampling(t *testing.T) {
+	c, flush := setupTracing(t, 0)
+	log := logger.New("debug").Logger
+	router := newTracedRouter(t, log)
+
+	// New traces are not sampled at ratio 0
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users", nil))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.True(t, strings.HasSuffix(rec.Header().Get("traceparent"), "-00"))
+
+	// A sampled caller's trace is followed
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	flush()
+
+	assert.Nil(t, c.span("POST /users"))
+	assert.Nil(t, c.span("UserService.CreateUser"))
+	assert.NotNil(t, c.span("GET /users/{id}"))
+	assert.NotNil(t, c.span("UserService.GetUser"))
+}
+
+func TestRedactSQL(t *testing.T) {
+	assert.Equal(t,
+		"SELECT id FROM users WHERE email = ? AND active = true LIMIT ? OFFSET $2",
+		tracing.RedactSQL("\n\t\tSELECT id FROM users\n\t\tWHERE email = 'a''b@example.com' AND active = true\n\t\tLIMIT 10 OFFSET $2\n\t"),
+	)
+	assert.Equal(t, "UPDATE users2 SET score = ? WHERE id = $1", tracing.RedactSQL("UPDATE users2 SET score = 1.5 WHERE id = $1"))
+}
+
+func TestTracingSetupValidation(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Options{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = tracing.Setup(context.Background(), tracing.Options{Endpoint: "otel-collector:4318", SampleRatio: 1})
+	assert.Error(t, err)
+	_, err = tracing.Setup(context.Background(), tracing.Options{Endpoint: "http://otel-collector:4318", SampleRatio: 2})
+	assert.Error(t, err)
+}